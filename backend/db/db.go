@@ -17,8 +17,24 @@ func Connect() {
     }
 
     // Auto migrate the schema
-    database.AutoMigrate(&models.Order{}, &models.Asset{})
+    if err := Migrate(database); err != nil {
+        log.Fatalf("failed to migrate database: %v", err)
+    }
 
     DB = database
     log.Println("Database connected and migrated successfully")
 }
+
+// Migrate creates or updates the tables of every model
+func Migrate(database *gorm.DB) error {
+    return database.AutoMigrate(
+        &models.Order{}, &models.OrderLine{}, &models.Asset{}, &models.OrderEvent{}, &models.IdempotencyKey{}, &models.OrderDiscount{}, &models.OrderLineTax{},
+        &models.Quote{}, &models.QuoteLine{}, &models.QuoteDiscount{}, &models.Payment{},
+        &models.InventoryItem{}, &models.StockMovement{}, &models.StockAlert{}, &models.ItemCost{},
+        &models.Supplier{}, &models.PurchaseOrder{}, &models.PurchaseOrderLine{},
+        &models.Location{}, &models.LocationStock{},
+        &models.Customer{}, &models.CustomerAddress{},
+        &models.CycleCount{}, &models.CycleCountLine{},
+        &models.Consumable{}, &models.ConsumableRate{}, &models.ConsumableMovement{},
+    )
+}
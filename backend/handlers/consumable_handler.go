@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+type CreateConsumableInput struct {
+	Name         string  `json:"name"`
+	Unit         string  `json:"unit"`
+	ReorderPoint float64 `json:"reorderPoint"`
+}
+
+type ConsumableMovementInput struct {
+	Quantity float64 `json:"quantity"`
+	UnitCost float64 `json:"unitCost"`
+	Note     string  `json:"note"`
+}
+
+type ConsumableRateInput struct {
+	Product     string  `json:"product"`
+	Placement   string  `json:"placement"`
+	PerPrint    float64 `json:"perPrint"`
+	PerSquareCm float64 `json:"perSquareCm"`
+}
+
+func ListConsumables(c *gin.Context) {
+	var consumables []models.Consumable
+	db.DB.Order("name").Find(&consumables)
+	c.JSON(http.StatusOK, consumables)
+}
+
+func CreateConsumable(c *gin.Context) {
+	var input CreateConsumableInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	consumable, err := services.CreateConsumable(db.DB, input.Name, input.Unit, input.ReorderPoint)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, consumable)
+}
+
+// GetConsumable returns a consumable with its usage rates and recent movements
+func GetConsumable(c *gin.Context) {
+	var consumable models.Consumable
+	if err := db.DB.First(&consumable, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "consumable not found"})
+		return
+	}
+
+	var rates []models.ConsumableRate
+	db.DB.Where("consumable_id = ?", consumable.ID).Find(&rates)
+	var movements []models.ConsumableMovement
+	db.DB.Where("consumable_id = ?", consumable.ID).Order("id desc").Limit(100).Find(&movements)
+
+	c.JSON(http.StatusOK, gin.H{
+		"consumable": consumable,
+		"rates":      rates,
+		"movements":  movements,
+	})
+}
+
+// ReceiveConsumable books a delivery of a consumable at a unit cost
+func ReceiveConsumable(c *gin.Context) {
+	postConsumableMovement(c, func(tx *gorm.DB, id uint, input ConsumableMovementInput) (*models.Consumable, error) {
+		return services.ReceiveConsumable(tx, id, input.Quantity, input.UnitCost, input.Note)
+	})
+}
+
+// AdjustConsumable applies a signed correction to a consumable's on-hand quantity
+func AdjustConsumable(c *gin.Context) {
+	postConsumableMovement(c, func(tx *gorm.DB, id uint, input ConsumableMovementInput) (*models.Consumable, error) {
+		return services.AdjustConsumable(tx, id, input.Quantity, input.Note)
+	})
+}
+
+// SetConsumableRate sets how much of the consumable a print uses for a product and placement
+func SetConsumableRate(c *gin.Context) {
+	var consumable models.Consumable
+	if err := db.DB.First(&consumable, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "consumable not found"})
+		return
+	}
+
+	var input ConsumableRateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate, err := services.SetConsumableRate(db.DB, models.ConsumableRate{
+		ConsumableID: consumable.ID,
+		Product:      input.Product,
+		Placement:    input.Placement,
+		PerPrint:     input.PerPrint,
+		PerSquareCm:  input.PerSquareCm,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+// ForecastConsumables projects stock-outs from usage over the last ?days= (default 30)
+func ForecastConsumables(c *gin.Context) {
+	days := 30
+	if d := c.Query("days"); d != "" {
+		parsed, err := strconv.Atoi(d)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a number"})
+			return
+		}
+		days = parsed
+	}
+
+	forecasts, err := services.ForecastConsumables(db.DB, days)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"days":        days,
+		"consumables": forecasts,
+	})
+}
+
+// GetOrderConsumables returns consumables used by an order, or the estimate if it
+// has not reached fulfillment yet
+func GetOrderConsumables(c *gin.Context) {
+	var order models.Order
+	if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	}
+
+	usage, err := services.OrderConsumableUsage(db.DB, order.ID)
+	estimated := false
+	if err == nil && len(usage) == 0 {
+		usage, err = services.EstimateConsumables(db.DB, &order)
+		estimated = true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total := 0.0
+	for _, u := range usage {
+		total += u.Cost
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"order":       order.ID,
+		"estimated":   estimated,
+		"consumables": usage,
+		"totalCost":   total,
+	})
+}
+
+func postConsumableMovement(c *gin.Context, post func(tx *gorm.DB, id uint, input ConsumableMovementInput) (*models.Consumable, error)) {
+	var consumable models.Consumable
+	if err := db.DB.First(&consumable, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "consumable not found"})
+		return
+	}
+
+	var input ConsumableMovementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated *models.Consumable
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		updated, err = post(tx, consumable.ID, input)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
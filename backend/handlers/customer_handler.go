@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+// ListCustomers returns all customers with their addresses, optionally filtered by
+// ?q= matching name, company or email
+func ListCustomers(c *gin.Context) {
+	query := db.DB.Preload("Addresses").Order("name, company, id")
+	if q := c.Query("q"); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("name LIKE ? OR company LIKE ? OR email LIKE ?", like, like, like)
+	}
+
+	var customers []models.Customer
+	query.Find(&customers)
+	c.JSON(http.StatusOK, customers)
+}
+
+func CreateCustomer(c *gin.Context) {
+	var input services.CustomerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var customer *models.Customer
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		customer, err = services.CreateCustomer(tx, input)
+		return err
+	})
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, customer)
+}
+
+func GetCustomer(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	customer, err := services.GetCustomer(db.DB, id)
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, customer)
+}
+
+// UpdateCustomer replaces a customer's contact details; addresses are managed separately
+func UpdateCustomer(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	var input services.CustomerInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	customer, err := services.UpdateCustomer(db.DB, id, input)
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, customer)
+}
+
+// DeleteCustomer removes a customer without orders
+func DeleteCustomer(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return services.DeleteCustomer(tx, id)
+	})
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListCustomerOrders returns a customer's orders, newest first
+func ListCustomerOrders(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	orders, err := services.CustomerOrders(db.DB, id)
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+func AddCustomerAddress(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	var input services.AddressInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var address *models.CustomerAddress
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		address, err = services.AddCustomerAddress(tx, id, input)
+		return err
+	})
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, address)
+}
+
+func UpdateCustomerAddress(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	addressID, err := strconv.ParseUint(c.Param("addressID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownAddress.Error()})
+		return
+	}
+	var input services.AddressInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var address *models.CustomerAddress
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		address, err = services.UpdateCustomerAddress(tx, id, uint(addressID), input)
+		return err
+	})
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, address)
+}
+
+// DeleteCustomerAddress removes an address that no order ships to
+func DeleteCustomerAddress(c *gin.Context) {
+	id, ok := customerID(c)
+	if !ok {
+		return
+	}
+	addressID, err := strconv.ParseUint(c.Param("addressID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownAddress.Error()})
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		return services.DeleteCustomerAddress(tx, id, uint(addressID))
+	})
+	if err != nil {
+		customerError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func customerID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("ID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownCustomer.Error()})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// customerError maps customer service errors to HTTP statuses
+func customerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownCustomer), errors.Is(err, services.ErrUnknownAddress):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCustomer):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCustomerInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+type RecordCountsInput struct {
+	Lines []services.CountInput `json:"lines"`
+}
+
+func ListCycleCounts(c *gin.Context) {
+	query := db.DB.Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var counts []models.CycleCount
+	query.Find(&counts)
+	c.JSON(http.StatusOK, counts)
+}
+
+// CreateCycleCount generates a count sheet for a location, optionally limited to some SKUs
+func CreateCycleCount(c *gin.Context) {
+	var input services.CycleCountScope
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count *models.CycleCount
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		count, err = services.CreateCycleCount(tx, input)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, count)
+}
+
+func GetCycleCount(c *gin.Context) {
+	count, ok := loadCycleCount(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, count)
+}
+
+// GetCycleCountVariances returns only the counted lines that differ from the snapshot
+func GetCycleCountVariances(c *gin.Context) {
+	count, ok := loadCycleCount(c)
+	if !ok {
+		return
+	}
+
+	variances := []models.CycleCountLine{}
+	for _, line := range count.Lines {
+		if line.CountedQuantity != nil && line.Variance != 0 {
+			variances = append(variances, line)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cycleCount": count.ID,
+		"variances":  variances,
+	})
+}
+
+// GetCycleCountSheet downloads the count sheet as PDF (default) or ?format=csv
+func GetCycleCountSheet(c *gin.Context) {
+	count, ok := loadCycleCount(c)
+	if !ok {
+		return
+	}
+
+	var location models.Location
+	if err := db.DB.First(&location, count.LocationID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "count location not found"})
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	contentType, ext := "application/pdf", "pdf"
+	if c.Query("format") == "csv" {
+		contentType, ext = "text/csv", "csv"
+		err = services.WriteCountSheetCSV(&buf, count, &location)
+	} else {
+		err = services.WriteCountSheetPDF(&buf, count, &location)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("count sheet generation failed: %v", err)})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=cycle_count_%d.%s", count.ID, ext))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// RecordCycleCounts stores counted quantities for count sheet lines
+func RecordCycleCounts(c *gin.Context) {
+	var input RecordCountsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updateCycleCount(c, func(tx *gorm.DB, count *models.CycleCount) error {
+		return services.RecordCounts(tx, count, input.Lines)
+	})
+}
+
+// PostCycleCount books the count's variances as stock adjustments
+func PostCycleCount(c *gin.Context) {
+	updateCycleCount(c, func(tx *gorm.DB, count *models.CycleCount) error {
+		return services.PostCycleCount(tx, count)
+	})
+}
+
+func loadCycleCount(c *gin.Context) (*models.CycleCount, bool) {
+	var count models.CycleCount
+	if err := db.DB.Preload("Lines").First(&count, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cycle count not found"})
+		return nil, false
+	}
+	return &count, true
+}
+
+func updateCycleCount(c *gin.Context, update func(tx *gorm.DB, count *models.CycleCount) error) {
+	count, ok := loadCycleCount(c)
+	if !ok {
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return update(tx, count)
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrCycleCountPosted) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, count)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"printflow/db"
+	"printflow/services"
+)
+
+// ExportOrders streams every order matching the list filters (see ListOrders; limit
+// and offset are ignored) as format=csv (default), ndjson or xlsx. CSV and XLSX have
+// one row per order line; NDJSON has one order per line with its lines and assets.
+func ExportOrders(c *gin.Context) {
+	query, err := orderQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", services.ExportCSV))
+	if format == "jsonl" {
+		format = services.ExportNDJSON
+	}
+
+	filename := fmt.Sprintf("orders-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Type", services.ExportContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	err = services.ExportOrders(db.DB, query, services.ExportOptions{Format: format, BaseURL: requestBaseURL(c)}, c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// Too late for an error status; the client sees a truncated file
+		log.Printf("Order export failed part way: %v", err)
+		return
+	}
+	// c.JSON keeps a Content-Type that is already set
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	if errors.Is(err, services.ErrInvalidOrderQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// requestBaseURL is the scheme and host the client reached the API on. X-Forwarded-Proto
+// is only believed from a trusted proxy, since anyone else could set it.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(c.GetHeader("X-Forwarded-Proto")); (proto == "http" || proto == "https") && fromTrustedProxy(c) {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// TrustedProxies returns the comma-separated addresses and CIDR ranges in
+// TRUSTED_PROXIES, the reverse proxies whose forwarding headers are believed
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// fromTrustedProxy reports whether the request came straight from a trusted proxy
+func fromTrustedProxy(c *gin.Context) bool {
+	remote, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	remote = remote.Unmap()
+	for _, proxy := range TrustedProxies() {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil && prefix.Contains(remote) {
+			return true
+		}
+		if addr, err := netip.ParseAddr(proxy); err == nil && addr.Unmap() == remote {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// X-Forwarded-Proto only changes asset links when the request came from a proxy in
+// TRUSTED_PROXIES. Test requests come from 192.0.2.1.
+func TestBaseURLTrustsForwardedProtoOnlyFromProxies(t *testing.T) {
+	cases := []struct {
+		name, proxies, proto, want string
+	}{
+		{"no proxies", "", "https", "http://example.com"},
+		{"untrusted client", "10.0.0.0/8", "https", "http://example.com"},
+		{"trusted range", "10.0.0.0/8, 192.0.2.0/24", "https", "https://example.com"},
+		{"trusted address", "192.0.2.1", "https", "https://example.com"},
+		{"unknown scheme", "192.0.2.1", "javascript", "http://example.com"},
+	}
+	baseURL := func(c *gin.Context) { c.String(http.StatusOK, requestBaseURL(c)) }
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tc.proxies)
+			w := serve(http.MethodGet, "/base", "/base", baseURL, map[string]string{"X-Forwarded-Proto": tc.proto}, "")
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
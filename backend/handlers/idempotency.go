@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"printflow/db"
+	"printflow/services"
+)
+
+// maxIdempotencyKeyLength keeps keys to the size of a UUID or a storefront's own ids
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder copies the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent makes a route safe to retry. A request with an Idempotency-Key header
+// runs once; repeating it with the same key and body replays the first response,
+// while reusing the key for a different body is rejected with 422. Conflicts (409,
+// 428) and server errors are not stored, so the request can be retried with the same key.
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "could not read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		scope := c.Request.Method + " " + c.Request.URL.Path
+
+		stored, err := services.BeginIdempotentRequest(db.DB, key, scope, hex.EncodeToString(sum[:]), services.IdempotencyRetention())
+		switch {
+		case errors.Is(err, services.ErrIdempotencyMismatch):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		case stored != nil:
+			if stored.ETag != "" {
+				c.Header("ETag", stored.ETag)
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.StatusCode, "application/json; charset=utf-8", stored.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusConflict || status == http.StatusPreconditionRequired {
+			err = services.AbandonIdempotentRequest(db.DB, key, scope)
+		} else {
+			err = services.CompleteIdempotentRequest(db.DB, key, scope, status, recorder.Header().Get("ETag"), recorder.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Storing idempotent response for %s (%s) failed: %v", scope, key, err)
+		}
+	}
+}
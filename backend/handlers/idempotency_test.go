@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotentRequestsReplay(t *testing.T) {
+	useTestDB(t)
+	calls := 0
+	status := http.StatusCreated
+	r := gin.New()
+	r.POST("/payments/:ID", Idempotent(), func(c *gin.Context) {
+		calls++
+		c.Header("ETag", `"2"`)
+		c.JSON(status, gin.H{"call": calls})
+	})
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := send("/payments/1", "k1", `{"amount": 10}`)
+	replay := send("/payments/1", "k1", `{"amount": 10}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+	if replay.Code != first.Code || replay.Body.String() != first.Body.String() ||
+		replay.Header().Get("ETag") != `"2"` || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay %d %s (ETag %q), want the first response %d %s marked replayed",
+			replay.Code, replay.Body, replay.Header().Get("ETag"), first.Code, first.Body)
+	}
+
+	// The same key with another body is a client bug; on another route it is unrelated
+	if w := send("/payments/1", "k1", `{"amount": 20}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with a new body: got %d, want 422: %s", w.Code, w.Body)
+	}
+	if w := send("/payments/2", "k1", `{"amount": 10}`); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("same key on another order: got %d after %d calls, want it run", w.Code, calls)
+	}
+
+	// Conflicts are not stored, so the request can be retried once resolved
+	status = http.StatusConflict
+	send("/payments/3", "k2", `{}`)
+	status = http.StatusCreated
+	if w := send("/payments/3", "k2", `{}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after a conflict: got %d replayed %q, want it run again", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/services"
+)
+
+// errDryRun rolls back a dry-run import once it has been validated
+var errDryRun = errors.New("dry run")
+
+// ImportOrders creates orders in bulk from CSV or a JSON array, sent as the request
+// body or as a multipart "file". The format comes from ?format=csv|json, the file
+// extension or the Content-Type. With ?dryRun=true every row is checked but nothing is
+// saved. Either all orders are created or, if any row fails, none are.
+func ImportOrders(c *gin.Context) {
+	body := io.Reader(c.Request.Body)
+	format := strings.ToLower(c.Query("format"))
+	if file, header, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		body = file
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+	}
+	if format == "" {
+		switch c.ContentType() {
+		case "text/csv", "application/csv", "text/plain":
+			format = "csv"
+		case "application/json":
+			format = "json"
+		}
+	}
+
+	var rows []services.ImportRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = services.ParseImportCSV(body)
+	case "json":
+		rows, err = services.ParseImportJSON(body)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send CSV or JSON, or set format=csv or format=json"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options := services.ImportOptions{DryRun: c.Query("dryRun") == "true", UploadDir: "uploads"}
+	// Logos download before the transaction opens so a slow host cannot hold the database
+	options.Logos = services.FetchImportLogos(rows, options)
+	var result *services.ImportResult
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.ImportOrders(tx, rows, options, transitionMeta(c, "imported"))
+		if err == nil && options.DryRun {
+			return errDryRun
+		}
+		return err
+	})
+	if err != nil {
+		options.Logos.RemoveDownloads()
+	}
+	switch {
+	case err == nil:
+		c.JSON(http.StatusCreated, result)
+	case errors.Is(err, errDryRun):
+		c.JSON(http.StatusOK, result)
+	case errors.Is(err, services.ErrImportRejected):
+		c.JSON(http.StatusUnprocessableEntity, result)
+	case errors.Is(err, services.ErrInvalidImport):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+type CreateInventoryItemInput struct {
+	Product string `json:"product"`
+	Color   string `json:"color"`
+	Size    string `json:"size"`
+}
+
+type StockMovementInput struct {
+	Quantity   int     `json:"quantity"`
+	LocationID uint    `json:"locationId"`
+	Reason     string  `json:"reason"`
+	UnitCost   float64 `json:"unitCost"`
+	Reference  string  `json:"reference"`
+	Note       string  `json:"note"`
+}
+
+// ListInventory returns all SKUs, optionally filtered by product, color or size
+func ListInventory(c *gin.Context) {
+	query := db.DB.Order("sku")
+	if product := c.Query("product"); product != "" {
+		query = query.Where("LOWER(product) = LOWER(?)", product)
+	}
+	if color := c.Query("color"); color != "" {
+		query = query.Where("LOWER(color) = LOWER(?)", color)
+	}
+	if size := c.Query("size"); size != "" {
+		query = query.Where("LOWER(size) = LOWER(?)", size)
+	}
+
+	var items []models.InventoryItem
+	query.Find(&items)
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateInventoryItem registers a new blank garment SKU
+func CreateInventoryItem(c *gin.Context) {
+	var input CreateInventoryItemInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, err := services.CreateInventoryItem(db.DB, input.Product, input.Color, input.Size)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrDuplicateSKU):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrIncompleteSKU):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, item)
+}
+
+// GetInventoryItem returns a single SKU with its current stock levels
+func GetInventoryItem(c *gin.Context) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// ListStockMovements returns the ledger entries for a SKU, newest first
+func ListStockMovements(c *gin.Context) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+
+	var movements []models.StockMovement
+	db.DB.Where("inventory_item_id = ?", item.ID).Order("id desc").Find(&movements)
+	c.JSON(http.StatusOK, gin.H{
+		"item":      item,
+		"movements": movements,
+	})
+}
+
+// ReceiveStock books incoming blanks into on-hand stock
+func ReceiveStock(c *gin.Context) {
+	postStockMovement(c, services.ReceiveStock)
+}
+
+// AdjustStock applies a signed correction to on-hand stock with a reason code
+func AdjustStock(c *gin.Context) {
+	postStockMovement(c, services.AdjustStock)
+}
+
+type movementFunc func(tx *gorm.DB, itemID uint, input services.MovementInput) (*models.InventoryItem, error)
+
+func postStockMovement(c *gin.Context, post movementFunc) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+
+	var input StockMovementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated *models.InventoryItem
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		updated, err = post(tx, item.ID, services.MovementInput{
+			Quantity:   input.Quantity,
+			LocationID: input.LocationID,
+			Reason:     input.Reason,
+			UnitCost:   input.UnitCost,
+			Reference:  input.Reference,
+			Note:       input.Note,
+		})
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+type ReorderPolicyInput struct {
+	ReorderPoint    int   `json:"reorderPoint"`
+	ReorderQuantity int   `json:"reorderQuantity"`
+	SupplierID      *uint `json:"supplierId"`
+}
+
+// SetReorderPolicy configures the reorder point and quantity for a SKU
+func SetReorderPolicy(c *gin.Context) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+
+	var input ReorderPolicyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.SupplierID != nil {
+		if err := db.DB.First(&models.Supplier{}, *input.SupplierID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "supplier not found"})
+			return
+		}
+	}
+
+	updated, err := services.SetReorderPolicy(db.DB, item.ID, input.ReorderPoint, input.ReorderQuantity, input.SupplierID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// LowStockReport lists SKUs at or below their reorder point
+func LowStockReport(c *gin.Context) {
+	lines, err := services.LowStockReport(db.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": lines})
+}
+
+// ListStockAlerts returns low-stock alerts, unacknowledged only unless ?all=true
+func ListStockAlerts(c *gin.Context) {
+	query := db.DB.Order("id desc")
+	if c.Query("all") != "true" {
+		query = query.Where("acknowledged = ?", false)
+	}
+
+	var alerts []models.StockAlert
+	query.Find(&alerts)
+	c.JSON(http.StatusOK, alerts)
+}
+
+// AcknowledgeStockAlert marks a low-stock alert as seen
+func AcknowledgeStockAlert(c *gin.Context) {
+	var alert models.StockAlert
+	if err := db.DB.First(&alert, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert not found"})
+		return
+	}
+
+	alert.Acknowledged = true
+	db.DB.Save(&alert)
+	c.JSON(http.StatusOK, alert)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+type CreateLocationInput struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	City     string `json:"city"`
+	State    string `json:"state"`
+	Zip      string `json:"zip"`
+	Priority int    `json:"priority"`
+}
+
+type TransferStockInput struct {
+	FromLocationID uint   `json:"fromLocationId"`
+	ToLocationID   uint   `json:"toLocationId"`
+	Quantity       int    `json:"quantity"`
+	Note           string `json:"note"`
+}
+
+func ListLocations(c *gin.Context) {
+	var locations []models.Location
+	db.DB.Order("priority, id").Find(&locations)
+	c.JSON(http.StatusOK, locations)
+}
+
+func CreateLocation(c *gin.Context) {
+	var input CreateLocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location, err := services.CreateLocation(db.DB, models.Location{
+		Code:     input.Code,
+		Name:     input.Name,
+		Address:  input.Address,
+		City:     input.City,
+		State:    input.State,
+		Zip:      input.Zip,
+		Priority: input.Priority,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, location)
+}
+
+// GetLocationStock returns every SKU's stock level at a location
+func GetLocationStock(c *gin.Context) {
+	var location models.Location
+	if err := db.DB.First(&location, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "location not found"})
+		return
+	}
+
+	var stock []models.LocationStock
+	db.DB.Where("location_id = ?", location.ID).Order("inventory_item_id").Find(&stock)
+	c.JSON(http.StatusOK, gin.H{
+		"location": location,
+		"stock":    stock,
+	})
+}
+
+// GetInventoryItemLocations returns a SKU's stock level at each location
+func GetInventoryItemLocations(c *gin.Context) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+
+	var stock []models.LocationStock
+	db.DB.Where("inventory_item_id = ?", item.ID).Order("location_id").Find(&stock)
+	c.JSON(http.StatusOK, gin.H{
+		"item":      item,
+		"locations": stock,
+	})
+}
+
+// TransferStock moves on-hand stock of a SKU between locations
+func TransferStock(c *gin.Context) {
+	var item models.InventoryItem
+	if err := db.DB.First(&item, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "inventory item not found"})
+		return
+	}
+
+	var input TransferStockInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var updated *models.InventoryItem
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		updated, err = services.TransferStock(tx, item.ID, input.FromLocationID, input.ToLocationID, input.Quantity, input.Note)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+	"printflow/testutil"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := services.LoadWorkflows(testutil.ConfigDir + services.DefaultWorkflowFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.LoadPricing(testutil.ConfigDir + services.DefaultPricingFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.LoadTaxRates(testutil.ConfigDir + services.DefaultTaxFile); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// useTestDB points db.DB at an empty database with the main location for one test
+func useTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database := testutil.NewDB(t)
+	if err := services.EnsureDefaultLocation(database); err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = database
+	t.Cleanup(func() { db.DB = previous })
+	return database
+}
+
+// createTestOrder saves an order with one line of 10 garments, priced at total, after
+// receiving fifty of its blanks at the main location. Orders ready for fulfillment
+// have consumed their blanks.
+func createTestOrder(t *testing.T, tx *gorm.DB, status string, total float64) *models.Order {
+	t.Helper()
+	if _, err := services.FindInventoryItem(tx, "T-Shirt", "black", "M"); err != nil {
+		item, err := services.CreateInventoryItem(tx, "T-Shirt", "black", "M")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := services.ReceiveStock(tx, item.ID, services.MovementInput{Quantity: 50, UnitCost: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	order := testutil.CreateOrder(t, tx, status, total)
+	if status == models.StatusReady {
+		if err := services.ReserveForOrder(tx, order); err != nil {
+			t.Fatal(err)
+		}
+		if err := services.ConsumeForOrder(tx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return order
+}
+
+// serve sends one request through a router with route registered for handler
+func serve(method, route, path string, handler gin.HandlerFunc, headers map[string]string, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, handler)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
@@ -1,22 +1,69 @@
 package handlers
 
 import (
+    "encoding/json"
+    "errors"
     "fmt"
+    "log"
     "net/http"
+    "strconv"
+    "strings"
 
     "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
     "printflow/db"
     "printflow/models"
     "printflow/services"
 )
 
+// CreateOrderInput takes either line items or a single garment (product, color and
+// size or sizeRun) for simple one-line orders
 type CreateOrderInput struct {
-    Product   string `json:"product"`
-    Color     string `json:"color"`
-    Size      string `json:"size"`
-    LogoURL   string `json:"logoUrl"`
-    AIPrompt  string `json:"aiPrompt"`
-    UseAI     bool   `json:"useAI"`
+    Lines      []services.OrderLineInput `json:"lines"`
+    Product    string                    `json:"product"`
+    Color      string                    `json:"color"`
+    Size       string                    `json:"size"`
+    Quantity   int                       `json:"quantity"`
+    SizeRun    string                    `json:"sizeRun"` // e.g. "S:4, M:10, L:8, XL:2"
+    Placements []string                  `json:"placements"`
+    LogoURL    string                    `json:"logoUrl"`
+    AIPrompt   string                    `json:"aiPrompt"`
+    UseAI      bool                      `json:"useAI"`
+    LogoColors int                       `json:"logoColors"`   // pricing: ink colors in the logo
+    PrintAreaCm2 float64                 `json:"printAreaCm2"` // pricing: printed area per placement
+    Rush       string                    `json:"rush"`         // optional rush option from the price table
+    DiscountCodes []string               `json:"discountCodes"` // optional codes for discounts in the price table
+    LocationID uint                      `json:"locationId"` // optional; chosen by stock and priority when omitted
+    CustomerID uint                      `json:"customerId"`
+    ShippingAddressID uint               `json:"shippingAddressId"` // optional; the customer's default shipping address when omitted
+}
+
+// orderInput turns the request body into the service's order input
+func (input CreateOrderInput) orderInput() services.OrderInput {
+    lines := input.Lines
+    if len(lines) == 0 {
+        lines = []services.OrderLineInput{{
+            Product:      input.Product,
+            Color:        input.Color,
+            Size:         input.Size,
+            Quantity:     input.Quantity,
+            SizeRun:      input.SizeRun,
+            Placements:   input.Placements,
+            LogoColors:   input.LogoColors,
+            PrintAreaCm2: input.PrintAreaCm2,
+        }}
+    }
+    return services.OrderInput{
+        Lines:      lines,
+        LocationID: input.LocationID,
+        CustomerID: input.CustomerID,
+        ShippingAddressID: input.ShippingAddressID,
+        LogoURL:    input.LogoURL,
+        AIPrompt:   input.AIPrompt,
+        UseAI:      input.UseAI,
+        Rush:       input.Rush,
+        DiscountCodes: input.DiscountCodes,
+    }
 }
 
 func CreateOrder(c *gin.Context) {
@@ -26,76 +73,542 @@ func CreateOrder(c *gin.Context) {
         return
     }
 
-    order := models.Order{
-        Product: input.Product,
-        Color:   input.Color,
-        Size:    input.Size,
-        Status:  models.StatusCreated,
+    var order *models.Order
+    err := db.DB.Transaction(func(tx *gorm.DB) error {
+        var err error
+        order, err = services.CreateOrder(tx, input.orderInput(), transitionMeta(c, ""))
+        return err
+    })
+    if err != nil {
+        createOrderError(c, err)
+        return
     }
 
-    if err := db.DB.Create(&order).Error; err != nil {
+    c.Header("ETag", services.OrderETag(order))
+    c.JSON(http.StatusCreated, gin.H{
+        "order": order,
+        "message": "Order created successfully. Click 'Generate Mockup' to create your design.",
+    })
+}
+
+// createOrderError reports why an order or quote could not be created
+func createOrderError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, services.ErrUnknownSKU), errors.Is(err, services.ErrInvalidOrder), errors.Is(err, services.ErrUnknownLocation),
+        errors.Is(err, services.ErrUnknownCustomer), errors.Is(err, services.ErrUnknownAddress), errors.Is(err, services.ErrInvalidCustomer):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    default:
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    }
+}
+
+// ListOrders returns a page of orders. Filters: status (comma-separated), product,
+// color, customerId, from and to (created date range), q (search), deleted=true for
+// deleted orders awaiting purge; sort is a field name such as createdAt or -createdAt;
+// limit and offset page the results.
+func ListOrders(c *gin.Context) {
+    query, err := orderQuery(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
+    limit, err := uintQuery(c, "limit")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    offset, err := uintQuery(c, "offset")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    query.Limit, query.Offset = int(limit), int(offset)
 
-    // Store the mockup request data for later generation
-    if input.UseAI || input.LogoURL != "" {
-        asset := models.Asset{
-            OrderID:     order.ID,
-            LogoURL:     input.LogoURL,
-            AIGenerated: input.UseAI,
-            AIPrompt:    input.AIPrompt,
-            MockupURL:   "", // Will be generated when user clicks "Generate Mockup"
+    page, err := services.ListOrders(db.DB, query)
+    if errors.Is(err, services.ErrInvalidOrderQuery) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, page)
+}
+
+// orderQuery reads the order list filters and sort shared by listing and exporting
+func orderQuery(c *gin.Context) (services.OrderQuery, error) {
+    query := services.OrderQuery{
+        Product: c.Query("product"),
+        Color:   c.Query("color"),
+        Search:  c.Query("q"),
+        Sort:    c.Query("sort"),
+        Deleted: c.Query("deleted") == "true",
+    }
+    for _, status := range c.QueryArray("status") {
+        for _, s := range strings.Split(status, ",") {
+            if s = strings.TrimSpace(s); s != "" {
+                query.Statuses = append(query.Statuses, strings.ToUpper(s))
+            }
         }
-        db.DB.Create(&asset)
     }
 
-    c.JSON(http.StatusCreated, gin.H{
-        "order": order,
-        "message": "Order created successfully. Click 'Generate Mockup' to create your design.",
-    })
+    var err error
+    if query.CustomerID, err = uintQuery(c, "customerId"); err != nil {
+        return query, err
+    }
+    if from := c.Query("from"); from != "" {
+        if query.From, err = services.ParseOrderDate(from, false); err != nil {
+            return query, err
+        }
+    }
+    if to := c.Query("to"); to != "" {
+        if query.To, err = services.ParseOrderDate(to, true); err != nil {
+            return query, err
+        }
+    }
+    return query, nil
 }
 
-func ListOrders(c *gin.Context) {
-    var orders []models.Order
-    db.DB.Find(&orders)
-    c.JSON(http.StatusOK, orders)
+// uintQuery reads an optional non-negative integer query parameter
+func uintQuery(c *gin.Context, name string) (uint, error) {
+    value := c.Query(name)
+    if value == "" {
+        return 0, nil
+    }
+    n, err := strconv.ParseUint(value, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("%s must be a non-negative integer", name)
+    }
+    return uint(n), nil
 }
 
 func GetOrder(c *gin.Context) {
     var order models.Order
-    if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
+    err := db.DB.Preload("Lines", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+        Preload("Lines.Taxes", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+        Preload("Discounts", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+        First(&order, c.Param("ID")).Error
+    if err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
         return
     }
     
+    assets, err := services.OrderAssets(db.DB, order.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    // "asset" is the first line's artwork, kept for clients that show a single mockup
     var asset models.Asset
-    // Don't return error if asset doesn't exist, just return empty asset
-    db.DB.Where("order_id = ?", order.ID).First(&asset)
+    if len(assets) > 0 {
+        asset = assets[0]
+    }
     
+    c.Header("ETag", services.OrderETag(&order))
     c.JSON(http.StatusOK, gin.H{
-        "order": order,
-        "asset": asset,
+        "order":  order,
+        "asset":  asset,
+        "assets": assets,
+    })
+}
+
+// UpdateOrderInput is the body of PATCH /orders/:ID. Only the fields present are changed.
+// Line fields at the top level edit a single-line order. Changing lines reprices the order.
+type UpdateOrderInput struct {
+    Lines             []services.OrderLinePatch `json:"lines"`
+    Product           *string                   `json:"product"`
+    Color             *string                   `json:"color"`
+    Size              *string                   `json:"size"`
+    Quantity          *int                      `json:"quantity"`
+    Placements        []string                  `json:"placements"`
+    LogoColors        *int                      `json:"logoColors"`
+    PrintAreaCm2      *float64                  `json:"printAreaCm2"`
+    CustomerID        *uint                     `json:"customerId"` // 0 unlinks the customer
+    ShippingAddressID *uint                     `json:"shippingAddressId"`
+}
+
+// UpdateOrder edits an order awaiting approval. Changing a line's product or color
+// drops its mockup and sends a MOCKUP_GENERATED order back to REVISION_REQUESTED.
+// Lines cannot be added or removed; such patches are rejected with 400.
+func UpdateOrder(c *gin.Context) {
+    var order models.Order
+    if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+        return
+    }
+    if !checkIfMatch(c, &order) {
+        return
+    }
+
+    // Unknown fields such as status are rejected rather than silently ignored
+    var input UpdateOrderInput
+    decoder := json.NewDecoder(c.Request.Body)
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid input: %v", err)})
+        return
+    }
+
+    patch := services.OrderPatch{
+        Lines:             input.Lines,
+        CustomerID:        input.CustomerID,
+        ShippingAddressID: input.ShippingAddressID,
+    }
+    if input.Product != nil || input.Color != nil || input.Size != nil || input.Quantity != nil || input.Placements != nil ||
+        input.LogoColors != nil || input.PrintAreaCm2 != nil {
+        patch.Lines = append([]services.OrderLinePatch{{
+            Product:      input.Product,
+            Color:        input.Color,
+            Size:         input.Size,
+            Quantity:     input.Quantity,
+            Placements:   input.Placements,
+            LogoColors:   input.LogoColors,
+            PrintAreaCm2: input.PrintAreaCm2,
+        }}, patch.Lines...)
+    }
+
+    from := order.Status
+    var invalidated bool
+    err := db.DB.Transaction(func(tx *gorm.DB) error {
+        var err error
+        invalidated, err = services.UpdateOrder(tx, &order, patch, transitionMeta(c, ""))
+        return err
     })
+    var validationErr *services.OrderValidationError
+    switch {
+    case errors.As(err, &validationErr):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fields": validationErr.Fields})
+        return
+    case errors.Is(err, services.ErrOrderLocked):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    case err != nil:
+        transitionError(c, err)
+        return
+    }
+    if order.Status != from {
+        services.RunAfterCommitHooks(db.DB, order, from)
+    }
+
+    c.Header("ETag", services.OrderETag(&order))
+    c.JSON(http.StatusOK, gin.H{"order": order, "mockupInvalidated": invalidated})
+}
+
+// DeleteOrder soft-deletes an order awaiting approval or cancelled. It can be restored
+// until the purge job removes it.
+func DeleteOrder(c *gin.Context) {
+    var order models.Order
+    if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+        return
+    }
+    if !checkIfMatch(c, &order) {
+        return
+    }
+
+    err := db.DB.Transaction(func(tx *gorm.DB) error {
+        return services.DeleteOrder(tx, &order)
+    })
+    if errors.Is(err, services.ErrOrderLocked) {
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        transitionError(c, err)
+        return
+    }
+    c.Status(http.StatusNoContent)
+}
+
+// RestoreOrder brings back a deleted order
+func RestoreOrder(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("ID"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+        return
+    }
+    order, err := services.DeletedOrder(db.DB, uint(id))
+    if errors.Is(err, services.ErrUnknownOrder) {
+        c.JSON(http.StatusNotFound, gin.H{"error": "deleted order not found"})
+        return
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    if !checkIfMatch(c, order) {
+        return
+    }
+
+    err = db.DB.Transaction(func(tx *gorm.DB) error {
+        return services.RestoreOrder(tx, order)
+    })
+    if err != nil {
+        transitionError(c, err)
+        return
+    }
+
+    c.Header("ETag", services.OrderETag(order))
+    c.JSON(http.StatusOK, order)
 }
 
 func ApproveOrder(c *gin.Context) {
+    transitionOrder(c, models.StatusApproved)
+}
+
+// CancelOrder cancels an order and releases any stock reserved for it
+func CancelOrder(c *gin.Context) {
+    transitionOrder(c, models.StatusCancelled)
+}
+
+// HoldOrder pauses an order until it is resumed
+func HoldOrder(c *gin.Context) {
+    transitionOrder(c, models.StatusOnHold)
+}
+
+// ResumeOrder returns a held order to the status it was held from
+func ResumeOrder(c *gin.Context) {
+    order, ok := loadOrder(c)
+    if !ok {
+        return
+    }
+    if order.Status != models.StatusOnHold {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "order is not on hold"})
+        return
+    }
+    applyOrderTransition(c, order, order.HeldFrom)
+}
+
+// RequestRevision records that the customer rejected the mockup so a new one can be generated
+func RequestRevision(c *gin.Context) {
+    transitionOrder(c, models.StatusRevisionRequested)
+}
+
+// MarkOrderDigitized records that an embroidery design has been digitized for the machines
+func MarkOrderDigitized(c *gin.Context) {
+    transitionOrder(c, models.StatusDigitized)
+}
+
+// MarkOrderReady releases an approved order to production, consuming its reserved blank
+func MarkOrderReady(c *gin.Context) {
+    transitionOrder(c, models.StatusReady)
+}
+
+// MarkOrderPrinted records that the order's print job is done
+func MarkOrderPrinted(c *gin.Context) {
+    transitionOrder(c, models.StatusPrinted)
+}
+
+// MarkOrderPacked records that the order is packed and waiting for pickup
+func MarkOrderPacked(c *gin.Context) {
+    transitionOrder(c, models.StatusPacked)
+}
+
+// MarkOrderShipped records that the order has left with the carrier
+func MarkOrderShipped(c *gin.Context) {
+    transitionOrder(c, models.StatusShipped)
+}
+
+// MarkOrderDelivered records that the carrier delivered the order
+func MarkOrderDelivered(c *gin.Context) {
+    transitionOrder(c, models.StatusDelivered)
+}
+
+// TransitionInput is the optional body of a status change endpoint
+type TransitionInput struct {
+    Reason string `json:"reason"`
+}
+
+// transitionOrder moves the order to newStatus together with its inventory side effects
+func transitionOrder(c *gin.Context, newStatus string) {
+    order, ok := loadOrder(c)
+    if !ok {
+        return
+    }
+    applyOrderTransition(c, order, newStatus)
+}
+
+// GetOrderHistory returns the order's status changes, oldest first
+func GetOrderHistory(c *gin.Context) {
+    order, ok := loadOrder(c)
+    if !ok {
+        return
+    }
+
+    events, err := services.OrderHistory(db.DB, order.ID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, events)
+}
+
+// transitionMeta captures who is changing an order and from where. The actor comes
+// from the X-Actor header since the API has no user accounts yet.
+func transitionMeta(c *gin.Context, reason string) services.TransitionMeta {
+    actor := c.GetHeader("X-Actor")
+    if actor == "" {
+        actor = "anonymous"
+    }
+    return services.TransitionMeta{
+        Actor:      actor,
+        Reason:     reason,
+        RemoteAddr: c.ClientIP(),
+        UserAgent:  c.Request.UserAgent(),
+        RequestID:  c.GetHeader("X-Request-ID"),
+    }
+}
+
+func loadOrder(c *gin.Context) (*models.Order, bool) {
     var order models.Order
     if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+        return nil, false
+    }
+    return &order, true
+}
+
+func applyOrderTransition(c *gin.Context, order *models.Order, newStatus string) {
+    if !checkIfMatch(c, order) {
         return
     }
 
-    if err := services.Transition(&order, models.StatusApproved); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    var input TransitionInput
+    // The reason is optional, so an empty body is fine
+    if c.Request.ContentLength > 0 {
+        if err := c.ShouldBindJSON(&input); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+    }
+
+    from := order.Status
+    err := db.DB.Transaction(func(tx *gorm.DB) error {
+        return services.ApplyTransition(tx, order, newStatus, transitionMeta(c, input.Reason))
+    })
+    if err != nil {
+        transitionError(c, err)
         return
     }
+    services.RunAfterCommitHooks(db.DB, *order, from)
 
-    db.DB.Save(&order)
+    c.Header("ETag", services.OrderETag(order))
     c.JSON(http.StatusOK, order)
 }
 
+// checkIfMatch requires the client to send the order's ETag in If-Match, so a change
+// made from a stale copy is rejected with 409 instead of overwriting someone else's
+func checkIfMatch(c *gin.Context, order *models.Order) bool {
+    ifMatch := c.GetHeader("If-Match")
+    if ifMatch == "" {
+        c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the order's ETag is required"})
+        return false
+    }
+    if !services.MatchesETag(ifMatch, order) {
+        c.Header("ETag", services.OrderETag(order))
+        c.JSON(http.StatusConflict, gin.H{"error": services.ErrVersionConflict.Error()})
+        return false
+    }
+    return true
+}
+
+// transitionError responds with the status code for a failed transition. Blocked
+// transitions list each guard that failed.
+func transitionError(c *gin.Context, err error) {
+    var guardErr *services.GuardError
+    switch {
+    case errors.As(err, &guardErr):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "guards": guardErr.Failures})
+    case errors.Is(err, services.ErrInvalidTransition):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, services.ErrVersionConflict), errors.Is(err, services.ErrInsufficientStock), errors.Is(err, services.ErrUnknownSKU):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    }
+}
+
+type MisprintInput struct {
+	LineID   uint   `json:"lineId"` // optional for single-line orders
+	Quantity int    `json:"quantity"`
+	Note     string `json:"note"`
+}
+
+// RecordMisprint writes off blanks ruined by a failed print job as a MISPRINT adjustment
+func RecordMisprint(c *gin.Context) {
+	var order models.Order
+	if err := db.DB.First(&order, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+		return
+	}
+	if !checkIfMatch(c, &order) {
+		return
+	}
+
+	input := MisprintInput{Quantity: 1}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	lines, err := services.LoadOrderLines(db.DB, &order)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var line *models.OrderLine
+	for i := range lines {
+		if lines[i].ID == input.LineID || (input.LineID == 0 && len(lines) == 1) {
+			line = &lines[i]
+		}
+	}
+	if line == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lineId must name one of the order's lines"})
+		return
+	}
+
+	var reprintReserved bool
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		reprintReserved, err = services.RecordMisprint(tx, &order, line, input.Quantity, input.Note)
+		if err != nil {
+			return err
+		}
+		// The order's stock changed, so copies of it are stale
+		return services.SaveOrder(tx, &order)
+	})
+	if err != nil {
+		misprintError(c, err)
+		return
+	}
+
+	c.Header("ETag", services.OrderETag(&order))
+	c.JSON(http.StatusOK, gin.H{
+		"order":           order,
+		"reprintReserved": reprintReserved,
+	})
+}
+
+// misprintError reports why a misprint could not be recorded: bad input is a 400, an
+// order that is not being printed or short of stock a 409
+func misprintError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMisprint), errors.Is(err, services.ErrUnknownLocation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOrderLocked), errors.Is(err, services.ErrNegativeStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		transitionError(c, err)
+	}
+}
+
+// MockupInput sets the artwork to render. Without a logo or prompt each asset's stored
+// artwork is used; without an assetId every product and color on the order is rendered.
 type MockupInput struct {
+	AssetID  uint   `json:"assetId"`
 	LogoURL  string `json:"logoUrl"`
 	AIPrompt string `json:"aiPrompt"`
 	UseAI    bool   `json:"useAI"`
@@ -107,6 +620,16 @@ func GenerateMockupHandler(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
 		return
 	}
+	if !checkIfMatch(c, &order) {
+		return
+	}
+
+	// Load the assets now so a concurrent regeneration is caught when they are saved
+	assets, err := services.OrderAssets(db.DB, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	var input MockupInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -114,16 +637,88 @@ func GenerateMockupHandler(c *gin.Context) {
 		return
 	}
 
+	var targets []*models.Asset
+	for i := range assets {
+		if input.AssetID == 0 || assets[i].ID == input.AssetID {
+			targets = append(targets, &assets[i])
+		}
+	}
+	if len(targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "assetId must name one of the order's assets"})
+		return
+	}
+
+	// A mockup can be regenerated in place; otherwise the order must be able to move to MOCKUP_GENERATED
+	regenerate := order.Status == models.StatusMockupGenerated
+	if !regenerate {
+		if err := services.CanTransition(db.DB, &order, models.StatusMockupGenerated); err != nil {
+			transitionError(c, err)
+			return
+		}
+	}
+
+	for _, asset := range targets {
+		if input.LogoURL != "" || input.AIPrompt != "" {
+			asset.LogoURL = input.LogoURL
+			asset.AIPrompt = input.AIPrompt
+			asset.AIGenerated = input.UseAI
+		}
+		if err := renderMockup(&order, asset); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate mockup"})
+			return
+		}
+	}
+
+	// The order moves on once every product and color has a mockup
+	complete := true
+	for _, asset := range assets {
+		if asset.MockupURL == "" {
+			complete = false
+		}
+	}
+
+	// Save the assets and move the order together so neither is left half-updated
+	from := order.Status
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, asset := range targets {
+			if err := services.SaveAsset(tx, asset); err != nil {
+				return err
+			}
+		}
+
+		if regenerate || !complete {
+			// New artwork still changes what the customer approves, so bump the order's version
+			return services.SaveOrder(tx, &order)
+		}
+		return services.ApplyTransition(tx, &order, models.StatusMockupGenerated, transitionMeta(c, ""))
+	})
+	if err != nil {
+		transitionError(c, err)
+		return
+	}
+	services.RunAfterCommitHooks(db.DB, order, from)
+
+	c.Header("ETag", services.OrderETag(&order))
+	c.JSON(http.StatusOK, gin.H{
+		"order":  order,
+		"asset":  targets[0],
+		"assets": assets,
+		"mockup": targets[0].MockupURL,
+	})
+}
+
+// renderMockup draws the asset's artwork on its garment and measures the printed logo
+// so consumable usage can be estimated
+func renderMockup(order *models.Order, asset *models.Asset) error {
 	var mockupURL string
 	var err error
 
-	if input.UseAI && input.AIPrompt != "" {
+	if asset.AIGenerated && asset.AIPrompt != "" {
 		// Generate AI-powered mockup
 		aiRequest := services.AIPromptRequest{
-			Prompt:  input.AIPrompt,
-			Product: order.Product,
-			Color:   order.Color,
-			Size:    order.Size,
+			Prompt:  asset.AIPrompt,
+			Product: asset.Product,
+			Color:   asset.Color,
 		}
 		
 		mockupURL, err = services.GenerateAIMockup(order.ID, aiRequest)
@@ -134,57 +729,28 @@ func GenerateMockupHandler(c *gin.Context) {
 			if err != nil {
 				// Final fallback to simple mockup
 				fmt.Printf("AI fallback failed: %v, using simple mockup\n", err)
-				mockupURL, err = services.GenerateMockupWithProduct(order.ID, input.LogoURL, order.Product, order.Color)
+				mockupURL, err = services.GenerateMockupWithProduct(order.ID, asset.LogoURL, asset.Product, asset.Color)
 			}
 		}
 	} else {
 		// Generate traditional mockup with logo
-		mockupURL, err = services.GenerateMockupWithProduct(order.ID, input.LogoURL, order.Product, order.Color)
+		mockupURL, err = services.GenerateMockupWithProduct(order.ID, asset.LogoURL, asset.Product, asset.Color)
 	}
-
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate mockup"})
-		return
+		return err
 	}
 
-	// Update or create asset
-	var asset models.Asset
-	result := db.DB.Where("order_id = ?", order.ID).First(&asset)
-	
-	if result.Error != nil {
-		// Create new asset
-		asset = models.Asset{
-			OrderID:     order.ID,
-			LogoURL:     input.LogoURL,
-			MockupURL:   mockupURL,
-			AIGenerated: input.UseAI,
-			AIPrompt:    input.AIPrompt,
-		}
-		if err := db.DB.Create(&asset).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create asset"})
-			return
-		}
-	} else {
-		// Update existing asset
-		asset.LogoURL = input.LogoURL
-		asset.MockupURL = mockupURL
-		asset.AIGenerated = input.UseAI
-		asset.AIPrompt = input.AIPrompt
-		if err := db.DB.Save(&asset).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update asset"})
-			return
+	var printArea services.PrintArea
+	if asset.LogoURL != "" && !asset.AIGenerated {
+		if printArea, err = services.MeasurePrintArea(asset.LogoURL, asset.Product); err != nil {
+			log.Printf("Print area measurement failed for order %d: %v", order.ID, err)
 		}
 	}
 
-	// Update order status
-	services.Transition(&order, models.StatusMockupGenerated)
-	db.DB.Save(&order)
-
-	c.JSON(http.StatusOK, gin.H{
-		"order":  order,
-		"asset":  asset,
-		"mockup": mockupURL,
-	})
+	asset.MockupURL = mockupURL
+	asset.PrintWidthCm = printArea.WidthCm
+	asset.PrintHeightCm = printArea.HeightCm
+	return nil
 }
 
 type LabelInput struct {
@@ -201,11 +767,18 @@ func GenerateLabel(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "order not found"})
 		return
 	}
+	if !services.ReachedStatus(&order, models.StatusReady) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("order must be %s before a label can be generated", models.StatusReady)})
+		return
+	}
 
+	// Without an address in the body the label ships to the order's stored address
 	var input LabelInput
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": "invalid input"})
-		return
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(400, gin.H{"error": "invalid input"})
+			return
+		}
 	}
 
 	// Convert handler LabelInput to services LabelInput
@@ -216,8 +789,32 @@ func GenerateLabel(c *gin.Context) {
 		State:   input.State,
 		Zip:     input.Zip,
 	}
+	if serviceInput.Address == "" {
+		stored, ok, err := services.OrderShipTo(db.DB, &order)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("shipping address lookup failed: %v", err)})
+			return
+		}
+		if ok {
+			serviceInput = stored
+		}
+	}
 
-	url, err := services.GenerateLabel(order.ID, serviceInput)
+	// Ship from the order's fulfilling location
+	location, err := services.FulfillingLocation(db.DB, &order)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("fulfilling location lookup failed: %v", err)})
+		return
+	}
+
+	// The label lists what is in the box
+	lines, err := services.LoadOrderLines(db.DB, &order)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("order lines lookup failed: %v", err)})
+		return
+	}
+
+	url, err := services.GenerateLabel(order.ID, serviceInput, services.ShipFromAddress(location), lines)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("label generation failed: %v", err)})
 		return
@@ -226,6 +823,11 @@ func GenerateLabel(c *gin.Context) {
 	c.JSON(200, gin.H{"label": url})
 }
 
+// ListWorkflows returns the order workflows loaded at startup
+func ListWorkflows(c *gin.Context) {
+	c.JSON(http.StatusOK, services.Workflows())
+}
+
 // GetAvailableColors returns list of available product colors
 func GetAvailableColors(c *gin.Context) {
 	colors := services.GetAvailableColors()
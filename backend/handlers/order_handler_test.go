@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"printflow/models"
+	"printflow/services"
+)
+
+// Every endpoint that changes an order refuses a request without the order's ETag,
+// and one made from a stale copy
+func TestMutatingOrderEndpointsRequireIfMatch(t *testing.T) {
+	endpoints := []struct {
+		name, route string
+		handler     gin.HandlerFunc
+		status      string
+		deleted     bool
+		body        string
+	}{
+		{"payment", "/orders/:ID/payments", RecordOrderPayment, models.StatusCreated, false, `{"amount": 10}`},
+		{"misprint", "/orders/:ID/misprint", RecordMisprint, models.StatusReady, false, `{"quantity": 1}`},
+		{"restore", "/orders/:ID/restore", RestoreOrder, models.StatusCreated, true, ""},
+	}
+	for _, e := range endpoints {
+		t.Run(e.name, func(t *testing.T) {
+			tx := useTestDB(t)
+			order := createTestOrder(t, tx, e.status, 100)
+			if e.deleted {
+				if err := services.DeleteOrder(tx, order); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := fmt.Sprintf("/orders/%d/%s", order.ID, e.route[len("/orders/:ID/"):])
+
+			w := serve(http.MethodPost, e.route, path, e.handler, nil, e.body)
+			if w.Code != http.StatusPreconditionRequired {
+				t.Errorf("without If-Match: got %d, want 428: %s", w.Code, w.Body)
+			}
+
+			stale := map[string]string{"If-Match": fmt.Sprintf(`"%d"`, order.Version-1)}
+			w = serve(http.MethodPost, e.route, path, e.handler, stale, e.body)
+			if w.Code != http.StatusConflict {
+				t.Errorf("with a stale If-Match: got %d, want 409: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("ETag"); got != services.OrderETag(order) {
+				t.Errorf("stale response ETag %q, want %q", got, services.OrderETag(order))
+			}
+
+			// If-Match compares strongly, so a weak tag for the current version is refused
+			weak := map[string]string{"If-Match": "W/" + services.OrderETag(order)}
+			w = serve(http.MethodPost, e.route, path, e.handler, weak, e.body)
+			if w.Code != http.StatusConflict {
+				t.Errorf("with a weak If-Match: got %d, want 409: %s", w.Code, w.Body)
+			}
+
+			current := map[string]string{"If-Match": services.OrderETag(order)}
+			w = serve(http.MethodPost, e.route, path, e.handler, current, e.body)
+			if w.Code >= 400 {
+				t.Errorf("with the current If-Match: got %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestRecordMisprintErrors(t *testing.T) {
+	cases := []struct {
+		name, status, body string
+		want               int
+	}{
+		{"not printing", models.StatusApproved, `{"quantity": 1}`, http.StatusConflict},
+		{"cancelled", models.StatusCancelled, `{"quantity": 1}`, http.StatusConflict},
+		{"zero quantity", models.StatusReady, `{"quantity": 0}`, http.StatusBadRequest},
+		{"more than the line", models.StatusReady, `{"quantity": 11}`, http.StatusBadRequest},
+		{"printing", models.StatusReady, `{"quantity": 2}`, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := useTestDB(t)
+			order := createTestOrder(t, tx, tc.status, 100)
+			headers := map[string]string{"If-Match": services.OrderETag(order)}
+			w := serve(http.MethodPost, "/orders/:ID/misprint", fmt.Sprintf("/orders/%d/misprint", order.ID), RecordMisprint, headers, tc.body)
+			if w.Code != tc.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestLabelsOnlyForOrdersReleasedToProduction(t *testing.T) {
+	for _, status := range []string{models.StatusCreated, models.StatusApproved, models.StatusCancelled} {
+		t.Run(status, func(t *testing.T) {
+			tx := useTestDB(t)
+			order := createTestOrder(t, tx, status, 100)
+			w := serve(http.MethodPost, "/orders/:ID/label", fmt.Sprintf("/orders/%d/label", order.ID), GenerateLabel, nil, "")
+			if w.Code != http.StatusConflict {
+				t.Errorf("got %d, want 409: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// A status change is recorded with who made it, why and from which request, and a
+// change that fails leaves no trace in the history
+func TestOrderHistoryRecordsEachStatusChange(t *testing.T) {
+	tx := useTestDB(t)
+	order := createTestOrder(t, tx, models.StatusCreated, 100)
+	path := fmt.Sprintf("/orders/%d", order.ID)
+
+	headers := map[string]string{"If-Match": services.OrderETag(order), "X-Actor": "sam", "X-Request-ID": "req-1"}
+	w := serve(http.MethodPost, "/orders/:ID/hold", path+"/hold", HoldOrder, headers, `{"reason": "artwork query"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("hold: got %d: %s", w.Code, w.Body)
+	}
+	order.Version++
+
+	headers = map[string]string{"If-Match": services.OrderETag(order)}
+	w = serve(http.MethodPost, "/orders/:ID/approve", path+"/approve", ApproveOrder, headers, "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("approving a held order: got %d, want 400: %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodGet, "/orders/:ID/history", path+"/history", GetOrderHistory, nil, "")
+	var events []models.OrderEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1: %s", len(events), w.Body)
+	}
+	e := events[0]
+	if e.FromStatus != models.StatusCreated || e.ToStatus != models.StatusOnHold || e.Actor != "sam" ||
+		e.Reason != "artwork query" || e.RequestID != "req-1" || e.CreatedAt.IsZero() {
+		t.Errorf("event %+v, want CREATED -> ON_HOLD by sam for the artwork query in req-1", e)
+	}
+}
+
+// A blocked approval lists every guard that failed, and a hook that fails leaves the
+// order as it was
+func TestApprovalGuardsAndHooksAreAtomic(t *testing.T) {
+	tx := useTestDB(t)
+	customer := models.Customer{Name: "Acme", DepositPercent: 50}
+	if err := tx.Create(&customer).Error; err != nil {
+		t.Fatal(err)
+	}
+	order := createTestOrder(t, tx, models.StatusMockupGenerated, 100)
+	order.CustomerID = &customer.ID
+	if err := tx.Save(order).Error; err != nil {
+		t.Fatal(err)
+	}
+	approve := func() *httptest.ResponseRecorder {
+		var current models.Order
+		tx.First(&current, order.ID)
+		headers := map[string]string{"If-Match": services.OrderETag(&current)}
+		return serve(http.MethodPost, "/orders/:ID/approve", fmt.Sprintf("/orders/%d/approve", order.ID), ApproveOrder, headers, "")
+	}
+
+	w := approve()
+	var blocked struct {
+		Guards []services.GuardFailure `json:"guards"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &blocked)
+	if w.Code != http.StatusConflict || len(blocked.Guards) != 2 ||
+		blocked.Guards[0].Guard != "has_mockup" || blocked.Guards[1].Guard != "deposit_received" {
+		t.Fatalf("got %d with guards %+v, want 409 naming has_mockup and deposit_received: %s", w.Code, blocked.Guards, w.Body)
+	}
+
+	// With the guards passing, reserve_stock fails for want of blanks
+	asset := models.Asset{OrderID: order.ID, Product: order.Product, Color: order.Color, MockupURL: "/mockups/m.png"}
+	if err := tx.Create(&asset).Error; err != nil {
+		t.Fatal(err)
+	}
+	customer.DepositPercent = 0
+	tx.Save(&customer)
+	item, _ := services.FindInventoryItem(tx, "T-Shirt", "black", "M")
+	if _, err := services.AdjustStock(tx, item.ID, services.MovementInput{Quantity: -45, Reason: models.ReasonDamaged}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := approve(); w.Code != http.StatusConflict {
+		t.Fatalf("approving without stock: got %d, want 409: %s", w.Code, w.Body)
+	}
+	var after models.Order
+	tx.First(&after, order.ID)
+	history, _ := services.OrderHistory(tx, order.ID)
+	reserved, _ := services.OutstandingReservations(tx, order.ID)
+	if after.Status != models.StatusMockupGenerated || after.Version != order.Version || len(history) != 0 || len(reserved) != 0 {
+		t.Errorf("failed approval left status %s, version %d, %d events and %d reservations", after.Status, after.Version, len(history), len(reserved))
+	}
+}
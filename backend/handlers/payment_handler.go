@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+// GetOrderInvoice renders the order's invoice as a PDF, or as JSON with ?format=json
+func GetOrderInvoice(c *gin.Context) {
+	order, ok := loadOrder(c)
+	if !ok {
+		return
+	}
+	invoice, err := services.BuildInvoice(db.DB, order)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, invoice)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := services.WriteInvoicePDF(&buf, invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("invoice generation failed: %v", err)})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="invoice-%d.pdf"`, order.ID))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// ListOrderPayments returns the order's payments and refunds with its balance and deposit
+func ListOrderPayments(c *gin.Context) {
+	order, ok := loadOrder(c)
+	if !ok {
+		return
+	}
+	summary, err := services.OrderPaymentSummary(db.DB, order)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// RecordOrderPayment records a payment received for the order, or a refund
+func RecordOrderPayment(c *gin.Context) {
+	order, ok := loadOrder(c)
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, order) {
+		return
+	}
+	var input services.PaymentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var payment *models.Payment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		payment, err = services.RecordPayment(tx, order, input, transitionMeta(c, "").Actor)
+		return err
+	})
+	switch {
+	case errors.Is(err, services.ErrInvalidPayment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", services.OrderETag(order))
+	c.JSON(http.StatusCreated, gin.H{"payment": payment, "order": order})
+}
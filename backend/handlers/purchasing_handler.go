@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+type CreateSupplierInput struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	Address string `json:"address"`
+}
+
+type CreatePurchaseOrderInput struct {
+	SupplierID uint                              `json:"supplierId"`
+	Notes      string                            `json:"notes"`
+	Lines      []services.PurchaseOrderLineInput `json:"lines"`
+}
+
+type ReceivePurchaseOrderInput struct {
+	LocationID uint                    `json:"locationId"`
+	Lines      []services.ReceiptInput `json:"lines"`
+}
+
+type SuggestPurchaseOrdersInput struct {
+	SupplierID *uint `json:"supplierId"`
+}
+
+func ListSuppliers(c *gin.Context) {
+	var suppliers []models.Supplier
+	db.DB.Order("name").Find(&suppliers)
+	c.JSON(http.StatusOK, suppliers)
+}
+
+func CreateSupplier(c *gin.Context) {
+	var input CreateSupplierInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "supplier name is required"})
+		return
+	}
+
+	supplier := models.Supplier{
+		Name:    input.Name,
+		Email:   input.Email,
+		Phone:   input.Phone,
+		Address: input.Address,
+	}
+	if err := db.DB.Create(&supplier).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, supplier)
+}
+
+func GetSupplier(c *gin.Context) {
+	var supplier models.Supplier
+	if err := db.DB.First(&supplier, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "supplier not found"})
+		return
+	}
+	c.JSON(http.StatusOK, supplier)
+}
+
+// ListPurchaseOrders returns purchase orders, optionally filtered by ?status=
+func ListPurchaseOrders(c *gin.Context) {
+	query := db.DB.Preload("Supplier").Preload("Lines").Order("id desc")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var pos []models.PurchaseOrder
+	query.Find(&pos)
+	c.JSON(http.StatusOK, pos)
+}
+
+func CreatePurchaseOrder(c *gin.Context) {
+	var input CreatePurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var po *models.PurchaseOrder
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		po, err = services.CreatePurchaseOrder(tx, input.SupplierID, input.Notes, input.Lines)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, po)
+}
+
+func GetPurchaseOrder(c *gin.Context) {
+	po, ok := loadPurchaseOrder(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, po)
+}
+
+// SendPurchaseOrder marks a draft purchase order as sent
+func SendPurchaseOrder(c *gin.Context) {
+	updatePurchaseOrder(c, func(tx *gorm.DB, po *models.PurchaseOrder) error {
+		return services.SendPurchaseOrder(tx, po)
+	})
+}
+
+// ReceivePurchaseOrder posts received quantities into inventory
+func ReceivePurchaseOrder(c *gin.Context) {
+	var input ReceivePurchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatePurchaseOrder(c, func(tx *gorm.DB, po *models.PurchaseOrder) error {
+		return services.ReceivePurchaseOrder(tx, po, input.LocationID, input.Lines)
+	})
+}
+
+// ClosePurchaseOrder closes a received or short-shipped purchase order
+func ClosePurchaseOrder(c *gin.Context) {
+	updatePurchaseOrder(c, func(tx *gorm.DB, po *models.PurchaseOrder) error {
+		return services.ClosePurchaseOrder(tx, po)
+	})
+}
+
+// SuggestPurchaseOrders drafts purchase orders from the low-stock report
+func SuggestPurchaseOrders(c *gin.Context) {
+	var input SuggestPurchaseOrdersInput
+	// An empty body is fine: SKUs without a preferred supplier are reported as unassigned
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var result *services.SuggestedPurchaseOrders
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.SuggestPurchaseOrders(tx, input.SupplierID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+func loadPurchaseOrder(c *gin.Context) (*models.PurchaseOrder, bool) {
+	var po models.PurchaseOrder
+	if err := db.DB.Preload("Supplier").Preload("Lines").First(&po, c.Param("ID")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "purchase order not found"})
+		return nil, false
+	}
+	return &po, true
+}
+
+func updatePurchaseOrder(c *gin.Context, update func(tx *gorm.DB, po *models.PurchaseOrder) error) {
+	po, ok := loadPurchaseOrder(c)
+	if !ok {
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return update(tx, po)
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPurchaseOrderTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, po)
+}
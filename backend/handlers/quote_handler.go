@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/models"
+	"printflow/services"
+)
+
+// GetPricing returns the loaded price table
+func GetPricing(c *gin.Context) {
+	table, err := services.CurrentPriceTable()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, table)
+}
+
+// CreateQuote prices an order without placing it. The body is the same as POST /orders.
+func CreateQuote(c *gin.Context) {
+	var input CreateOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var quote *models.Quote
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		quote, err = services.CreateQuote(tx, input.orderInput())
+		return err
+	})
+	if err != nil {
+		createOrderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, quote)
+}
+
+// ListQuotes returns quotes newest first; customerId and status filter them
+func ListQuotes(c *gin.Context) {
+	customerID, err := uintQuery(c, "customerId")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	quotes, err := services.ListQuotes(db.DB, customerID, strings.ToUpper(c.Query("status")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, quotes)
+}
+
+func GetQuote(c *gin.Context) {
+	id, ok := quoteID(c)
+	if !ok {
+		return
+	}
+	quote, err := services.GetQuote(db.DB, id)
+	if err != nil {
+		quoteError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
+
+// ConvertQuote places the quoted order at the quoted prices
+func ConvertQuote(c *gin.Context) {
+	id, ok := quoteID(c)
+	if !ok {
+		return
+	}
+
+	var order *models.Order
+	var quote *models.Quote
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, quote, err = services.ConvertQuote(tx, id, transitionMeta(c, ""))
+		return err
+	})
+	if err != nil {
+		quoteError(c, err)
+		return
+	}
+
+	c.Header("ETag", services.OrderETag(order))
+	c.JSON(http.StatusCreated, gin.H{"order": order, "quote": quote})
+}
+
+func quoteID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("ID"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid quote id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func quoteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownQuote):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuoteClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		createOrderError(c, err)
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"printflow/db"
+	"printflow/services"
+)
+
+// InventoryValuationReport values on-hand stock ?asOf= a date (default now) using
+// ?method=fifo|average (default INVENTORY_COST_METHOD); ?format=csv for CSV
+func InventoryValuationReport(c *gin.Context) {
+	asOf := time.Now()
+	if value := c.Query("asOf"); value != "" {
+		parsed, err := parseReportDate(value, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		asOf = parsed
+	}
+	method := c.DefaultQuery("method", services.CostMethod())
+
+	valuation, err := services.ValueInventory(db.DB, asOf, method)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := services.WriteValuationCSV(&buf, valuation); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sendCSV(c, "inventory_valuation.csv", buf.Bytes())
+		return
+	}
+	c.JSON(http.StatusOK, valuation)
+}
+
+// COGSReport lists cost of goods for orders consumed ?from= to ?to= (inclusive dates,
+// default the last 30 days); ?format=csv for CSV
+func COGSReport(c *gin.Context) {
+	from, to, ok := reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.CostOfGoodsSold(db.DB, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := services.WriteCOGSCSV(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sendCSV(c, "cogs.csv", buf.Bytes())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// DiscountReport totals the discounts given on orders created ?from= to ?to= (inclusive
+// dates, default the last 30 days) per discount; ?format=csv for CSV
+func DiscountReport(c *gin.Context) {
+	from, to, ok := reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	report, err := services.DiscountsGiven(db.DB, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := services.WriteDiscountCSV(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sendCSV(c, "discounts.csv", buf.Bytes())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// SalesTaxReport totals the sales tax owed to each jurisdiction on orders sold ?from=
+// to ?to= (inclusive dates, default the last 30 days), by ?groupBy=month (default),
+// quarter or year; ?format=csv for CSV
+func SalesTaxReport(c *gin.Context) {
+	from, to, ok := reportPeriod(c)
+	if !ok {
+		return
+	}
+	groupBy := c.DefaultQuery("groupBy", "month")
+	if groupBy != "month" && groupBy != "quarter" && groupBy != "year" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be month, quarter or year"})
+		return
+	}
+
+	report, err := services.TaxLiabilities(db.DB, from, to, groupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		if err := services.WriteTaxLiabilityCSV(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sendCSV(c, "sales-tax.csv", buf.Bytes())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// reportPeriod reads a report's ?from= and ?to=, defaulting to the last 30 days
+func reportPeriod(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	if value := c.Query("from"); value != "" {
+		parsed, err := parseReportDate(value, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return from, to, false
+		}
+		from = parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := parseReportDate(value, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return from, to, false
+		}
+		to = parsed
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// parseReportDate accepts RFC3339 or YYYY-MM-DD; a bare date at the end of a range
+// covers the whole day
+func parseReportDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC3339", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+func sendCSV(c *gin.Context, filename string, data []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, "text/csv", data)
+}
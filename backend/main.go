@@ -6,7 +6,7 @@ import (
     "time"
     "printflow/db"
     "printflow/handlers"
-    "printflow/models"
+    "printflow/services"
 
     "github.com/gin-gonic/gin"
     "github.com/joho/godotenv"
@@ -19,9 +19,28 @@ func main() {
     }
 
     db.Connect()
-    db.DB.AutoMigrate(&models.Order{}, &models.Asset{})
+    if err := services.EnsureDefaultLocation(db.DB); err != nil {
+        log.Fatalf("failed to set up default location: %v", err)
+    }
+    if err := services.EnsureOrderLines(db.DB); err != nil {
+        log.Fatalf("failed to add lines to existing orders: %v", err)
+    }
+    if err := services.LoadWorkflows(services.WorkflowFile()); err != nil {
+        log.Fatalf("failed to load workflows: %v", err)
+    }
+    if err := services.LoadPricing(services.PricingFile()); err != nil {
+        log.Fatalf("failed to load price table: %v", err)
+    }
+    if err := services.LoadTaxRates(services.TaxFile()); err != nil {
+        log.Fatalf("failed to load tax table: %v", err)
+    }
+    services.StartOrderPurge(db.DB, services.OrderRetention(), time.Hour)
 
     r := gin.Default()
+    // Forwarded headers are only believed from the proxies in TRUSTED_PROXIES
+    if err := r.SetTrustedProxies(handlers.TrustedProxies()); err != nil {
+        log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+    }
 
     // Add middleware for timestamp
     r.Use(func(c *gin.Context) {
@@ -31,8 +50,9 @@ func main() {
 
 	r.Use(func(c *gin.Context) {
     c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-    c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-    c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+    c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+    c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key, X-Actor, X-Request-ID")
+    c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed")
 
     if c.Request.Method == "OPTIONS" {
         c.AbortWithStatus(204)
@@ -42,13 +62,108 @@ func main() {
 })
 
     // API routes
-    r.POST("/orders", handlers.CreateOrder)
+    r.POST("/orders", handlers.Idempotent(), handlers.CreateOrder)
     r.GET("/orders", handlers.ListOrders)
+    r.POST("/orders/import", handlers.Idempotent(), handlers.ImportOrders)
+    r.GET("/orders/export", handlers.ExportOrders)
     r.GET("/orders/:ID", handlers.GetOrder)
+    r.PATCH("/orders/:ID", handlers.UpdateOrder)
+    r.DELETE("/orders/:ID", handlers.DeleteOrder)
+    r.POST("/orders/:ID/restore", handlers.RestoreOrder)
     r.POST("/orders/:ID/approve", handlers.ApproveOrder)
-    r.POST("/orders/:ID/mockup", handlers.GenerateMockupHandler)
-	r.POST("/orders/:ID/label", handlers.GenerateLabel)
+    r.POST("/orders/:ID/cancel", handlers.CancelOrder)
+    r.POST("/orders/:ID/digitize", handlers.MarkOrderDigitized)
+    r.POST("/orders/:ID/ready", handlers.MarkOrderReady)
+    r.POST("/orders/:ID/print", handlers.MarkOrderPrinted)
+    r.POST("/orders/:ID/pack", handlers.MarkOrderPacked)
+    r.POST("/orders/:ID/ship", handlers.MarkOrderShipped)
+    r.POST("/orders/:ID/deliver", handlers.MarkOrderDelivered)
+    r.POST("/orders/:ID/hold", handlers.HoldOrder)
+    r.POST("/orders/:ID/resume", handlers.ResumeOrder)
+    r.POST("/orders/:ID/request-revision", handlers.RequestRevision)
+    r.POST("/orders/:ID/misprint", handlers.RecordMisprint)
+    r.GET("/orders/:ID/consumables", handlers.GetOrderConsumables)
+    r.GET("/orders/:ID/invoice", handlers.GetOrderInvoice)
+    r.GET("/orders/:ID/payments", handlers.ListOrderPayments)
+    r.POST("/orders/:ID/payments", handlers.Idempotent(), handlers.RecordOrderPayment)
+    r.GET("/orders/:ID/history", handlers.GetOrderHistory)
+    r.POST("/orders/:ID/mockup", handlers.Idempotent(), handlers.GenerateMockupHandler)
+	r.POST("/orders/:ID/label", handlers.Idempotent(), handlers.GenerateLabel)
 	r.GET("/colors", handlers.GetAvailableColors)
+    r.GET("/workflows", handlers.ListWorkflows)
+
+    // Inventory routes
+    r.GET("/inventory", handlers.ListInventory)
+    r.POST("/inventory", handlers.CreateInventoryItem)
+    r.GET("/inventory/low-stock", handlers.LowStockReport)
+    r.GET("/inventory/alerts", handlers.ListStockAlerts)
+    r.POST("/inventory/alerts/:ID/acknowledge", handlers.AcknowledgeStockAlert)
+    r.GET("/inventory/:ID", handlers.GetInventoryItem)
+    r.GET("/inventory/:ID/movements", handlers.ListStockMovements)
+    r.POST("/inventory/:ID/receive", handlers.ReceiveStock)
+    r.POST("/inventory/:ID/adjust", handlers.AdjustStock)
+    r.PUT("/inventory/:ID/reorder", handlers.SetReorderPolicy)
+    r.GET("/inventory/:ID/locations", handlers.GetInventoryItemLocations)
+    r.POST("/inventory/:ID/transfer", handlers.TransferStock)
+
+    // Customer routes
+    r.GET("/customers", handlers.ListCustomers)
+    r.POST("/customers", handlers.CreateCustomer)
+    r.GET("/customers/:ID", handlers.GetCustomer)
+    r.PUT("/customers/:ID", handlers.UpdateCustomer)
+    r.DELETE("/customers/:ID", handlers.DeleteCustomer)
+    r.GET("/customers/:ID/orders", handlers.ListCustomerOrders)
+    r.POST("/customers/:ID/addresses", handlers.AddCustomerAddress)
+    r.PUT("/customers/:ID/addresses/:addressID", handlers.UpdateCustomerAddress)
+    r.DELETE("/customers/:ID/addresses/:addressID", handlers.DeleteCustomerAddress)
+
+    // Pricing and quote routes
+    r.GET("/pricing", handlers.GetPricing)
+    r.GET("/quotes", handlers.ListQuotes)
+    r.POST("/quotes", handlers.CreateQuote)
+    r.GET("/quotes/:ID", handlers.GetQuote)
+    r.POST("/quotes/:ID/convert", handlers.Idempotent(), handlers.ConvertQuote)
+
+    // Location routes
+    r.GET("/locations", handlers.ListLocations)
+    r.POST("/locations", handlers.CreateLocation)
+    r.GET("/locations/:ID/stock", handlers.GetLocationStock)
+
+    // Consumable routes
+    r.GET("/consumables", handlers.ListConsumables)
+    r.POST("/consumables", handlers.CreateConsumable)
+    r.GET("/consumables/forecast", handlers.ForecastConsumables)
+    r.GET("/consumables/:ID", handlers.GetConsumable)
+    r.POST("/consumables/:ID/receive", handlers.ReceiveConsumable)
+    r.POST("/consumables/:ID/adjust", handlers.AdjustConsumable)
+    r.PUT("/consumables/:ID/rates", handlers.SetConsumableRate)
+
+    // Report routes
+    r.GET("/reports/inventory-valuation", handlers.InventoryValuationReport)
+    r.GET("/reports/cogs", handlers.COGSReport)
+    r.GET("/reports/discounts", handlers.DiscountReport)
+    r.GET("/reports/sales-tax", handlers.SalesTaxReport)
+
+    // Cycle count routes
+    r.GET("/cycle-counts", handlers.ListCycleCounts)
+    r.POST("/cycle-counts", handlers.CreateCycleCount)
+    r.GET("/cycle-counts/:ID", handlers.GetCycleCount)
+    r.GET("/cycle-counts/:ID/sheet", handlers.GetCycleCountSheet)
+    r.GET("/cycle-counts/:ID/variances", handlers.GetCycleCountVariances)
+    r.PUT("/cycle-counts/:ID/counts", handlers.RecordCycleCounts)
+    r.POST("/cycle-counts/:ID/post", handlers.PostCycleCount)
+
+    // Purchasing routes
+    r.GET("/suppliers", handlers.ListSuppliers)
+    r.POST("/suppliers", handlers.CreateSupplier)
+    r.GET("/suppliers/:ID", handlers.GetSupplier)
+    r.GET("/purchase-orders", handlers.ListPurchaseOrders)
+    r.POST("/purchase-orders", handlers.CreatePurchaseOrder)
+    r.POST("/purchase-orders/suggest", handlers.SuggestPurchaseOrders)
+    r.GET("/purchase-orders/:ID", handlers.GetPurchaseOrder)
+    r.POST("/purchase-orders/:ID/send", handlers.SendPurchaseOrder)
+    r.POST("/purchase-orders/:ID/receive", handlers.ReceivePurchaseOrder)
+    r.POST("/purchase-orders/:ID/close", handlers.ClosePurchaseOrder)
 
 
     
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Consumable units
+const (
+    UnitMilliliters = "ml"
+    UnitMeters      = "m"
+    UnitGrams       = "g"
+)
+
+// Consumable movement types
+const (
+    ConsumableReceive = "RECEIVE"
+    ConsumableAdjust  = "ADJUST"
+    ConsumableUse     = "USE"
+)
+
+// Consumable is a print supply used up per job: DTF film, powder, ink
+type Consumable struct {
+    ID           uint   `gorm:"primaryKey"`
+    Name         string `gorm:"uniqueIndex"`
+    Unit         string
+    OnHand       float64
+    UnitCost     float64 // weighted average cost per unit
+    ReorderPoint float64
+    CreatedAt    time.Time
+    UpdatedAt    time.Time
+}
+
+// ConsumableRate estimates how much of a consumable one print uses. Usage is
+// PerPrint plus PerSquareCm times the printed logo area. An empty Product
+// applies to every product.
+type ConsumableRate struct {
+    ID           uint `gorm:"primaryKey"`
+    ConsumableID uint `gorm:"index"`
+    Product      string
+    Placement    string
+    PerPrint     float64
+    PerSquareCm  float64
+}
+
+// ConsumableMovement is a ledger entry changing a Consumable's on-hand quantity
+type ConsumableMovement struct {
+    ID           uint `gorm:"primaryKey"`
+    ConsumableID uint `gorm:"index"`
+    Type         string
+    Quantity     float64
+    Cost         float64
+    OrderID      *uint `gorm:"index"`
+    Note         string
+    CreatedAt    time.Time
+}
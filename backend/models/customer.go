@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+const (
+    AddressShipping = "shipping"
+    AddressBilling  = "billing"
+)
+
+// Customer is who an order is made for, with the addresses they ship and bill to
+type Customer struct {
+    ID        uint `gorm:"primaryKey"`
+    Name      string
+    Company   string
+    Email     string `gorm:"index"`
+    Phone     string
+    Notes     string
+    DepositPercent   float64 // share of an order's total to collect before it is approved; 0 for none
+    PaymentTermsDays int     // days after invoicing that payment is due; 0 for due on receipt
+    PriceList        string  // price list from the price table their orders are priced at; empty for list prices
+    TaxExempt        bool    // buys for resale or as a non-profit; their orders are not taxed
+    TaxCertificate   string  // exemption certificate number, required when TaxExempt
+    Addresses []CustomerAddress
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
+
+// CustomerAddress is a saved shipping or billing address. Each customer has at most
+// one default address of each kind.
+type CustomerAddress struct {
+    ID         uint   `gorm:"primaryKey"`
+    CustomerID uint   `gorm:"index"`
+    Kind       string // AddressShipping or AddressBilling
+    Label      string // e.g. "Warehouse", "Head office"
+    Name       string
+    Address    string
+    City       string
+    State      string
+    Zip        string
+    IsDefault  bool
+    CreatedAt  time.Time
+}
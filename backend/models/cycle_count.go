@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+    CycleCountOpen   = "OPEN"
+    CycleCountPosted = "POSTED"
+)
+
+// CycleCount is a stock count of some or all SKUs at one location
+type CycleCount struct {
+    ID         uint `gorm:"primaryKey"`
+    LocationID uint `gorm:"index"`
+    Status     string
+    Notes      string
+    Lines      []CycleCountLine
+    PostedAt   *time.Time
+    CreatedAt  time.Time
+}
+
+// CycleCountLine is one SKU on a count sheet. ExpectedQuantity is the on-hand
+// snapshot taken when the sheet was generated.
+type CycleCountLine struct {
+    ID               uint `gorm:"primaryKey"`
+    CycleCountID     uint `gorm:"index"`
+    InventoryItemID  uint
+    SKU              string
+    ExpectedQuantity int
+    CountedQuantity  *int
+    Variance         int
+    Reason           string
+}
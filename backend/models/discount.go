@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// OrderDiscount is a discount from the price table taken off an order when it was
+// priced. Amount is what it took off; the order's lines hold their share of it.
+type OrderDiscount struct {
+    ID        uint   `gorm:"primaryKey"`
+    OrderID   uint   `gorm:"index"`
+    Name      string
+    Code      string // the code the customer gave; empty for automatic discounts
+    Type      string // percentage, fixed, free_placement or buy_x_get_y
+    Amount    float64
+    CreatedAt time.Time
+}
+
+// QuoteDiscount is a discount taken off a quote, carried onto the order it converts to
+type QuoteDiscount struct {
+    ID      uint   `gorm:"primaryKey"`
+    QuoteID uint   `gorm:"index"`
+    Name    string
+    Code    string
+    Type    string
+    Amount  float64
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers the response to a request sent with an Idempotency-Key
+// header so a retry of the same request gets the same response instead of repeating it
+type IdempotencyKey struct {
+    ID          uint   `gorm:"primaryKey"`
+    Key         string `gorm:"uniqueIndex:idx_idempotency_key"`
+    Scope       string `gorm:"uniqueIndex:idx_idempotency_key"` // method and path, e.g. "POST /orders"
+    RequestHash string // SHA-256 of the request body; a retry must match it
+    StatusCode  int    // 0 while the first request is still running
+    ETag        string
+    Body        []byte
+    CreatedAt   time.Time `gorm:"index"`
+    CompletedAt *time.Time
+}
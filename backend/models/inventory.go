@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// Stock movement types recorded in the inventory ledger
+const (
+    MovementReceive     = "RECEIVE"
+    MovementAdjust      = "ADJUST"
+    MovementReserve     = "RESERVE"
+    MovementRelease     = "RELEASE"
+    MovementConsume     = "CONSUME"
+    MovementTransferOut = "TRANSFER_OUT"
+    MovementTransferIn  = "TRANSFER_IN"
+)
+
+// Adjustment reason codes
+const (
+    ReasonDamaged   = "DAMAGED"
+    ReasonMisprint  = "MISPRINT"
+    ReasonShrinkage = "SHRINKAGE"
+    ReasonFound     = "FOUND"
+)
+
+// InventoryItem is a blank garment SKU (product x color x size) and its stock levels
+// summed across all locations; per-location levels live in LocationStock
+type InventoryItem struct {
+    ID              uint   `gorm:"primaryKey"`
+    SKU             string `gorm:"uniqueIndex"`
+    Product         string
+    Color           string
+    Size            string
+    OnHand          int
+    Reserved        int
+    Available       int
+    ReorderPoint    int // 0 disables low-stock alerts for the SKU
+    ReorderQuantity int
+    SupplierID      *uint // preferred supplier for suggested purchase orders
+    CreatedAt       time.Time
+    UpdatedAt       time.Time
+}
+
+// StockMovement is an append-only ledger entry changing an InventoryItem's stock
+type StockMovement struct {
+    ID              uint `gorm:"primaryKey"`
+    InventoryItemID uint `gorm:"index"`
+    LocationID      uint `gorm:"index"`
+    Type            string
+    Reason          string
+    Quantity        int
+    UnitCost        float64 // purchase cost on receipts, issue cost when stock leaves
+    Cost            float64 // extended cost of the movement (issues only)
+    OnHandAfter     int
+    ReservedAfter   int
+    OrderID         *uint `gorm:"index"`
+    Reference       string
+    Note            string
+    CreatedAt       time.Time
+}
+
+// ItemCost is a SKU's cost position after the ledger up to ThroughMovementID, kept so
+// pricing a movement does not replay the whole ledger
+type ItemCost struct {
+    InventoryItemID   uint `gorm:"primaryKey;autoIncrement:false"`
+    OnHand            int
+    AverageCost       float64
+    Layers            string // FIFO cost layers as JSON, oldest first
+    ThroughMovementID uint
+    UpdatedAt         time.Time
+}
+
+// StockAlert is raised when a SKU's available stock falls to or below its reorder point
+type StockAlert struct {
+    ID              uint `gorm:"primaryKey"`
+    InventoryItemID uint `gorm:"index"`
+    SKU             string
+    Available       int
+    ReorderPoint    int
+    MovementID      uint
+    Acknowledged    bool `gorm:"default:false"`
+    CreatedAt       time.Time
+}
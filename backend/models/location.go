@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Location is a place stock is kept and orders ship from (shop, overflow storage, second facility)
+type Location struct {
+    ID        uint   `gorm:"primaryKey"`
+    Code      string `gorm:"uniqueIndex"`
+    Name      string
+    Address   string
+    City      string
+    State     string
+    Zip       string
+    Priority  int  // lower ships first when several locations can fulfil an order
+    Active    bool `gorm:"default:true"`
+    CreatedAt time.Time
+}
+
+// LocationStock is an InventoryItem's stock held at a single Location
+type LocationStock struct {
+    ID              uint `gorm:"primaryKey"`
+    InventoryItemID uint `gorm:"uniqueIndex:idx_location_stock"`
+    LocationID      uint `gorm:"uniqueIndex:idx_location_stock"`
+    OnHand          int
+    Reserved        int
+    Available       int
+    UpdatedAt       time.Time
+}
@@ -1,28 +1,105 @@
 package models
 
-import "time"
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
 
 const (
-    StatusCreated         = "CREATED"
-    StatusMockupGenerated = "MOCKUP_GENERATED"
-    StatusApproved        = "APPROVED"
-    StatusReady           = "READY_FOR_FULFILLMENT"
+    StatusCreated           = "CREATED"
+    StatusMockupGenerated   = "MOCKUP_GENERATED"
+    StatusApproved          = "APPROVED"
+    StatusDigitized         = "DIGITIZED" // embroidery design converted to a stitch file
+    StatusReady             = "READY_FOR_FULFILLMENT"
+    StatusPrinted           = "PRINTED"
+    StatusPacked            = "PACKED"
+    StatusShipped           = "SHIPPED"
+    StatusDelivered         = "DELIVERED"
+    StatusCancelled         = "CANCELLED"
+    StatusOnHold            = "ON_HOLD"
+    StatusRevisionRequested = "REVISION_REQUESTED"
 )
 
 type Order struct {
-    ID        uint      `gorm:"primaryKey"`
-    Product   string
-    Color     string
-    Size      string
-    Status    string
-    CreatedAt time.Time
+    ID          uint `gorm:"primaryKey"`
+    Product     string // first line's garment; it decides the order's workflow
+    Color       string
+    Size        string
+    Status      string `gorm:"index"`
+    HeldFrom    string  // status to resume to while ON_HOLD
+    LocationID  uint    // fulfilling location, chosen at creation
+    CustomerID  *uint   `gorm:"index"`
+    ShippingAddressID *uint // customer address the order ships to; labels default to it
+    CostOfGoods float64 // blank and consumable cost, recorded when stock is consumed
+    Rush        string  // rush level from the price table; empty for standard turnaround
+    Subtotal    float64 // sum of line totals at the locked prices
+    PriceList   string  // the customer's price list when the order was priced
+    DiscountTotal float64 // taken off the subtotal before the rush fee
+    RushFee     float64
+    TaxTotal    float64 // sales tax on the lines, included in Total
+    TaxState    string  // state and zip the tax was worked out for
+    TaxZip      string
+    TaxCertificate string // customer's exemption certificate when the order was not taxed
+    Total       float64
+    PaymentStatus string  `gorm:"default:UNPAID"` // PaymentUnpaid, PaymentPartial, PaymentPaid or PaymentRefunded
+    AmountPaid    float64 // payments less refunds
+    QuoteID     *uint      // quote the order was converted from, whose prices it keeps
+    PricedAt    *time.Time // when the prices were locked; nil for orders from before pricing
+    Version     uint    `gorm:"not null;default:1"` // bumped on every save, served as the ETag
+    CreatedAt   time.Time `gorm:"index"`
+    DeletedAt   gorm.DeletedAt `gorm:"index"` // soft delete; purged after the retention period
+    Lines       []OrderLine
+    Discounts   []OrderDiscount
+}
+
+// OrderLine is one garment SKU on an order and how many of it to print
+type OrderLine struct {
+    ID              uint `gorm:"primaryKey"`
+    OrderID         uint `gorm:"index"`
+    InventoryItemID uint
+    Product         string
+    Color           string
+    Size            string
+    Quantity        int
+    Placements      string // comma-separated print placements, e.g. "front,back"
+    LogoColors      int     // ink colors in the logo, for pricing; 0 counts as 1
+    PrintAreaCm2    float64 // printed area per placement, for pricing; 0 when not priced by area
+    UnitPrice       float64 // locked price per garment
+    LineTotal       float64 // quantity at the unit price, before discounts
+    Discount        float64 // the line's share of the order's discounts
+    TaxCategory     string  // product tax category from the tax table
+    TaxRate         float64 // combined percent across the line's jurisdictions
+    Tax             float64 // sales tax on the line total less its discount
+    Taxes           []OrderLineTax
+    AssetID         *uint  // artwork for this line, shared by every size of the same product and color
+    CreatedAt       time.Time
 }
 
 type Asset struct {
     ID        uint   `gorm:"primaryKey"`
-    OrderID   uint
+    OrderID   uint   `gorm:"index"`
+    Product   string // garment the mockup is rendered on
+    Color     string
     LogoURL   string
     MockupURL string
     AIGenerated bool `gorm:"default:false"`
     AIPrompt    string
+    PrintWidthCm  float64 // printed logo size, measured when the mockup is generated
+    PrintHeightCm float64
+    Version       uint `gorm:"not null;default:1"`
+}
+
+// OrderEvent is one status change in an order's audit trail
+type OrderEvent struct {
+    ID         uint   `gorm:"primaryKey"`
+    OrderID    uint   `gorm:"index"`
+    FromStatus string // empty for the event that created the order
+    ToStatus   string
+    Actor      string
+    Reason     string
+    RemoteAddr string
+    UserAgent  string
+    RequestID  string
+    CreatedAt  time.Time
 }
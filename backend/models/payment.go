@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+    PaymentUnpaid   = "UNPAID"
+    PaymentPartial  = "PARTIALLY_PAID"
+    PaymentPaid     = "PAID"
+    PaymentRefunded = "REFUNDED"
+)
+
+const (
+    PaymentKindPayment = "PAYMENT"
+    PaymentKindRefund  = "REFUND"
+)
+
+// Payment is money received for an order, or returned to the customer as a refund.
+// Amounts are always positive; Kind says which way the money moved.
+type Payment struct {
+    ID        uint   `gorm:"primaryKey"`
+    OrderID   uint   `gorm:"index"`
+    Kind      string // PaymentKindPayment or PaymentKindRefund
+    Amount    float64
+    Method    string // e.g. "card", "check", "bank transfer"
+    Reference string // check number, transaction id, ...
+    Note      string
+    Actor     string
+    CreatedAt time.Time
+}
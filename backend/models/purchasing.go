@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+const (
+    PurchaseOrderDraft             = "DRAFT"
+    PurchaseOrderSent              = "SENT"
+    PurchaseOrderPartiallyReceived = "PARTIALLY_RECEIVED"
+    PurchaseOrderReceived          = "RECEIVED"
+    PurchaseOrderClosed            = "CLOSED"
+)
+
+type Supplier struct {
+    ID        uint `gorm:"primaryKey"`
+    Name      string
+    Email     string
+    Phone     string
+    Address   string
+    CreatedAt time.Time
+}
+
+type PurchaseOrder struct {
+    ID         uint `gorm:"primaryKey"`
+    SupplierID uint `gorm:"index"`
+    Supplier   Supplier
+    Status     string
+    Notes      string
+    Lines      []PurchaseOrderLine
+    SentAt     *time.Time
+    ClosedAt   *time.Time
+    CreatedAt  time.Time
+    UpdatedAt  time.Time
+}
+
+// PurchaseOrderLine is a quantity of one blank garment SKU ordered from a supplier
+type PurchaseOrderLine struct {
+    ID               uint `gorm:"primaryKey"`
+    PurchaseOrderID  uint `gorm:"index"`
+    InventoryItemID  uint `gorm:"index"`
+    SKU              string
+    Quantity         int
+    ReceivedQuantity int
+    UnitCost         float64
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+const (
+    QuoteOpen      = "OPEN"
+    QuoteConverted = "CONVERTED"
+)
+
+// Quote is a priced order that has not been placed. Converting it creates the order
+// at the quoted prices, as long as it has not expired.
+type Quote struct {
+    ID                   uint   `gorm:"primaryKey"`
+    CustomerID           *uint  `gorm:"index"`
+    Status               string `gorm:"index"`
+    Request              string // the order input the quote was priced for, as JSON
+    Currency             string
+    Rush                 string
+    Quantity             int     // garments across every line
+    QuantityBreakPercent float64 // discount for the quantity, already in the unit prices
+    Subtotal             float64
+    PriceList            string
+    DiscountTotal        float64
+    RushFee              float64
+    TaxTotal             float64 // estimated; worked out again when the quote converts
+    Total                float64
+    ExpiresAt            time.Time
+    OrderID              *uint // set once converted
+    Lines                []QuoteLine
+    Discounts            []QuoteDiscount
+    CreatedAt            time.Time
+    UpdatedAt            time.Time
+}
+
+// QuoteLine is one garment size on a quote with the parts of its unit price
+type QuoteLine struct {
+    ID              uint `gorm:"primaryKey"`
+    QuoteID         uint `gorm:"index"`
+    Product         string
+    Color           string
+    Size            string
+    Quantity        int
+    Placements      string
+    LogoColors      int
+    PrintAreaCm2    float64
+    BasePrice       float64 // blank garment
+    SizeUpcharge    float64
+    DecorationPrice float64 // placements, extra colors and print area
+    QuantityBreak   float64 // taken off per garment
+    UnitPrice       float64
+    LineTotal       float64
+    Discount        float64 // share of the quote's discounts
+    TaxRate         float64
+    Tax             float64
+}
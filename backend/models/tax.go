@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+const (
+    TaxLevelState = "state"
+    TaxLevelLocal = "local" // a county, city or zip override within the state
+)
+
+// OrderLineTax is the sales tax one jurisdiction charges on an order line. Lines
+// shipped into a county or city with its own rate have one row for the state and one
+// for the local jurisdiction. Rows are kept for exempt sales too, at a zero amount,
+// so they can be reported.
+type OrderLineTax struct {
+    ID           uint   `gorm:"primaryKey"`
+    OrderLineID  uint   `gorm:"index"`
+    State        string `gorm:"index"`
+    Jurisdiction string // the state, or the local jurisdiction's name
+    Level        string // TaxLevelState or TaxLevelLocal
+    Category     string
+    Sales        float64 // line total less its discount
+    Rate         float64 // percent; 0 when the category or customer is exempt
+    Amount       float64
+    Exempt       bool // the customer had an exemption certificate
+    CreatedAt    time.Time
+}
@@ -6,39 +6,72 @@ import (
 	"printflow/models"
 )
 
+// orderTables hold orders and everything that belongs to them, children first. Order
+// IDs are reused once the counters are reset, so nothing may be left pointing at one.
+var orderTables = []struct{ name, where string }{
+	{"order_line_taxes", ""},
+	{"order_lines", ""},
+	{"order_discounts", ""},
+	{"order_events", ""},
+	{"payments", ""},
+	{"assets", ""},
+	{"stock_movements", "order_id IS NOT NULL"},
+	{"consumable_movements", "order_id IS NOT NULL"},
+	{"quote_lines", ""},
+	{"quote_discounts", ""},
+	{"quotes", ""},
+	{"orders", ""},
+}
+
 func main() {
 	// Connect to database
 	db.Connect()
 
-	log.Println("Clearing all data from database...")
+	log.Println("Clearing all orders from database...")
 
 	// Delete all records from tables (keeps table structure)
-	if err := db.DB.Exec("DELETE FROM assets").Error; err != nil {
-		log.Printf("Error clearing assets: %v", err)
-	} else {
-		log.Println("✓ Cleared assets table")
+	var names []interface{}
+	for _, table := range orderTables {
+		query := "DELETE FROM " + table.name
+		if table.where != "" {
+			query += " WHERE " + table.where
+		}
+		if err := db.DB.Exec(query).Error; err != nil {
+			log.Fatalf("Error clearing %s: %v", table.name, err)
+		}
+		log.Printf("✓ Cleared %s table", table.name)
+		if table.where == "" {
+			names = append(names, table.name)
+		}
 	}
 
-	if err := db.DB.Exec("DELETE FROM orders").Error; err != nil {
-		log.Printf("Error clearing orders: %v", err)
-	} else {
-		log.Println("✓ Cleared orders table")
+	// Stock reserved for the cleared orders is free again
+	for _, table := range []string{"inventory_items", "location_stocks"} {
+		if err := db.DB.Exec("UPDATE " + table + " SET reserved = 0").Error; err != nil {
+			log.Fatalf("Error releasing reservations in %s: %v", table, err)
+		}
 	}
+	log.Println("✓ Released reserved stock")
 
-	// Reset auto-increment counters (SQLite specific)
-	if err := db.DB.Exec("DELETE FROM sqlite_sequence WHERE name IN ('orders', 'assets')").Error; err != nil {
+	// Reset auto-increment counters (SQLite specific) of the tables now empty
+	if err := db.DB.Exec("DELETE FROM sqlite_sequence WHERE name IN ?", names).Error; err != nil {
 		log.Printf("Warning: Could not reset auto-increment counters: %v", err)
 	} else {
 		log.Println("✓ Reset auto-increment counters")
 	}
 
 	// Verify tables are empty
-	var orderCount, assetCount int64
-	db.DB.Model(&models.Order{}).Count(&orderCount)
+	var orderCount, lineCount, assetCount, paymentCount int64
+	db.DB.Unscoped().Model(&models.Order{}).Count(&orderCount)
+	db.DB.Model(&models.OrderLine{}).Count(&lineCount)
 	db.DB.Model(&models.Asset{}).Count(&assetCount)
+	db.DB.Model(&models.Payment{}).Count(&paymentCount)
 
 	log.Printf("Database cleared successfully!")
 	log.Printf("Orders: %d records", orderCount)
+	log.Printf("Order lines: %d records", lineCount)
 	log.Printf("Assets: %d records", assetCount)
+	log.Printf("Payments: %d records", paymentCount)
+	log.Println("On-hand stock keeps its counts; order stock movements were removed")
 	log.Println("Tables structure preserved - ready for fresh data")
-}
\ No newline at end of file
+}
@@ -0,0 +1,99 @@
+//go:build ignore
+
+// Imports orders from a CSV or JSON file, like POST /orders/import.
+//
+//	go run scripts/import_orders.go [-dry-run] [-actor name] orders.csv
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+	"printflow/db"
+	"printflow/services"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "check every row without saving anything")
+	actor := flag.String("actor", "import-script", "who the order history records as creating the orders")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: go run scripts/import_orders.go [-dry-run] [-actor name] orders.csv|orders.json")
+	}
+	path := flag.Arg(0)
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var rows []services.ImportRow
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = services.ParseImportCSV(file)
+	case ".json":
+		rows, err = services.ParseImportJSON(file)
+	default:
+		log.Fatalf("%s should be a .csv or .json file", path)
+	}
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", path, err)
+	}
+
+	// Connect to database
+	db.Connect()
+	if err := services.EnsureDefaultLocation(db.DB); err != nil {
+		log.Fatalf("Error setting up default location: %v", err)
+	}
+	if err := services.LoadWorkflows(services.WorkflowFile()); err != nil {
+		log.Fatalf("Error loading workflows: %v", err)
+	}
+	if err := services.LoadPricing(services.PricingFile()); err != nil {
+		log.Fatalf("Error loading price table: %v", err)
+	}
+	if err := services.LoadTaxRates(services.TaxFile()); err != nil {
+		log.Fatalf("Error loading tax table: %v", err)
+	}
+
+	options := services.ImportOptions{DryRun: *dryRun, UploadDir: "uploads"}
+	// Logos download before the transaction opens so a slow host cannot hold the database
+	options.Logos = services.FetchImportLogos(rows, options)
+	var result *services.ImportResult
+	errDryRun := errors.New("dry run")
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = services.ImportOrders(tx, rows, options, services.TransitionMeta{Actor: *actor, Reason: "imported"})
+		if err == nil && options.DryRun {
+			return errDryRun
+		}
+		return err
+	})
+	if err != nil {
+		options.Logos.RemoveDownloads()
+	}
+
+	if result != nil {
+		for _, rowErr := range result.Errors {
+			log.Printf("Row %d %s: %s", rowErr.Row, rowErr.Field, rowErr.Message)
+		}
+		out, _ := json.MarshalIndent(result, "", "  ")
+		os.Stdout.Write(append(out, '\n'))
+	}
+	switch {
+	case err == nil:
+		log.Printf("✓ Imported %d orders from %d rows", len(result.Created), result.Rows)
+	case errors.Is(err, errDryRun):
+		log.Printf("✓ Dry run: %d rows would create %d orders", result.Rows, result.Orders)
+	case errors.Is(err, services.ErrImportRejected):
+		log.Fatalf("Import rejected: %d errors, nothing was saved", len(result.Errors))
+	default:
+		log.Fatalf("Import failed: %v", err)
+	}
+}
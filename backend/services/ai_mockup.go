@@ -51,7 +51,7 @@ func GenerateAIMockup(orderID uint, request AIPromptRequest) (string, error) {
 	fmt.Printf("Full prompt: %s\n", fullPrompt)
 
 	// Try the new Hugging Face Inference Providers API
-	success, mockupPath, err := tryNewHuggingFaceAPI(orderID, fullPrompt, apiKey)
+	success, mockupPath, err := tryNewHuggingFaceAPI(mockupName(orderID, request.Product, request.Color), fullPrompt, apiKey)
 	if success {
 		return mockupPath, err
 	}
@@ -62,7 +62,7 @@ func GenerateAIMockup(orderID uint, request AIPromptRequest) (string, error) {
 }
 
 // tryNewHuggingFaceAPI attempts to use the new Hugging Face Inference Providers API
-func tryNewHuggingFaceAPI(orderID uint, prompt string, apiKey string) (bool, string, error) {
+func tryNewHuggingFaceAPI(name string, prompt string, apiKey string) (bool, string, error) {
 	// Try the FLUX.2-klein-9B model and other working models using the new router
 	models := []struct {
 		name     string
@@ -77,7 +77,7 @@ func tryNewHuggingFaceAPI(orderID uint, prompt string, apiKey string) (bool, str
 	for _, model := range models {
 		fmt.Printf("Trying model: %s\n", model.name)
 		
-		success, mockupPath, err := makeHuggingFaceDirectRequest(model.endpoint, prompt, apiKey, orderID)
+		success, mockupPath, err := makeHuggingFaceDirectRequest(model.endpoint, prompt, apiKey, name)
 		if success {
 			return true, mockupPath, nil
 		}
@@ -88,7 +88,7 @@ func tryNewHuggingFaceAPI(orderID uint, prompt string, apiKey string) (bool, str
 }
 
 // makeHuggingFaceDirectRequest makes a direct request to Hugging Face Inference API
-func makeHuggingFaceDirectRequest(url, prompt, apiKey string, orderID uint) (bool, string, error) {
+func makeHuggingFaceDirectRequest(url, prompt, apiKey string, name string) (bool, string, error) {
 	// Prepare simple request format for direct API
 	requestData := map[string]interface{}{
 		"inputs": prompt,
@@ -147,7 +147,7 @@ func makeHuggingFaceDirectRequest(url, prompt, apiKey string, orderID uint) (boo
 	}
 
 	// Save the generated image
-	mockupPath, err := saveImageData(imageData, orderID)
+	mockupPath, err := saveImageData(imageData, name)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to save image: %v", err)
 	}
@@ -178,7 +178,7 @@ func buildMockupPrompt(request AIPromptRequest) string {
 }
 
 // saveImageData saves raw image data to a file
-func saveImageData(imageData []byte, orderID uint) (string, error) {
+func saveImageData(imageData []byte, name string) (string, error) {
 	// Create mockups directory if it doesn't exist
 	mockupsDir := "mockups"
 	if err := os.MkdirAll(mockupsDir, 0755); err != nil {
@@ -186,7 +186,7 @@ func saveImageData(imageData []byte, orderID uint) (string, error) {
 	}
 
 	// Save the image
-	filename := fmt.Sprintf("ai_mockup_order_%d.png", orderID)
+	filename := fmt.Sprintf("ai_mockup_%s.png", name)
 	filepath := filepath.Join(mockupsDir, filename)
 
 	err := os.WriteFile(filepath, imageData, 0644)
@@ -206,7 +206,7 @@ func GenerateAIMockupFallback(orderID uint, request AIPromptRequest) (string, er
 		return "", fmt.Errorf("failed to create mockups directory: %v", err)
 	}
 
-	filename := fmt.Sprintf("ai_mockup_order_%d.html", orderID)
+	filename := fmt.Sprintf("ai_mockup_%s.html", mockupName(orderID, request.Product, request.Color))
 	filepath := filepath.Join(mockupsDir, filename)
 
 	// Create an HTML mockup that looks like an AI-generated design
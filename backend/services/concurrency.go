@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"printflow/models"
+)
+
+var ErrVersionConflict = errors.New("record was changed by another request; reload and try again")
+
+// SaveOrder writes the order only if nobody has saved it since it was loaded,
+// bumping its version. It returns ErrVersionConflict when the stored version moved on.
+func SaveOrder(tx *gorm.DB, order *models.Order) error {
+	current := order.Version
+	order.Version = current + 1
+	result := tx.Model(order).Where("version = ?", current).Select("*").Omit(clause.Associations).Updates(order)
+	if result.Error != nil {
+		order.Version = current
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		order.Version = current
+		return fmt.Errorf("%w: order %d", ErrVersionConflict, order.ID)
+	}
+	return nil
+}
+
+// SaveAsset creates a new asset, or updates an existing one with the same version check as SaveOrder
+func SaveAsset(tx *gorm.DB, asset *models.Asset) error {
+	if asset.ID == 0 {
+		return tx.Create(asset).Error
+	}
+
+	current := asset.Version
+	asset.Version = current + 1
+	result := tx.Model(asset).Where("version = ?", current).Select("*").Updates(asset)
+	if result.Error != nil {
+		asset.Version = current
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		asset.Version = current
+		return fmt.Errorf("%w: asset %d", ErrVersionConflict, asset.ID)
+	}
+	return nil
+}
+
+// OrderETag is the entity tag for the order's current version
+func OrderETag(order *models.Order) string {
+	return fmt.Sprintf(`"%d"`, order.Version)
+}
+
+// MatchesETag reports whether an If-Match header value names the order's current version.
+// If-Match uses strong comparison (RFC 9110 section 13.1.1), so weak W/ tags never match.
+func MatchesETag(ifMatch string, order *models.Order) bool {
+	etag := OrderETag(order)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// DefaultPlacement is used for order lines that do not name their placements
+const DefaultPlacement = "front"
+
+// ConsumableUsage is the estimated use of one consumable by one order
+type ConsumableUsage struct {
+	ConsumableID uint    `json:"consumableId"`
+	Name         string  `json:"name"`
+	Unit         string  `json:"unit"`
+	Quantity     float64 `json:"quantity"`
+	Cost         float64 `json:"cost"`
+}
+
+// ConsumableForecast projects when a consumable runs out at its recent usage rate
+type ConsumableForecast struct {
+	Consumable       models.Consumable `json:"consumable"`
+	DailyUsage       float64           `json:"dailyUsage"`
+	DaysRemaining    *float64          `json:"daysRemaining"`
+	SuggestedReorder float64           `json:"suggestedReorder"`
+}
+
+// CreateConsumable registers a new consumable supply
+func CreateConsumable(tx *gorm.DB, name, unit string, reorderPoint float64) (*models.Consumable, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("consumable name is required")
+	}
+	switch unit {
+	case models.UnitMilliliters, models.UnitMeters, models.UnitGrams:
+	default:
+		return nil, fmt.Errorf("unit must be one of %s, %s, %s", models.UnitMilliliters, models.UnitMeters, models.UnitGrams)
+	}
+
+	consumable := models.Consumable{
+		Name:         name,
+		Unit:         unit,
+		ReorderPoint: reorderPoint,
+	}
+	if err := tx.Create(&consumable).Error; err != nil {
+		return nil, err
+	}
+	return &consumable, nil
+}
+
+// ReceiveConsumable books a delivery and folds its cost into the weighted average unit cost
+func ReceiveConsumable(tx *gorm.DB, consumableID uint, quantity, unitCost float64, note string) (*models.Consumable, error) {
+	if quantity <= 0 {
+		return nil, errors.New("received quantity must be positive")
+	}
+
+	var consumable models.Consumable
+	if err := tx.First(&consumable, consumableID).Error; err != nil {
+		return nil, err
+	}
+
+	if unitCost > 0 {
+		if consumable.OnHand > 0 {
+			consumable.UnitCost = (consumable.OnHand*consumable.UnitCost + quantity*unitCost) / (consumable.OnHand + quantity)
+		} else {
+			consumable.UnitCost = unitCost
+		}
+	}
+	return postConsumableMovement(tx, &consumable, models.ConsumableReceive, quantity, nil, note)
+}
+
+// AdjustConsumable applies a signed correction to a consumable's on-hand quantity
+func AdjustConsumable(tx *gorm.DB, consumableID uint, quantity float64, note string) (*models.Consumable, error) {
+	if quantity == 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	var consumable models.Consumable
+	if err := tx.First(&consumable, consumableID).Error; err != nil {
+		return nil, err
+	}
+	return postConsumableMovement(tx, &consumable, models.ConsumableAdjust, quantity, nil, note)
+}
+
+// SetConsumableRate creates or replaces the usage rate of a consumable for a product and placement
+func SetConsumableRate(tx *gorm.DB, rate models.ConsumableRate) (*models.ConsumableRate, error) {
+	if rate.PerPrint < 0 || rate.PerSquareCm < 0 {
+		return nil, errors.New("usage rates cannot be negative")
+	}
+	if err := tx.First(&models.Consumable{}, rate.ConsumableID).Error; err != nil {
+		return nil, fmt.Errorf("consumable %d not found", rate.ConsumableID)
+	}
+	if rate.Placement == "" {
+		rate.Placement = DefaultPlacement
+	}
+
+	var existing models.ConsumableRate
+	err := tx.Where("consumable_id = ? AND LOWER(product) = LOWER(?) AND placement = ?",
+		rate.ConsumableID, rate.Product, rate.Placement).First(&existing).Error
+	if err == nil {
+		rate.ID = existing.ID
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if err := tx.Save(&rate).Error; err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// EstimateConsumables works out how much of each consumable an order's print jobs use:
+// every placement on every line, from the logo's printed area (or the product's max
+// print area when unknown), times the line quantity
+func EstimateConsumables(tx *gorm.DB, order *models.Order) ([]ConsumableUsage, error) {
+	lines, err := LoadOrderLines(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := OrderAssets(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	areas := make(map[uint]PrintArea)
+	for _, asset := range assets {
+		if asset.PrintWidthCm > 0 {
+			areas[asset.ID] = PrintArea{WidthCm: asset.PrintWidthCm, HeightCm: asset.PrintHeightCm}
+		}
+	}
+
+	totals := make(map[uint]*ConsumableUsage)
+	var ids []uint
+	for _, line := range lines {
+		area := MaxPrintArea(line.Product)
+		if line.AssetID != nil {
+			if measured, ok := areas[*line.AssetID]; ok {
+				area = measured
+			}
+		}
+		for _, placement := range linePlacements(line) {
+			usage, err := estimateUsage(tx, line.Product, placement, area)
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range usage {
+				total, ok := totals[u.ConsumableID]
+				if !ok {
+					total = &ConsumableUsage{ConsumableID: u.ConsumableID, Name: u.Name, Unit: u.Unit}
+					totals[u.ConsumableID] = total
+					ids = append(ids, u.ConsumableID)
+				}
+				total.Quantity += u.Quantity * float64(line.Quantity)
+				total.Cost += u.Cost * float64(line.Quantity)
+			}
+		}
+	}
+
+	usage := make([]ConsumableUsage, 0, len(ids))
+	for _, id := range ids {
+		total := totals[id]
+		total.Quantity = roundQuantity(total.Quantity)
+		total.Cost = roundQuantity(total.Cost)
+		usage = append(usage, *total)
+	}
+	return usage, nil
+}
+
+// ConsumeConsumablesForOrder deducts an order's estimated consumable usage and
+// records its cost against the order
+func ConsumeConsumablesForOrder(tx *gorm.DB, order *models.Order) error {
+	usage, err := EstimateConsumables(tx, order)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range usage {
+		var consumable models.Consumable
+		if err := tx.First(&consumable, u.ConsumableID).Error; err != nil {
+			return err
+		}
+		_, err := postConsumableMovement(tx, &consumable, models.ConsumableUse, -u.Quantity, &order.ID, orderReference(order))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrderConsumableUsage returns the consumables actually deducted for an order
+func OrderConsumableUsage(tx *gorm.DB, orderID uint) ([]ConsumableUsage, error) {
+	var rows []ConsumableUsage
+	err := tx.Model(&models.ConsumableMovement{}).
+		Select("consumables.id AS consumable_id, consumables.name, consumables.unit, -SUM(consumable_movements.quantity) AS quantity, SUM(consumable_movements.cost) AS cost").
+		Joins("JOIN consumables ON consumables.id = consumable_movements.consumable_id").
+		Where("consumable_movements.order_id = ? AND consumable_movements.type = ?", orderID, models.ConsumableUse).
+		Group("consumables.id, consumables.name, consumables.unit").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// ForecastConsumables projects days of stock remaining from usage over the last `days` days
+// and suggests enough to cover the next `days` days above the reorder point
+func ForecastConsumables(tx *gorm.DB, days int) ([]ConsumableForecast, error) {
+	if days <= 0 {
+		return nil, errors.New("forecast window must be at least one day")
+	}
+
+	var consumables []models.Consumable
+	if err := tx.Order("name").Find(&consumables).Error; err != nil {
+		return nil, err
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	forecasts := make([]ConsumableForecast, 0, len(consumables))
+	for _, consumable := range consumables {
+		var used float64
+		err := tx.Model(&models.ConsumableMovement{}).
+			Select("COALESCE(-SUM(quantity), 0)").
+			Where("consumable_id = ? AND type = ? AND created_at >= ?", consumable.ID, models.ConsumableUse, since).
+			Scan(&used).Error
+		if err != nil {
+			return nil, err
+		}
+
+		forecast := ConsumableForecast{
+			Consumable: consumable,
+			DailyUsage: used / float64(days),
+		}
+		if forecast.DailyUsage > 0 {
+			remaining := math.Max(consumable.OnHand, 0) / forecast.DailyUsage
+			forecast.DaysRemaining = &remaining
+		}
+		needed := forecast.DailyUsage*float64(days) + consumable.ReorderPoint - consumable.OnHand
+		forecast.SuggestedReorder = math.Max(math.Ceil(needed), 0)
+		forecasts = append(forecasts, forecast)
+	}
+	return forecasts, nil
+}
+
+func estimateUsage(tx *gorm.DB, product, placement string, area PrintArea) ([]ConsumableUsage, error) {
+	var rates []models.ConsumableRate
+	err := tx.Where("(product = '' OR LOWER(product) = LOWER(?)) AND placement = ?", product, placement).
+		Order("consumable_id, product DESC").
+		Find(&rates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// A product-specific rate overrides the catch-all rate for the same consumable
+	chosen := make(map[uint]models.ConsumableRate)
+	var ids []uint
+	for _, rate := range rates {
+		if _, seen := chosen[rate.ConsumableID]; !seen {
+			chosen[rate.ConsumableID] = rate
+			ids = append(ids, rate.ConsumableID)
+		}
+	}
+
+	usage := make([]ConsumableUsage, 0, len(ids))
+	for _, id := range ids {
+		var consumable models.Consumable
+		if err := tx.First(&consumable, id).Error; err != nil {
+			return nil, err
+		}
+		rate := chosen[id]
+		quantity := roundQuantity(rate.PerPrint + rate.PerSquareCm*area.SquareCm())
+		usage = append(usage, ConsumableUsage{
+			ConsumableID: consumable.ID,
+			Name:         consumable.Name,
+			Unit:         consumable.Unit,
+			Quantity:     quantity,
+			Cost:         roundQuantity(quantity * consumable.UnitCost),
+		})
+	}
+	return usage, nil
+}
+
+func postConsumableMovement(tx *gorm.DB, consumable *models.Consumable, movementType string, quantity float64, orderID *uint, note string) (*models.Consumable, error) {
+	consumable.OnHand = roundQuantity(consumable.OnHand + quantity)
+	if err := tx.Save(consumable).Error; err != nil {
+		return nil, err
+	}
+	// Usage is an estimate, so stock is allowed to go negative rather than block fulfillment
+	if consumable.OnHand < 0 {
+		log.Printf("Consumable %s is below zero (%.2f %s); recount or receive stock", consumable.Name, consumable.OnHand, consumable.Unit)
+	}
+
+	movement := models.ConsumableMovement{
+		ConsumableID: consumable.ID,
+		Type:         movementType,
+		Quantity:     quantity,
+		Cost:         roundQuantity(math.Abs(quantity) * consumable.UnitCost),
+		OrderID:      orderID,
+		Note:         note,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	return consumable, nil
+}
+
+// roundQuantity keeps consumable quantities and costs to two decimal places
+func roundQuantity(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+
+	"printflow/models"
+)
+
+func TestOrderConsumesEstimatedSuppliesAtAverageCost(t *testing.T) {
+	tx := newTestDB(t)
+	ink, err := CreateConsumable(tx, "White ink", models.UnitMilliliters, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unitCost := range []float64{0.1, 0.3} {
+		if _, err := ReceiveConsumable(tx, ink.ID, 100, unitCost, "delivery"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The T-Shirt rate overrides the catch-all rate for the same consumable
+	for _, rate := range []models.ConsumableRate{
+		{ConsumableID: ink.ID, PerPrint: 1},
+		{ConsumableID: ink.ID, Product: "T-Shirt", PerPrint: 2},
+	} {
+		if _, err := SetConsumableRate(tx, rate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	order := createTestOrder(t, tx, models.StatusApproved, 100)
+	if err := ConsumeConsumablesForOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := OrderConsumableUsage(tx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Quantity != 20 || usage[0].Cost != 4 {
+		t.Fatalf("usage %+v, want 20 ml of white ink costing 4.00", usage)
+	}
+	if err := tx.First(ink, ink.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ink.OnHand != 180 || ink.UnitCost != 0.2 {
+		t.Errorf("%.2f ml on hand at %.2f, want 180 at 0.20", ink.OnHand, ink.UnitCost)
+	}
+}
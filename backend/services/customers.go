@@ -0,0 +1,367 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+var (
+	ErrUnknownCustomer = errors.New("customer not found")
+	ErrUnknownAddress  = errors.New("address not found")
+	ErrInvalidCustomer = errors.New("invalid customer")
+	ErrCustomerInUse   = errors.New("customer is in use")
+)
+
+// CustomerInput is a customer's contact details. Addresses are only read when the
+// customer is created; afterwards they are managed one at a time.
+type CustomerInput struct {
+	Name             string         `json:"name"`
+	Company          string         `json:"company"`
+	Email            string         `json:"email"`
+	Phone            string         `json:"phone"`
+	Notes            string         `json:"notes"`
+	DepositPercent   float64        `json:"depositPercent"`   // checked by the deposit_received guard
+	PaymentTermsDays int            `json:"paymentTermsDays"` // invoices are due this many days after issue
+	PriceList        string         `json:"priceList"`        // a price list from the price table
+	TaxExempt        bool           `json:"taxExempt"`
+	TaxCertificate   string         `json:"taxCertificate"` // required when taxExempt
+	Addresses        []AddressInput `json:"addresses"`
+}
+
+// AddressInput is a shipping or billing address. The first address of each kind
+// becomes the default unless another is marked default.
+type AddressInput struct {
+	Kind      string `json:"kind"` // "shipping" (default) or "billing"
+	Label     string `json:"label"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	City      string `json:"city"`
+	State     string `json:"state"`
+	Zip       string `json:"zip"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// CreateCustomer saves a customer and any addresses given with it
+func CreateCustomer(tx *gorm.DB, input CustomerInput) (*models.Customer, error) {
+	customer := models.Customer{}
+	if err := applyCustomerInput(&customer, input); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&customer).Error; err != nil {
+		return nil, err
+	}
+	for _, in := range input.Addresses {
+		if _, err := AddCustomerAddress(tx, customer.ID, in); err != nil {
+			return nil, err
+		}
+	}
+	return GetCustomer(tx, customer.ID)
+}
+
+// GetCustomer returns a customer with their addresses, defaults first
+func GetCustomer(tx *gorm.DB, id uint) (*models.Customer, error) {
+	var customer models.Customer
+	err := tx.Preload("Addresses", func(db *gorm.DB) *gorm.DB {
+		return db.Order("kind, is_default DESC, id")
+	}).First(&customer, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownCustomer
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// UpdateCustomer replaces a customer's contact details
+func UpdateCustomer(tx *gorm.DB, id uint, input CustomerInput) (*models.Customer, error) {
+	customer, err := GetCustomer(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyCustomerInput(customer, input); err != nil {
+		return nil, err
+	}
+	if err := tx.Omit("Addresses").Save(customer).Error; err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+// DeleteCustomer removes a customer and their addresses. Customers with orders are
+// kept so the orders can still be shipped and reported on.
+func DeleteCustomer(tx *gorm.DB, id uint) error {
+	if _, err := GetCustomer(tx, id); err != nil {
+		return err
+	}
+	var orders int64
+	if err := tx.Unscoped().Model(&models.Order{}).Where("customer_id = ?", id).Count(&orders).Error; err != nil {
+		return err
+	}
+	if orders > 0 {
+		return fmt.Errorf("%w: customer has %d orders", ErrCustomerInUse, orders)
+	}
+	if err := tx.Where("customer_id = ?", id).Delete(&models.CustomerAddress{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Customer{}, id).Error
+}
+
+// AddCustomerAddress saves a new address for a customer
+func AddCustomerAddress(tx *gorm.DB, customerID uint, input AddressInput) (*models.CustomerAddress, error) {
+	if _, err := GetCustomer(tx, customerID); err != nil {
+		return nil, err
+	}
+	address := models.CustomerAddress{CustomerID: customerID}
+	if err := applyAddressInput(&address, input); err != nil {
+		return nil, err
+	}
+
+	// The first address of a kind is the default until another is chosen
+	if !address.IsDefault {
+		if _, err := DefaultCustomerAddress(tx, customerID, address.Kind); errors.Is(err, ErrUnknownAddress) {
+			address.IsDefault = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Create(&address).Error; err != nil {
+		return nil, err
+	}
+	if err := clearOtherDefaults(tx, &address); err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// UpdateCustomerAddress replaces one of a customer's addresses
+func UpdateCustomerAddress(tx *gorm.DB, customerID, addressID uint, input AddressInput) (*models.CustomerAddress, error) {
+	address, err := customerAddress(tx, customerID, addressID)
+	if err != nil {
+		return nil, err
+	}
+	wasDefault, kind := address.IsDefault, address.Kind
+	if err := applyAddressInput(address, input); err != nil {
+		return nil, err
+	}
+	if address.Kind != kind {
+		return nil, fmt.Errorf("%w: a %s address cannot become a %s address; add a new one", ErrInvalidCustomer, kind, address.Kind)
+	}
+	// A default can only be replaced by marking another address default
+	address.IsDefault = address.IsDefault || wasDefault
+	if err := tx.Save(address).Error; err != nil {
+		return nil, err
+	}
+	if err := clearOtherDefaults(tx, address); err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// DeleteCustomerAddress removes an address no order ships to. Deleting a default
+// promotes the oldest remaining address of the same kind.
+func DeleteCustomerAddress(tx *gorm.DB, customerID, addressID uint) error {
+	address, err := customerAddress(tx, customerID, addressID)
+	if err != nil {
+		return err
+	}
+	var orders int64
+	if err := tx.Unscoped().Model(&models.Order{}).Where("shipping_address_id = ?", address.ID).Count(&orders).Error; err != nil {
+		return err
+	}
+	if orders > 0 {
+		return fmt.Errorf("%w: %d orders ship to this address", ErrCustomerInUse, orders)
+	}
+	if err := tx.Delete(address).Error; err != nil {
+		return err
+	}
+	if !address.IsDefault {
+		return nil
+	}
+
+	var next models.CustomerAddress
+	err = tx.Where("customer_id = ? AND kind = ?", customerID, address.Kind).Order("id").First(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&next).Update("is_default", true).Error
+}
+
+// DefaultCustomerAddress returns a customer's default address of kind
+func DefaultCustomerAddress(tx *gorm.DB, customerID uint, kind string) (*models.CustomerAddress, error) {
+	var address models.CustomerAddress
+	err := tx.Where("customer_id = ? AND kind = ? AND is_default = ?", customerID, kind, true).First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownAddress
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// ResolveShippingAddress checks that addressID is one of the customer's shipping
+// addresses, or picks their default shipping address when addressID is zero. It
+// returns nil when the customer has no shipping address.
+func ResolveShippingAddress(tx *gorm.DB, customerID, addressID uint) (*models.CustomerAddress, error) {
+	if addressID == 0 {
+		address, err := DefaultCustomerAddress(tx, customerID, models.AddressShipping)
+		if errors.Is(err, ErrUnknownAddress) {
+			return nil, nil
+		}
+		return address, err
+	}
+	address, err := customerAddress(tx, customerID, addressID)
+	if err != nil {
+		return nil, err
+	}
+	if address.Kind != models.AddressShipping {
+		return nil, fmt.Errorf("%w: address %d is a %s address", ErrInvalidCustomer, addressID, address.Kind)
+	}
+	return address, nil
+}
+
+// hasShippingAddress is the has_shipping_address guard: the order must have a stored
+// address to ship to
+func hasShippingAddress(tx *gorm.DB, order *models.Order) error {
+	_, ok, err := OrderShipTo(tx, order)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("order has no shipping address")
+	}
+	return nil
+}
+
+// OrderShipTo returns the label address for the order's stored shipping address, and
+// false when the order has none
+func OrderShipTo(tx *gorm.DB, order *models.Order) (LabelInput, bool, error) {
+	if order.ShippingAddressID == nil {
+		return LabelInput{}, false, nil
+	}
+	var address models.CustomerAddress
+	err := tx.First(&address, *order.ShippingAddressID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return LabelInput{}, false, nil
+	}
+	if err != nil {
+		return LabelInput{}, false, err
+	}
+	// Ship to the customer by name when the address does not name someone
+	name := address.Name
+	if name == "" {
+		var customer models.Customer
+		if err := tx.First(&customer, address.CustomerID).Error; err == nil {
+			name = customer.Name
+			if name == "" {
+				name = customer.Company
+			}
+		}
+	}
+	return LabelInput{
+		Name:    name,
+		Address: address.Address,
+		City:    address.City,
+		State:   address.State,
+		Zip:     address.Zip,
+	}, true, nil
+}
+
+// CustomerOrders returns a customer's orders, newest first
+func CustomerOrders(tx *gorm.DB, customerID uint) ([]models.Order, error) {
+	if _, err := GetCustomer(tx, customerID); err != nil {
+		return nil, err
+	}
+	var orders []models.Order
+	err := tx.Preload("Lines").Where("customer_id = ?", customerID).Order("id DESC").Find(&orders).Error
+	return orders, err
+}
+
+func customerAddress(tx *gorm.DB, customerID, addressID uint) (*models.CustomerAddress, error) {
+	var address models.CustomerAddress
+	err := tx.Where("customer_id = ?", customerID).First(&address, addressID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownAddress
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// clearOtherDefaults keeps address the only default of its kind once it is marked default
+func clearOtherDefaults(tx *gorm.DB, address *models.CustomerAddress) error {
+	if !address.IsDefault {
+		return nil
+	}
+	return tx.Model(&models.CustomerAddress{}).
+		Where("customer_id = ? AND kind = ? AND id <> ?", address.CustomerID, address.Kind, address.ID).
+		Update("is_default", false).Error
+}
+
+// normalizeEmail is the form customer emails are stored and looked up in
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func applyCustomerInput(customer *models.Customer, input CustomerInput) error {
+	customer.Name = strings.TrimSpace(input.Name)
+	customer.Company = strings.TrimSpace(input.Company)
+	customer.Email = normalizeEmail(input.Email)
+	customer.Phone = strings.TrimSpace(input.Phone)
+	customer.Notes = input.Notes
+	customer.DepositPercent = input.DepositPercent
+	customer.PaymentTermsDays = input.PaymentTermsDays
+	customer.PriceList = strings.TrimSpace(input.PriceList)
+	customer.TaxExempt = input.TaxExempt
+	customer.TaxCertificate = strings.TrimSpace(input.TaxCertificate)
+	if customer.Name == "" && customer.Company == "" {
+		return fmt.Errorf("%w: a name or company is required", ErrInvalidCustomer)
+	}
+	if customer.Email != "" && !strings.Contains(customer.Email, "@") {
+		return fmt.Errorf("%w: %q is not an email address", ErrInvalidCustomer, customer.Email)
+	}
+	if customer.DepositPercent < 0 || customer.DepositPercent > 100 {
+		return fmt.Errorf("%w: depositPercent must be between 0 and 100", ErrInvalidCustomer)
+	}
+	if customer.PaymentTermsDays < 0 {
+		return fmt.Errorf("%w: paymentTermsDays cannot be negative", ErrInvalidCustomer)
+	}
+	if customer.PriceList != "" && !HasPriceList(customer.PriceList) {
+		return fmt.Errorf("%w: price list %q is not in the price table", ErrInvalidCustomer, customer.PriceList)
+	}
+	if customer.TaxExempt && customer.TaxCertificate == "" {
+		return fmt.Errorf("%w: a tax exempt customer needs an exemption certificate number", ErrInvalidCustomer)
+	}
+	return nil
+}
+
+func applyAddressInput(address *models.CustomerAddress, input AddressInput) error {
+	kind := strings.ToLower(strings.TrimSpace(input.Kind))
+	if kind == "" {
+		kind = models.AddressShipping
+	}
+	if kind != models.AddressShipping && kind != models.AddressBilling {
+		return fmt.Errorf("%w: address kind must be %s or %s", ErrInvalidCustomer, models.AddressShipping, models.AddressBilling)
+	}
+	if strings.TrimSpace(input.Address) == "" || strings.TrimSpace(input.City) == "" {
+		return fmt.Errorf("%w: address and city are required", ErrInvalidCustomer)
+	}
+	address.Kind = kind
+	address.Label = strings.TrimSpace(input.Label)
+	address.Name = strings.TrimSpace(input.Name)
+	address.Address = strings.TrimSpace(input.Address)
+	address.City = strings.TrimSpace(input.City)
+	address.State = strings.TrimSpace(input.State)
+	address.Zip = strings.TrimSpace(input.Zip)
+	address.IsDefault = input.IsDefault
+	return nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"printflow/models"
+)
+
+func TestCustomerValidation(t *testing.T) {
+	tx := newTestDB(t)
+	cases := []struct {
+		name  string
+		input CustomerInput
+	}{
+		{"no name or company", CustomerInput{Email: "a@example.com"}},
+		{"bad email", CustomerInput{Name: "Ann", Email: "ann.example.com"}},
+		{"deposit over 100%", CustomerInput{Name: "Ann", DepositPercent: 120}},
+		{"exempt without certificate", CustomerInput{Name: "Ann", TaxExempt: true}},
+		{"address without city", CustomerInput{Name: "Ann", Addresses: []AddressInput{{Address: "1 Main St"}}}},
+	}
+	for _, tc := range cases {
+		if _, err := CreateCustomer(tx, tc.input); !errors.Is(err, ErrInvalidCustomer) {
+			t.Errorf("%s: got %v, want ErrInvalidCustomer", tc.name, err)
+		}
+	}
+
+	customer, err := CreateCustomer(tx, CustomerInput{Company: "Acme", Email: " Orders@Acme.COM "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.Email != "orders@acme.com" {
+		t.Errorf("email stored as %q, want it trimmed and lowercased", customer.Email)
+	}
+}
+
+func TestAddressBookKeepsOneDefaultPerKind(t *testing.T) {
+	tx := newTestDB(t)
+	customer, err := CreateCustomer(tx, CustomerInput{Name: "Ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(input AddressInput) *models.CustomerAddress {
+		t.Helper()
+		address, err := AddCustomerAddress(tx, customer.ID, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return address
+	}
+	defaultShipping := func() uint {
+		t.Helper()
+		address, err := DefaultCustomerAddress(tx, customer.ID, models.AddressShipping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return address.ID
+	}
+
+	home := add(AddressInput{Label: "Home", Address: "1 Main St", City: "Springfield"})
+	billing := add(AddressInput{Kind: models.AddressBilling, Address: "2 Bank St", City: "Springfield"})
+	if got := defaultShipping(); got != home.ID {
+		t.Errorf("default shipping address %d, want the first one %d", got, home.ID)
+	}
+	if !billing.IsDefault {
+		t.Error("the first billing address is not its kind's default")
+	}
+
+	warehouse := add(AddressInput{Label: "Warehouse", Address: "3 Dock Rd", City: "Shelbyville", IsDefault: true})
+	if got := defaultShipping(); got != warehouse.ID {
+		t.Errorf("default shipping address %d, want the newly marked %d", got, warehouse.ID)
+	}
+
+	// An order without a chosen address ships to the default, and a billing address
+	// cannot be shipped to
+	if address, err := ResolveShippingAddress(tx, customer.ID, 0); err != nil || address.ID != warehouse.ID {
+		t.Errorf("resolved %v (%v), want the default %d", address, err, warehouse.ID)
+	}
+	if _, err := ResolveShippingAddress(tx, customer.ID, billing.ID); !errors.Is(err, ErrInvalidCustomer) {
+		t.Errorf("shipping to a billing address: got %v, want ErrInvalidCustomer", err)
+	}
+
+	// An address an order ships to stays; deleting the default promotes the oldest left
+	order := createTestOrder(t, tx, models.StatusCreated, 100)
+	order.CustomerID, order.ShippingAddressID = &customer.ID, &home.ID
+	tx.Save(order)
+	if err := DeleteCustomerAddress(tx, customer.ID, home.ID); !errors.Is(err, ErrCustomerInUse) {
+		t.Errorf("deleting an address in use: got %v, want ErrCustomerInUse", err)
+	}
+	if err := DeleteCustomerAddress(tx, customer.ID, warehouse.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultShipping(); got != home.ID {
+		t.Errorf("default after deleting it %d, want %d", got, home.ID)
+	}
+	if err := DeleteCustomer(tx, customer.ID); !errors.Is(err, ErrCustomerInUse) {
+		t.Errorf("deleting a customer with orders: got %v, want ErrCustomerInUse", err)
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"printflow/models"
+)
+
+var ErrCycleCountPosted = errors.New("cycle count has already been posted")
+
+// CycleCountScope selects which SKUs go on a count sheet. Empty fields match everything.
+type CycleCountScope struct {
+	LocationID       uint   `json:"locationId"`
+	InventoryItemIDs []uint `json:"inventoryItemIds"`
+	Product          string `json:"product"`
+	Notes            string `json:"notes"`
+}
+
+// CountInput is a counted quantity entered against a count sheet line
+type CountInput struct {
+	LineID          uint   `json:"lineId"`
+	CountedQuantity int    `json:"countedQuantity"`
+	Reason          string `json:"reason"`
+}
+
+// CreateCycleCount snapshots expected on-hand quantities for the scoped SKUs at a location
+func CreateCycleCount(tx *gorm.DB, scope CycleCountScope) (*models.CycleCount, error) {
+	location, err := resolveLocation(tx, scope.LocationID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := tx.Order("sku")
+	if len(scope.InventoryItemIDs) > 0 {
+		query = query.Where("id IN ?", scope.InventoryItemIDs)
+	}
+	if scope.Product != "" {
+		query = query.Where("LOWER(product) = LOWER(?)", scope.Product)
+	}
+	var items []models.InventoryItem
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no SKUs match the count scope")
+	}
+
+	count := models.CycleCount{
+		LocationID: location.ID,
+		Status:     models.CycleCountOpen,
+		Notes:      scope.Notes,
+	}
+	for _, item := range items {
+		stock, err := locationStock(tx, item.ID, location.ID)
+		if err != nil {
+			return nil, err
+		}
+		count.Lines = append(count.Lines, models.CycleCountLine{
+			InventoryItemID:  item.ID,
+			SKU:              item.SKU,
+			ExpectedQuantity: stock.OnHand,
+		})
+	}
+
+	if err := tx.Create(&count).Error; err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// RecordCounts stores counted quantities and their variances against the snapshot
+func RecordCounts(tx *gorm.DB, count *models.CycleCount, counts []CountInput) error {
+	if count.Status != models.CycleCountOpen {
+		return ErrCycleCountPosted
+	}
+
+	lines := make(map[uint]*models.CycleCountLine, len(count.Lines))
+	for i := range count.Lines {
+		lines[count.Lines[i].ID] = &count.Lines[i]
+	}
+
+	for _, input := range counts {
+		line, ok := lines[input.LineID]
+		if !ok {
+			return fmt.Errorf("line %d is not on cycle count %d", input.LineID, count.ID)
+		}
+		if input.CountedQuantity < 0 {
+			return fmt.Errorf("line %d: counted quantity cannot be negative", input.LineID)
+		}
+		reason := strings.ToUpper(strings.TrimSpace(input.Reason))
+		if reason != "" && !ValidAdjustmentReason(reason) {
+			return fmt.Errorf("line %d: %w", input.LineID, ErrInvalidReason)
+		}
+
+		counted := input.CountedQuantity
+		line.CountedQuantity = &counted
+		line.Variance = counted - line.ExpectedQuantity
+		line.Reason = reason
+		if err := tx.Save(line).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostCycleCount books every counted variance as a stock adjustment. Lines without
+// a reason default to FOUND for overages and SHRINKAGE for shortages.
+func PostCycleCount(tx *gorm.DB, count *models.CycleCount) error {
+	if count.Status != models.CycleCountOpen {
+		return ErrCycleCountPosted
+	}
+
+	reference := fmt.Sprintf("COUNT-%d", count.ID)
+	for i := range count.Lines {
+		line := &count.Lines[i]
+		if line.CountedQuantity == nil || line.Variance == 0 {
+			continue
+		}
+
+		if line.Reason == "" {
+			line.Reason = models.ReasonShrinkage
+			if line.Variance > 0 {
+				line.Reason = models.ReasonFound
+			}
+			if err := tx.Save(line).Error; err != nil {
+				return err
+			}
+		}
+
+		_, err := AdjustStock(tx, line.InventoryItemID, MovementInput{
+			Quantity:   line.Variance,
+			LocationID: count.LocationID,
+			Reason:     line.Reason,
+			Reference:  reference,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", line.SKU, err)
+		}
+	}
+
+	now := time.Now()
+	count.Status = models.CycleCountPosted
+	count.PostedAt = &now
+	return tx.Omit(clause.Associations).Save(count).Error
+}
+
+// WriteCountSheetCSV writes a count sheet as CSV with an empty column for counted quantities
+func WriteCountSheetCSV(w io.Writer, count *models.CycleCount, location *models.Location) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"count_id", "location", "line_id", "sku", "expected", "counted", "variance", "reason"})
+	for _, line := range count.Lines {
+		counted, variance := "", ""
+		if line.CountedQuantity != nil {
+			counted = strconv.Itoa(*line.CountedQuantity)
+			variance = strconv.Itoa(line.Variance)
+		}
+		writer.Write([]string{
+			strconv.Itoa(int(count.ID)),
+			location.Code,
+			strconv.Itoa(int(line.ID)),
+			line.SKU,
+			strconv.Itoa(line.ExpectedQuantity),
+			counted,
+			variance,
+			line.Reason,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteCountSheetPDF renders a printable count sheet for the warehouse floor
+func WriteCountSheetPDF(w io.Writer, count *models.CycleCount, location *models.Location) error {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.Cell(0, 12, "PRINTFLOW CYCLE COUNT SHEET")
+	pdf.Ln(14)
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.MultiCell(0, 6, fmt.Sprintf(
+		"Count #%d\nLocation: %s (%s)\nGenerated: %s",
+		count.ID,
+		location.Name,
+		location.Code,
+		count.CreatedAt.Format("2006-01-02 15:04"),
+	), "", "", false)
+	pdf.Ln(6)
+
+	widths := []float64{15, 75, 25, 25, 50}
+	headers := []string{"Line", "SKU", "Expected", "Counted", "Notes"}
+	pdf.SetFont("Helvetica", "B", 11)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 8, h, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 11)
+	for _, line := range count.Lines {
+		counted := ""
+		if line.CountedQuantity != nil {
+			counted = strconv.Itoa(*line.CountedQuantity)
+		}
+		pdf.CellFormat(widths[0], 8, strconv.Itoa(int(line.ID)), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[1], 8, line.SKU, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 8, strconv.Itoa(line.ExpectedQuantity), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[3], 8, counted, "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[4], 8, "", "1", 0, "L", false, 0, "")
+		pdf.Ln(-1)
+	}
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+const (
+	DiscountPercentage    = "percentage"     // Percent off the matching lines
+	DiscountFixed         = "fixed"          // Amount off the matching lines
+	DiscountFreePlacement = "free_placement" // one placement free on every matching garment
+	DiscountBuyXGetY      = "buy_x_get_y"    // for every Buy garments, Get more free
+)
+
+// DiscountRule is a discount from the price table. Rules with a code only apply to
+// orders that give it; the others apply to every order that meets their conditions.
+// Conditions are checked against the lines of the rule's products.
+type DiscountRule struct {
+	Name      string  `json:"name"`
+	Code      string  `json:"code,omitempty"`
+	Type      string  `json:"type"`
+	Percent   float64 `json:"percent,omitempty"`   // percentage
+	Amount    float64 `json:"amount,omitempty"`    // fixed
+	Placement string  `json:"placement,omitempty"` // free_placement; empty frees the cheapest of two or more
+	Buy       int     `json:"buy,omitempty"`       // buy_x_get_y; the cheapest garments are the free ones
+	Get       int     `json:"get,omitempty"`
+
+	Products    []string `json:"products,omitempty"`   // empty for every product
+	PriceLists  []string `json:"priceLists,omitempty"` // only customers on these price lists
+	MinQuantity int      `json:"minQuantity,omitempty"`
+	MinSubtotal float64  `json:"minSubtotal,omitempty"`
+	StartsOn    string   `json:"startsOn,omitempty"` // YYYY-MM-DD, inclusive
+	EndsOn      string   `json:"endsOn,omitempty"`
+
+	starts, ends time.Time
+}
+
+// AppliedDiscount is a discount taken off an order's price
+type AppliedDiscount struct {
+	Name   string  `json:"name"`
+	Code   string  `json:"code,omitempty"`
+	Type   string  `json:"type"`
+	Amount float64 `json:"amount"`
+}
+
+func validateDiscounts(rules []DiscountRule, priceLists map[string]bool) error {
+	codes := make(map[string]bool)
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("discount %d needs a name", i+1)
+		}
+		switch rule.Type {
+		case DiscountPercentage:
+			if rule.Percent <= 0 || rule.Percent > 100 {
+				return fmt.Errorf("discount %q needs a percent above 0 and at most 100", rule.Name)
+			}
+		case DiscountFixed:
+			if rule.Amount <= 0 {
+				return fmt.Errorf("discount %q needs a positive amount", rule.Name)
+			}
+		case DiscountFreePlacement:
+		case DiscountBuyXGetY:
+			if rule.Buy <= 0 || rule.Get <= 0 {
+				return fmt.Errorf("discount %q needs positive buy and get quantities", rule.Name)
+			}
+		default:
+			return fmt.Errorf("discount %q has unknown type %q", rule.Name, rule.Type)
+		}
+
+		if rule.Code != "" {
+			key := strings.ToUpper(rule.Code)
+			if codes[key] {
+				return fmt.Errorf("discount code %q is used twice", rule.Code)
+			}
+			codes[key] = true
+		}
+		for _, list := range rule.PriceLists {
+			if !priceLists[strings.ToLower(list)] {
+				return fmt.Errorf("discount %q names unknown price list %q", rule.Name, list)
+			}
+		}
+		if rule.MinQuantity < 0 || rule.MinSubtotal < 0 {
+			return fmt.Errorf("discount %q has a negative minimum", rule.Name)
+		}
+
+		var err error
+		if rule.StartsOn != "" {
+			if rule.starts, err = time.ParseInLocation("2006-01-02", rule.StartsOn, time.Local); err != nil {
+				return fmt.Errorf("discount %q: startsOn should be YYYY-MM-DD", rule.Name)
+			}
+		}
+		if rule.EndsOn != "" {
+			if rule.ends, err = time.ParseInLocation("2006-01-02", rule.EndsOn, time.Local); err != nil {
+				return fmt.Errorf("discount %q: endsOn should be YYYY-MM-DD", rule.Name)
+			}
+			rule.ends = rule.ends.AddDate(0, 0, 1)
+		}
+	}
+	return nil
+}
+
+// applyDiscounts takes the table's discounts off a priced order, in the order the
+// table lists them. Percentages come off what earlier discounts left of each line,
+// and no discount takes a line below zero.
+func applyDiscounts(table *PriceTable, list *PriceList, lines []models.OrderLine, price *Price, options PriceOptions) error {
+	codes := make(map[string]bool)
+	for _, code := range options.DiscountCodes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if table.discountByCode(code) == nil {
+			return fmt.Errorf("%w: unknown discount code %q", ErrInvalidOrder, code)
+		}
+		codes[code] = true
+	}
+	date := options.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	remaining := make([]float64, len(lines))
+	for i := range lines {
+		remaining[i] = price.Lines[i].LineTotal
+	}
+	for i := range table.Discounts {
+		rule := &table.Discounts[i]
+		if rule.Code != "" && !codes[strings.ToUpper(rule.Code)] {
+			continue
+		}
+		amounts, reason := rule.lineDiscounts(table, list, lines, price, remaining, date)
+		applied := AppliedDiscount{Name: rule.Name, Code: rule.Code, Type: rule.Type}
+		for j, amount := range amounts {
+			amount = roundCents(amount)
+			if amount > remaining[j] {
+				amount = remaining[j]
+			}
+			remaining[j] = roundCents(remaining[j] - amount)
+			price.Lines[j].Discount = roundCents(price.Lines[j].Discount + amount)
+			applied.Amount += amount
+		}
+		applied.Amount = roundCents(applied.Amount)
+		if applied.Amount <= 0 {
+			if rule.Code != "" {
+				if reason == "" {
+					reason = "takes nothing off this order"
+				}
+				price.unapplied = append(price.unapplied, fmt.Sprintf("discount code %s %s", rule.Code, reason))
+			}
+			continue
+		}
+		price.Discounts = append(price.Discounts, applied)
+		price.DiscountTotal += applied.Amount
+	}
+	price.DiscountTotal = roundCents(price.DiscountTotal)
+	return nil
+}
+
+// lineDiscounts works out what the rule takes off each line, given what is remaining
+// of each after earlier discounts, or why it does not apply
+func (rule *DiscountRule) lineDiscounts(table *PriceTable, list *PriceList, lines []models.OrderLine, price *Price, remaining []float64, date time.Time) ([]float64, string) {
+	if !rule.starts.IsZero() && date.Before(rule.starts) {
+		return nil, "starts on " + rule.StartsOn
+	}
+	if !rule.ends.IsZero() && !date.Before(rule.ends) {
+		return nil, "ended on " + rule.EndsOn
+	}
+	if len(rule.PriceLists) > 0 && (list == nil || !containsFold(rule.PriceLists, list.Name)) {
+		return nil, "is only for customers on the " + strings.Join(rule.PriceLists, " or ") + " price list"
+	}
+
+	var matching []int
+	quantity, subtotal := 0, 0.0
+	for i, line := range lines {
+		if len(rule.Products) == 0 || containsFold(rule.Products, line.Product) {
+			matching = append(matching, i)
+			quantity += line.Quantity
+			subtotal += price.Lines[i].LineTotal
+		}
+	}
+	if len(matching) == 0 {
+		return nil, "is only for " + strings.Join(rule.Products, ", ")
+	}
+	if quantity < rule.MinQuantity {
+		return nil, fmt.Sprintf("needs at least %d garments", rule.MinQuantity)
+	}
+	if roundCents(subtotal) < rule.MinSubtotal {
+		return nil, fmt.Sprintf("needs a subtotal of at least %.2f", rule.MinSubtotal)
+	}
+
+	amounts := make([]float64, len(lines))
+	switch rule.Type {
+	case DiscountPercentage:
+		for _, i := range matching {
+			amounts[i] = remaining[i] * rule.Percent / 100
+		}
+
+	case DiscountFixed:
+		// Shared between the lines by their totals; the last line takes the rounding
+		if subtotal <= 0 {
+			break
+		}
+		off := rule.Amount
+		if off > subtotal {
+			off = subtotal
+		}
+		left := roundCents(off)
+		for n, i := range matching {
+			share := roundCents(off * price.Lines[i].LineTotal / subtotal)
+			if n == len(matching)-1 {
+				share = left
+			}
+			amounts[i] = share
+			left = roundCents(left - share)
+		}
+
+	case DiscountFreePlacement:
+		for _, i := range matching {
+			placements := linePlacements(lines[i])
+			free, found := 0.0, false
+			for _, placement := range placements {
+				p := table.placementPrice(placement, list)
+				if rule.Placement != "" {
+					if strings.EqualFold(placement, rule.Placement) {
+						free, found = p, true
+					}
+				} else if len(placements) > 1 && (!found || p < free) {
+					free, found = p, true
+				}
+			}
+			// The quantity break already came off the placement's price
+			amounts[i] = free * (1 - price.QuantityBreakPercent/100) * float64(lines[i].Quantity)
+		}
+
+	case DiscountBuyXGetY:
+		freeCount := quantity / (rule.Buy + rule.Get) * rule.Get
+		cheapest := append([]int{}, matching...)
+		sort.SliceStable(cheapest, func(a, b int) bool {
+			return price.Lines[cheapest[a]].UnitPrice < price.Lines[cheapest[b]].UnitPrice
+		})
+		for _, i := range cheapest {
+			n := lines[i].Quantity
+			if n > freeCount {
+				n = freeCount
+			}
+			amounts[i] = price.Lines[i].UnitPrice * float64(n)
+			freeCount -= n
+		}
+	}
+	return amounts, ""
+}
+
+func (t *PriceTable) discountByCode(code string) *DiscountRule {
+	for i := range t.Discounts {
+		if t.Discounts[i].Code != "" && strings.EqualFold(t.Discounts[i].Code, code) {
+			return &t.Discounts[i]
+		}
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderDiscountCodes returns the codes of the discounts applied to an order, so it can
+// be repriced with them
+func OrderDiscountCodes(tx *gorm.DB, orderID uint) ([]string, error) {
+	var codes []string
+	err := tx.Model(&models.OrderDiscount{}).Where("order_id = ? AND code <> ''", orderID).Order("id").Pluck("code", &codes).Error
+	return codes, err
+}
+
+// OrderDiscounts returns the discounts applied to an order, in the order they were applied
+func OrderDiscounts(tx *gorm.DB, order *models.Order) ([]models.OrderDiscount, error) {
+	if order.Discounts != nil {
+		return order.Discounts, nil
+	}
+	discounts := []models.OrderDiscount{}
+	if err := tx.Where("order_id = ?", order.ID).Order("id").Find(&discounts).Error; err != nil {
+		return nil, err
+	}
+	return discounts, nil
+}
+
+// replaceOrderDiscounts stores the discounts applyPrice put on an already saved order
+func replaceOrderDiscounts(tx *gorm.DB, order *models.Order) error {
+	if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderDiscount{}).Error; err != nil {
+		return err
+	}
+	for i := range order.Discounts {
+		order.Discounts[i].OrderID = order.ID
+		if err := tx.Create(&order.Discounts[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiscountUsage is how much one discount took off orders in a period
+type DiscountUsage struct {
+	Name   string  `json:"name"`
+	Code   string  `json:"code,omitempty"`
+	Type   string  `json:"type"`
+	Orders int     `json:"orders"`
+	Amount float64 `json:"amount"`
+}
+
+// DiscountReport totals the discounts given on orders created within a period
+type DiscountReport struct {
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Discounts []DiscountUsage `json:"discounts"`
+	Orders    int             `json:"orders"` // orders with any discount
+	Total     float64         `json:"total"`
+}
+
+// DiscountsGiven reports the discounts on orders created between from and to,
+// leaving out cancelled and deleted orders
+func DiscountsGiven(tx *gorm.DB, from, to time.Time) (*DiscountReport, error) {
+	scope := tx.Table("order_discounts").
+		Joins("JOIN orders ON orders.id = order_discounts.order_id").
+		Where("orders.deleted_at IS NULL AND orders.status <> ? AND orders.created_at >= ? AND orders.created_at <= ?", models.StatusCancelled, from, to)
+
+	report := &DiscountReport{From: from, To: to, Discounts: []DiscountUsage{}}
+	err := scope.Session(&gorm.Session{}).
+		Select("order_discounts.name, order_discounts.code, order_discounts.type, COUNT(DISTINCT order_discounts.order_id) AS orders, SUM(order_discounts.amount) AS amount").
+		Group("order_discounts.name, order_discounts.code, order_discounts.type").
+		Order("amount DESC, order_discounts.name").
+		Scan(&report.Discounts).Error
+	if err != nil {
+		return nil, err
+	}
+	var orders int64
+	if err := scope.Session(&gorm.Session{}).Distinct("order_discounts.order_id").Count(&orders).Error; err != nil {
+		return nil, err
+	}
+	report.Orders = int(orders)
+	for i := range report.Discounts {
+		report.Discounts[i].Amount = roundCents(report.Discounts[i].Amount)
+		report.Total += report.Discounts[i].Amount
+	}
+	report.Total = roundCents(report.Total)
+	return report, nil
+}
+
+// WriteDiscountCSV writes a discount report as CSV
+func WriteDiscountCSV(w io.Writer, report *DiscountReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"discount", "code", "type", "orders", "amount"})
+	for _, d := range report.Discounts {
+		writer.Write([]string{d.Name, d.Code, d.Type, strconv.Itoa(d.Orders), formatMoney(d.Amount)})
+	}
+	writer.Write([]string{"TOTAL", "", "", strconv.Itoa(report.Orders), formatMoney(report.Total)})
+	writer.Flush()
+	return writer.Error()
+}
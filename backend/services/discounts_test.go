@@ -0,0 +1,79 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"printflow/models"
+)
+
+// Percentages stack on what earlier discounts left, and a discount listed after the
+// line is free takes nothing
+func TestDiscountsApplyToTheRemainder(t *testing.T) {
+	table := &PriceTable{Discounts: []DiscountRule{
+		{Name: "Half off", Type: DiscountPercentage, Percent: 50},
+		{Name: "Another half", Type: DiscountPercentage, Percent: 50},
+		{Name: "Everything else", Code: "FREE", Type: DiscountPercentage, Percent: 100},
+		{Name: "Ten off", Code: "TEN", Type: DiscountFixed, Amount: 10},
+	}}
+	if err := validateDiscounts(table.Discounts, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := []models.OrderLine{{Product: "T-Shirt", Quantity: 10}}
+	priced := func() *Price {
+		return &Price{Lines: []LinePrice{{UnitPrice: 10, LineTotal: 100}}, Subtotal: 100, Discounts: []AppliedDiscount{}}
+	}
+
+	price := priced()
+	if err := applyDiscounts(table, nil, lines, price, PriceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(price.Discounts) != 2 || price.Discounts[0].Amount != 50 || price.Discounts[1].Amount != 25 || price.DiscountTotal != 75 {
+		t.Errorf("got %+v, want 50 then 25 off", price.Discounts)
+	}
+
+	price = priced()
+	if err := applyDiscounts(table, nil, lines, price, PriceOptions{DiscountCodes: []string{"free", "ten"}}); err != nil {
+		t.Fatal(err)
+	}
+	if price.DiscountTotal != 100 || price.Lines[0].Discount != 100 {
+		t.Errorf("took %.2f off a line of 100, want all of it and no more", price.DiscountTotal)
+	}
+	if err := checkDiscountCodes(price); err == nil || !strings.Contains(err.Error(), "TEN takes nothing off this order") {
+		t.Errorf("got %v, want TEN reported as taking nothing off", err)
+	}
+}
+
+func TestPriceWithTableDiscounts(t *testing.T) {
+	lines := []models.OrderLine{{Product: "T-Shirt", Size: "M", Quantity: 48, Placements: "front,sleeve"}}
+	price, err := PriceOrderLines(lines, PriceOptions{DiscountCodes: []string{"welcome10", "SAVE25"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 48 shirts at 13.95 after the 10% break. The sleeve print is free, the welcome
+	// 10% comes off the rest, and SAVE25 qualifies on the undiscounted subtotal.
+	want := []AppliedDiscount{
+		{Name: "Free sleeve print on 48+", Type: DiscountFreePlacement, Amount: 108},
+		{Name: "Welcome 10% off", Code: "WELCOME10", Type: DiscountPercentage, Amount: 56.16},
+		{Name: "25 off orders over 500", Code: "SAVE25", Type: DiscountFixed, Amount: 25},
+	}
+	if len(price.Discounts) != len(want) {
+		t.Fatalf("got discounts %+v, want %+v", price.Discounts, want)
+	}
+	for i, d := range price.Discounts {
+		if d != want[i] {
+			t.Errorf("discount %d is %+v, want %+v", i, d, want[i])
+		}
+	}
+	if price.Subtotal != 669.6 || price.DiscountTotal != 189.16 || price.Total != 480.44 {
+		t.Errorf("subtotal %.2f, discounts %.2f, total %.2f, want 669.60, 189.16 and 480.44", price.Subtotal, price.DiscountTotal, price.Total)
+	}
+
+	caps := []models.OrderLine{{Product: "Cap", Size: "OS", Quantity: 11}}
+	if price, err = PriceOrderLines(caps, PriceOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(price.Discounts) != 1 || price.Discounts[0].Amount != price.Lines[0].UnitPrice {
+		t.Errorf("11 caps got discounts %+v, want one cap free", price.Discounts)
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"printflow/models"
+)
+
+const (
+	// DefaultIdempotencyRetention is how long responses are replayed when IDEMPOTENCY_TTL_HOURS is not set
+	DefaultIdempotencyRetention = 24 * time.Hour
+	// idempotencyStaleAfter is when an unfinished request is assumed to have died with
+	// its server, so a retry may run it again
+	idempotencyStaleAfter = 5 * time.Minute
+)
+
+var (
+	ErrIdempotencyMismatch   = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// IdempotencyRetention returns how long responses are kept for replay, from IDEMPOTENCY_TTL_HOURS
+func IdempotencyRetention() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_TTL_HOURS"); value != "" {
+		if hours, err := strconv.Atoi(value); err == nil && hours > 0 {
+			return time.Duration(hours) * time.Hour
+		}
+		log.Printf("Ignoring invalid IDEMPOTENCY_TTL_HOURS %q", value)
+	}
+	return DefaultIdempotencyRetention
+}
+
+// BeginIdempotentRequest claims key for a request. It returns the stored response when
+// the same request already completed, or nil when the caller should run the request
+// and then call CompleteIdempotentRequest or AbandonIdempotentRequest.
+func BeginIdempotentRequest(tx *gorm.DB, key, scope, requestHash string, retention time.Duration) (*models.IdempotencyKey, error) {
+	now := time.Now()
+
+	// Forget expired keys so they can be reused
+	if err := tx.Where("created_at < ?", now.Add(-retention)).Delete(&models.IdempotencyKey{}).Error; err != nil {
+		return nil, err
+	}
+
+	record := models.IdempotencyKey{Key: key, Scope: scope, RequestHash: requestHash}
+	created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if created.Error != nil {
+		return nil, created.Error
+	}
+	if created.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := tx.Where("key = ? AND scope = ?", key, scope).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.RequestHash != requestHash {
+		return nil, ErrIdempotencyMismatch
+	}
+	if existing.CompletedAt != nil {
+		return &existing, nil
+	}
+	if now.Sub(existing.CreatedAt) < idempotencyStaleAfter {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	// Take over a request whose server went away before it finished
+	taken := tx.Model(&existing).Where("completed_at IS NULL AND created_at = ?", existing.CreatedAt).Update("created_at", now)
+	if taken.Error != nil {
+		return nil, taken.Error
+	}
+	if taken.RowsAffected == 0 {
+		return nil, ErrIdempotencyInProgress
+	}
+	return nil, nil
+}
+
+// CompleteIdempotentRequest stores the response to replay for key
+func CompleteIdempotentRequest(tx *gorm.DB, key, scope string, statusCode int, etag string, body []byte) error {
+	now := time.Now()
+	return tx.Model(&models.IdempotencyKey{}).Where("key = ? AND scope = ?", key, scope).Updates(map[string]interface{}{
+		"status_code":  statusCode,
+		"e_tag":        etag,
+		"body":         body,
+		"completed_at": &now,
+	}).Error
+}
+
+// AbandonIdempotentRequest releases key without storing a response, so a retry runs again
+func AbandonIdempotentRequest(tx *gorm.DB, key, scope string) error {
+	return tx.Where("key = ? AND scope = ? AND completed_at IS NULL", key, scope).Delete(&models.IdempotencyKey{}).Error
+}
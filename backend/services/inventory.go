@@ -0,0 +1,493 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+var (
+	ErrUnknownSKU        = errors.New("unknown product/color/size combination")
+	ErrInvalidQuantity   = errors.New("quantity must be non-zero")
+	ErrNegativeStock     = errors.New("on-hand stock cannot drop below zero or the reserved quantity")
+	ErrDuplicateSKU      = errors.New("SKU already exists")
+	ErrIncompleteSKU     = errors.New("product, color and size are required")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrInvalidReason     = errors.New("adjustment reason must be one of DAMAGED, MISPRINT, SHRINKAGE, FOUND")
+	ErrInvalidMisprint   = errors.New("invalid misprint")
+)
+
+// MovementInput describes a single stock movement to post to the ledger
+type MovementInput struct {
+	Quantity   int
+	LocationID uint // 0 posts to the default location
+	OrderID    *uint
+	Reason     string  // required for adjustments, see models.Reason*
+	UnitCost   float64 // purchase cost for receipts, defaulting to the current average; the issue cost when consumption is taken back
+	Reference  string
+	Note       string
+}
+
+// BuildSKU derives the canonical SKU code for a product/color/size combination
+func BuildSKU(product, color, size string) string {
+	parts := []string{product, color, size}
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(p), " ", "_"))
+	}
+	return strings.Join(parts, "-")
+}
+
+// CreateInventoryItem registers a new SKU with zero stock
+func CreateInventoryItem(tx *gorm.DB, product, color, size string) (*models.InventoryItem, error) {
+	product, color, size = strings.TrimSpace(product), strings.TrimSpace(color), strings.TrimSpace(size)
+	if product == "" || color == "" || size == "" {
+		return nil, ErrIncompleteSKU
+	}
+
+	sku := BuildSKU(product, color, size)
+	var count int64
+	tx.Model(&models.InventoryItem{}).Where("sku = ?", sku).Count(&count)
+	if count > 0 {
+		return nil, ErrDuplicateSKU
+	}
+
+	item := models.InventoryItem{
+		SKU:     sku,
+		Product: product,
+		Color:   color,
+		Size:    size,
+	}
+	if err := tx.Create(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// FindInventoryItem looks up the SKU matching a product/color/size, ignoring case
+func FindInventoryItem(tx *gorm.DB, product, color, size string) (*models.InventoryItem, error) {
+	var item models.InventoryItem
+	err := tx.Where("sku = ?", BuildSKU(product, color, size)).First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownSKU
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// ReceiveStock adds incoming blanks to on-hand stock
+func ReceiveStock(tx *gorm.DB, itemID uint, input MovementInput) (*models.InventoryItem, error) {
+	if input.Quantity <= 0 {
+		return nil, fmt.Errorf("received quantity must be positive")
+	}
+	input.Reason = ""
+	return postMovement(tx, itemID, models.MovementReceive, input)
+}
+
+// AdjustStock applies a signed correction to on-hand stock with a reason code
+func AdjustStock(tx *gorm.DB, itemID uint, input MovementInput) (*models.InventoryItem, error) {
+	if input.Quantity == 0 {
+		return nil, ErrInvalidQuantity
+	}
+	input.Reason = strings.ToUpper(strings.TrimSpace(input.Reason))
+	if !ValidAdjustmentReason(input.Reason) {
+		return nil, ErrInvalidReason
+	}
+	return postMovement(tx, itemID, models.MovementAdjust, input)
+}
+
+// ValidAdjustmentReason reports whether reason is a known adjustment reason code
+func ValidAdjustmentReason(reason string) bool {
+	switch reason {
+	case models.ReasonDamaged, models.ReasonMisprint, models.ReasonShrinkage, models.ReasonFound:
+		return true
+	}
+	return false
+}
+
+// RecordMisprint writes off blanks ruined by a failed print job on one order line as a
+// MISPRINT adjustment linked to the order, then reserves fresh blanks for the reprint;
+// reprintReserved reports whether there were any. Misprints happen while the order is
+// being printed, after its blanks were consumed, so ruined blanks from the original run
+// are first taken back out of the order's consumption at the cost they went out at and
+// are not counted twice. Ruined reprint blanks come out of the reprint's reservation.
+// The write-off stands when nothing is left to reprint with. Reprint reservations are
+// consumed when the order is marked printed.
+func RecordMisprint(tx *gorm.DB, order *models.Order, line *models.OrderLine, quantity int, note string) (reprintReserved bool, err error) {
+	if order.Status != models.StatusReady {
+		return false, fmt.Errorf("%w: misprints can only be recorded while the order is %s, not %s",
+			ErrOrderLocked, models.StatusReady, order.Status)
+	}
+	if quantity <= 0 || quantity > line.Quantity {
+		return false, fmt.Errorf("%w: quantity must be between 1 and %d", ErrInvalidMisprint, line.Quantity)
+	}
+	item, err := FindInventoryItem(tx, line.Product, line.Color, line.Size)
+	if err != nil {
+		return false, err
+	}
+	location, err := resolveLocation(tx, order.LocationID)
+	if err != nil {
+		return false, err
+	}
+	movement := func(quantity int) MovementInput {
+		return MovementInput{Quantity: quantity, LocationID: location.ID, OrderID: &order.ID, Reference: orderReference(order)}
+	}
+
+	reserved, err := OutstandingReservations(tx, order.ID)
+	if err != nil {
+		return false, err
+	}
+	held := 0
+	for _, r := range reserved {
+		if r.InventoryItemID == item.ID && r.LocationID == location.ID {
+			held = r.Quantity
+		}
+	}
+	if fromRun := quantity - held; fromRun > 0 {
+		used, cost, err := consumedForOrder(tx, order.ID, item.ID, location.ID)
+		if err != nil {
+			return false, err
+		}
+		if fromRun > used {
+			return false, fmt.Errorf("%w: only %d of the order's %s blanks have been printed on", ErrInvalidMisprint, used+held, item.SKU)
+		}
+		unconsume := movement(fromRun)
+		unconsume.UnitCost = roundQuantity(cost / float64(used))
+		unconsume.Note = "misprinted blanks taken out of the order's consumption"
+		if _, err := postMovement(tx, item.ID, models.MovementConsume, unconsume); err != nil {
+			return false, err
+		}
+	}
+
+	release := movement(-quantity)
+	release.Note = "released for misprint"
+	if _, err := postMovement(tx, item.ID, models.MovementRelease, release); err != nil {
+		return false, err
+	}
+	writeOff := movement(-quantity)
+	writeOff.Reason = models.ReasonMisprint
+	writeOff.Note = note
+	if _, err := postMovement(tx, item.ID, models.MovementAdjust, writeOff); err != nil {
+		return false, err
+	}
+
+	reprint := movement(quantity)
+	reprint.Note = "reprint after misprint"
+	_, err = postMovement(tx, item.ID, models.MovementReserve, reprint)
+	if errors.Is(err, ErrInsufficientStock) {
+		log.Printf("No blank available to reprint order %d: %v", order.ID, err)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// consumedForOrder is how many of an item's blanks an order has consumed at a location,
+// net of any taken back out, and what they cost
+func consumedForOrder(tx *gorm.DB, orderID, itemID, locationID uint) (quantity int, cost float64, err error) {
+	var row struct {
+		Quantity int
+		Cost     float64
+	}
+	err = tx.Model(&models.StockMovement{}).
+		Select("COALESCE(-SUM(quantity), 0) AS quantity, COALESCE(SUM(cost), 0) AS cost").
+		Where("order_id = ? AND inventory_item_id = ? AND location_id = ? AND type = ?",
+			orderID, itemID, locationID, models.MovementConsume).
+		Scan(&row).Error
+	return row.Quantity, row.Cost, err
+}
+
+// ReserveForOrder sets aside the blanks for every line of an order at its fulfilling
+// location so they cannot be promised twice
+func ReserveForOrder(tx *gorm.DB, order *models.Order) error {
+	lines, err := LoadOrderLines(tx, order)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		item, err := FindInventoryItem(tx, line.Product, line.Color, line.Size)
+		if err != nil {
+			return err
+		}
+		_, err = postMovement(tx, item.ID, models.MovementReserve, MovementInput{
+			Quantity:   line.Quantity,
+			LocationID: order.LocationID,
+			OrderID:    &order.ID,
+			Reference:  orderReference(order),
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.SKU, err)
+		}
+	}
+	return nil
+}
+
+// ConsumeForOrder turns an order's outstanding reservations into consumed stock
+func ConsumeForOrder(tx *gorm.DB, order *models.Order) error {
+	return settleReservations(tx, order, models.MovementConsume)
+}
+
+// ReleaseForOrder returns an order's outstanding reservations to available stock
+func ReleaseForOrder(tx *gorm.DB, order *models.Order) error {
+	return settleReservations(tx, order, models.MovementRelease)
+}
+
+// Reservation is stock still held for an order at one location
+type Reservation struct {
+	InventoryItemID uint
+	LocationID      uint
+	Quantity        int
+}
+
+// OutstandingReservations returns the stock still reserved for an order
+func OutstandingReservations(tx *gorm.DB, orderID uint) ([]Reservation, error) {
+	var rows []Reservation
+	err := tx.Model(&models.StockMovement{}).
+		Select("inventory_item_id, location_id, SUM(quantity) AS quantity").
+		Where("order_id = ? AND type IN ?", orderID,
+			[]string{models.MovementReserve, models.MovementRelease, models.MovementConsume}).
+		Group("inventory_item_id, location_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make([]Reservation, 0, len(rows))
+	for _, row := range rows {
+		if row.Quantity > 0 {
+			reserved = append(reserved, row)
+		}
+	}
+	return reserved, nil
+}
+
+func settleReservations(tx *gorm.DB, order *models.Order, movementType string) error {
+	reserved, err := OutstandingReservations(tx, order.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reserved {
+		_, err := postMovement(tx, r.InventoryItemID, movementType, MovementInput{
+			Quantity:   -r.Quantity,
+			LocationID: r.LocationID,
+			OrderID:    &order.ID,
+			Reference:  orderReference(order),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderQuantities is the number of blanks of each SKU an order consumes
+func orderQuantities(tx *gorm.DB, order *models.Order) (map[uint]int, error) {
+	lines, err := LoadOrderLines(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	quantities := make(map[uint]int)
+	for _, line := range lines {
+		quantities[line.InventoryItemID] += line.Quantity
+	}
+	return quantities, nil
+}
+
+func orderReference(order *models.Order) string {
+	return fmt.Sprintf("ORDER-%d", order.ID)
+}
+
+// postMovement applies a movement to an item's stock at one location and appends it
+// to the ledger. Callers should run it inside a transaction so the item, its location
+// stock and the ledger stay in step.
+func postMovement(tx *gorm.DB, itemID uint, movementType string, input MovementInput) (*models.InventoryItem, error) {
+	var item models.InventoryItem
+	if err := tx.First(&item, itemID).Error; err != nil {
+		return nil, err
+	}
+	location, err := resolveLocation(tx, input.LocationID)
+	if err != nil {
+		return nil, err
+	}
+	stock, err := locationStock(tx, item.ID, location.ID)
+	if err != nil {
+		return nil, err
+	}
+	availableBefore := item.Available
+
+	var onHandDelta, reservedDelta int
+	switch movementType {
+	case models.MovementReceive, models.MovementAdjust, models.MovementTransferIn, models.MovementTransferOut:
+		onHandDelta = input.Quantity
+	case models.MovementReserve, models.MovementRelease:
+		reservedDelta = input.Quantity
+	case models.MovementConsume:
+		onHandDelta = input.Quantity
+		reservedDelta = input.Quantity
+	default:
+		return nil, fmt.Errorf("unknown movement type %q", movementType)
+	}
+
+	stock.OnHand += onHandDelta
+	stock.Reserved += reservedDelta
+	switch {
+	case stock.Reserved < 0:
+		return nil, fmt.Errorf("cannot %s more than is reserved for %s at %s",
+			strings.ToLower(movementType), item.SKU, location.Code)
+	case stock.OnHand < stock.Reserved && movementType == models.MovementReserve:
+		return nil, fmt.Errorf("%w: %s has %d available at %s, %d required",
+			ErrInsufficientStock, item.SKU, stock.Available, location.Code, input.Quantity)
+	case stock.OnHand < stock.Reserved:
+		return nil, fmt.Errorf("%w (%s at %s)", ErrNegativeStock, item.SKU, location.Code)
+	}
+	stock.Available = stock.OnHand - stock.Reserved
+
+	item.OnHand += onHandDelta
+	item.Reserved += reservedDelta
+	item.Available = item.OnHand - item.Reserved
+
+	// Price the movement from the SKU's cost position, which it then joins
+	var unitCost, cost float64
+	var costs *costState
+	if onHandDelta != 0 && (movementType == models.MovementReceive || movementType == models.MovementAdjust || movementType == models.MovementConsume) {
+		if costs, err = currentCosts(tx, item.ID); err != nil {
+			return nil, err
+		}
+		switch {
+		case movementType == models.MovementConsume && onHandDelta > 0:
+			// Consumption taken back returns at the cost it went out at
+			unitCost = input.UnitCost
+			cost = -roundQuantity(unitCost * float64(onHandDelta))
+		case onHandDelta > 0:
+			if unitCost = input.UnitCost; unitCost <= 0 {
+				unitCost = roundQuantity(costs.inboundCost())
+			}
+		default:
+			cost = roundQuantity(costs.apply(onHandDelta, 0, CostMethod()))
+			unitCost = roundQuantity(cost / float64(-onHandDelta))
+		}
+		if onHandDelta > 0 {
+			costs.apply(onHandDelta, unitCost, CostMethod())
+		}
+	}
+
+	if err := tx.Save(stock).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Save(&item).Error; err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		InventoryItemID: item.ID,
+		LocationID:      location.ID,
+		Type:            movementType,
+		Reason:          input.Reason,
+		Quantity:        input.Quantity,
+		UnitCost:        unitCost,
+		Cost:            cost,
+		OnHandAfter:     item.OnHand,
+		ReservedAfter:   item.Reserved,
+		OrderID:         input.OrderID,
+		Reference:       input.Reference,
+		Note:            input.Note,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	if costs != nil {
+		if err := saveCosts(tx, item.ID, costs, movement.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if crossedReorderPoint(&item, availableBefore) {
+		if err := raiseStockAlert(tx, &item, movement.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &item, nil
+}
+
+// LowStockLine is a SKU at or below its reorder point with a suggested replenishment
+type LowStockLine struct {
+	Item              models.InventoryItem `json:"item"`
+	Shortfall         int                  `json:"shortfall"`
+	SuggestedQuantity int                  `json:"suggestedQuantity"`
+}
+
+// SetReorderPolicy configures when a SKU should be replenished, by how much and from whom
+func SetReorderPolicy(tx *gorm.DB, itemID uint, reorderPoint, reorderQuantity int, supplierID *uint) (*models.InventoryItem, error) {
+	if reorderPoint < 0 || reorderQuantity < 0 {
+		return nil, fmt.Errorf("reorder point and quantity cannot be negative")
+	}
+
+	var item models.InventoryItem
+	if err := tx.First(&item, itemID).Error; err != nil {
+		return nil, err
+	}
+
+	item.ReorderPoint = reorderPoint
+	item.ReorderQuantity = reorderQuantity
+	item.SupplierID = supplierID
+	if err := tx.Save(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// LowStockReport lists every SKU whose available stock is at or below its reorder point
+func LowStockReport(tx *gorm.DB) ([]LowStockLine, error) {
+	var items []models.InventoryItem
+	err := tx.Where("reorder_point > 0 AND available <= reorder_point").
+		Order("sku").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]LowStockLine, 0, len(items))
+	for _, item := range items {
+		shortfall := item.ReorderPoint - item.Available
+		suggested := item.ReorderQuantity
+		if suggested < shortfall {
+			suggested = shortfall
+		}
+		lines = append(lines, LowStockLine{
+			Item:              item,
+			Shortfall:         shortfall,
+			SuggestedQuantity: suggested,
+		})
+	}
+	return lines, nil
+}
+
+// crossedReorderPoint reports whether a movement just took the item down through its reorder point
+func crossedReorderPoint(item *models.InventoryItem, availableBefore int) bool {
+	return item.ReorderPoint > 0 &&
+		availableBefore > item.ReorderPoint &&
+		item.Available <= item.ReorderPoint
+}
+
+func raiseStockAlert(tx *gorm.DB, item *models.InventoryItem, movementID uint) error {
+	alert := models.StockAlert{
+		InventoryItemID: item.ID,
+		SKU:             item.SKU,
+		Available:       item.Available,
+		ReorderPoint:    item.ReorderPoint,
+		MovementID:      movementID,
+	}
+	if err := tx.Create(&alert).Error; err != nil {
+		return err
+	}
+
+	log.Printf("Low stock: %s has %d available (reorder point %d)", item.SKU, item.Available, item.ReorderPoint)
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// printingOrder receives blanks and takes an order for 10 of them through reservation
+// and consumption, as approval and release to the floor do
+func printingOrder(t *testing.T, tx *gorm.DB, blanks int) (*models.Order, *models.OrderLine, uint) {
+	t.Helper()
+	item, err := CreateInventoryItem(tx, "T-Shirt", "black", "M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReceiveStock(tx, item.ID, MovementInput{Quantity: blanks, UnitCost: 3}); err != nil {
+		t.Fatal(err)
+	}
+	order := createTestOrder(t, tx, models.StatusApproved, 100)
+	if err := ReserveForOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeForOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = models.StatusReady
+	return order, &order.Lines[0], item.ID
+}
+
+func assertStock(t *testing.T, tx *gorm.DB, itemID uint, onHand, reserved int) {
+	t.Helper()
+	var item models.InventoryItem
+	if err := tx.First(&item, itemID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.OnHand != onHand || item.Reserved != reserved {
+		t.Errorf("on hand %d, reserved %d; want %d and %d", item.OnHand, item.Reserved, onHand, reserved)
+	}
+}
+
+// misprintWriteOffs is the number of blanks written off as misprints on an order
+func misprintWriteOffs(tx *gorm.DB, orderID uint) int {
+	var written int
+	tx.Model(&models.StockMovement{}).Select("COALESCE(-SUM(quantity), 0)").
+		Where("order_id = ? AND type = ? AND reason = ?", orderID, models.MovementAdjust, models.ReasonMisprint).
+		Scan(&written)
+	return written
+}
+
+func TestMisprintWritesOffAndReservesReprint(t *testing.T) {
+	tx := newTestDB(t)
+	order, line, itemID := printingOrder(t, tx, 20)
+	assertStock(t, tx, itemID, 10, 0)
+
+	// The ruined blanks come back out of the order's consumption to be written off,
+	// so only the reprint comes off the shelf
+	reprint, err := RecordMisprint(tx, order, line, 2, "smudged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reprint {
+		t.Error("reprint was not reserved")
+	}
+	assertStock(t, tx, itemID, 10, 2)
+	if written := misprintWriteOffs(tx, order.ID); written != 2 {
+		t.Errorf("%d written off as misprints, want 2", written)
+	}
+
+	// A ruined reprint blank is written off and replaced
+	if _, err := RecordMisprint(tx, order, line, 1, "ruined again"); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 9, 2)
+	if written := misprintWriteOffs(tx, order.ID); written != 3 {
+		t.Errorf("%d written off as misprints, want 3", written)
+	}
+
+	// Marking the order printed consumes the reprint; the order's blank cost is the
+	// 10 garments it shipped, the misprints are written off
+	if err := ConsumeForOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 7, 0)
+	if err := RecordOrderCostOfGoods(tx, order); err != nil {
+		t.Fatal(err)
+	}
+	if order.CostOfGoods != 30 {
+		t.Errorf("cost of goods %.2f, want 30", order.CostOfGoods)
+	}
+}
+
+func TestMisprintWriteOffStandsWithoutReprintStock(t *testing.T) {
+	tx := newTestDB(t)
+	order, line, itemID := printingOrder(t, tx, 10)
+
+	reprint, err := RecordMisprint(tx, order, line, 2, "")
+	if err != nil || reprint {
+		t.Fatalf("got reprint %v and %v, want a write-off without reprint", reprint, err)
+	}
+	assertStock(t, tx, itemID, 0, 0)
+	if written := misprintWriteOffs(tx, order.ID); written != 2 {
+		t.Errorf("%d written off as misprints, want 2", written)
+	}
+}
+
+func TestMisprintRejectedOutsidePrinting(t *testing.T) {
+	tx := newTestDB(t)
+	order, line, _ := printingOrder(t, tx, 20)
+
+	for _, status := range []string{models.StatusApproved, models.StatusOnHold, models.StatusCancelled, models.StatusPrinted} {
+		order.Status = status
+		if _, err := RecordMisprint(tx, order, line, 1, ""); !errors.Is(err, ErrOrderLocked) {
+			t.Errorf("%s: got %v, want ErrOrderLocked", status, err)
+		}
+	}
+
+	order.Status = models.StatusReady
+	for _, quantity := range []int{0, -1, 11} {
+		if _, err := RecordMisprint(tx, order, line, quantity, ""); !errors.Is(err, ErrInvalidMisprint) {
+			t.Errorf("quantity %d: got %v, want ErrInvalidMisprint", quantity, err)
+		}
+	}
+	if written := misprintWriteOffs(tx, order.ID); written != 0 {
+		t.Errorf("%d written off by rejected misprints", written)
+	}
+}
+
+// The ledger is the record: an item's on-hand stock is the sum of its movements, and
+// a movement that would take it negative is refused without being recorded
+func TestLedgerKeepsStockInStepWithMovements(t *testing.T) {
+	tx := newTestDB(t)
+	item, err := CreateInventoryItem(tx, "T-Shirt", "white", "L")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateInventoryItem(tx, "t-shirt", "White", "l"); !errors.Is(err, ErrDuplicateSKU) {
+		t.Errorf("duplicate SKU: got %v, want ErrDuplicateSKU", err)
+	}
+
+	if _, err := ReceiveStock(tx, item.ID, MovementInput{Quantity: 12, UnitCost: 2.5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AdjustStock(tx, item.ID, MovementInput{Quantity: -3, Reason: "damaged"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AdjustStock(tx, item.ID, MovementInput{Quantity: -1, Reason: "lost"}); !errors.Is(err, ErrInvalidReason) {
+		t.Errorf("unknown reason: got %v, want ErrInvalidReason", err)
+	}
+	if _, err := AdjustStock(tx, item.ID, MovementInput{Quantity: -10, Reason: models.ReasonShrinkage}); !errors.Is(err, ErrNegativeStock) {
+		t.Errorf("adjusting below zero: got %v, want ErrNegativeStock", err)
+	}
+	if _, err := AdjustStock(tx, item.ID, MovementInput{Reason: models.ReasonFound}); !errors.Is(err, ErrInvalidQuantity) {
+		t.Errorf("zero adjustment: got %v, want ErrInvalidQuantity", err)
+	}
+
+	var sum int
+	tx.Model(&models.StockMovement{}).Select("COALESCE(SUM(quantity), 0)").
+		Where("inventory_item_id = ? AND type IN ?", item.ID, []string{models.MovementReceive, models.MovementAdjust}).
+		Scan(&sum)
+	assertStock(t, tx, item.ID, 9, 0)
+	if sum != 9 {
+		t.Errorf("movements add up to %d, want 9", sum)
+	}
+	var stored models.InventoryItem
+	tx.First(&stored, item.ID)
+	if stored.Available != stored.OnHand-stored.Reserved {
+		t.Errorf("available %d, want on hand %d less reserved %d", stored.Available, stored.OnHand, stored.Reserved)
+	}
+}
+
+// An alert is raised once, when available stock falls through the reorder point, and
+// the low-stock report suggests at least the shortfall
+func TestLowStockAlertWhenCrossingReorderPoint(t *testing.T) {
+	tx := newTestDB(t)
+	item, err := CreateInventoryItem(tx, "Hoodie", "grey", "XL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetReorderPolicy(tx, item.ID, 5, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReceiveStock(tx, item.ID, MovementInput{Quantity: 8, UnitCost: 9}); err != nil {
+		t.Fatal(err)
+	}
+	for _, quantity := range []int{-2, -2, -1} { // 6, then 4 crosses, then 3 stays below
+		if _, err := AdjustStock(tx, item.ID, MovementInput{Quantity: quantity, Reason: models.ReasonDamaged}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var alerts []models.StockAlert
+	tx.Where("inventory_item_id = ?", item.ID).Find(&alerts)
+	if len(alerts) != 1 || alerts[0].Available != 4 {
+		t.Fatalf("alerts %+v, want one at 4 available", alerts)
+	}
+
+	report, err := LowStockReport(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].Shortfall != 2 || report[0].SuggestedQuantity != 3 {
+		t.Errorf("report %+v, want a shortfall of 2 and 3 suggested", report)
+	}
+	if _, err := SetReorderPolicy(tx, item.ID, -1, 0, nil); err == nil {
+		t.Error("negative reorder point was accepted")
+	}
+}
+
+func TestMovementsPricedFromSavedCostPosition(t *testing.T) {
+	tx := newTestDB(t)
+	item, err := CreateInventoryItem(tx, "T-Shirt", "black", "M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unitCost := range []float64{3, 5} {
+		if _, err := ReceiveStock(tx, item.ID, MovementInput{Quantity: 10, UnitCost: unitCost}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issuedCost := func(quantity int) float64 {
+		t.Helper()
+		if _, err := AdjustStock(tx, item.ID, MovementInput{Quantity: -quantity, Reason: models.ReasonDamaged}); err != nil {
+			t.Fatal(err)
+		}
+		var movement models.StockMovement
+		tx.Where("inventory_item_id = ?", item.ID).Order("id desc").First(&movement)
+		return movement.Cost
+	}
+
+	// FIFO takes the oldest layer first
+	if cost := issuedCost(12); cost != 40 {
+		t.Errorf("12 issued at %.2f, want 40", cost)
+	}
+
+	// A SKU without a saved position, as after an upgrade, catches up from its ledger
+	tx.Where("inventory_item_id = ?", item.ID).Delete(&models.ItemCost{})
+	if cost := issuedCost(3); cost != 15 {
+		t.Errorf("3 issued at %.2f, want 15", cost)
+	}
+
+	saved, err := currentCosts(tx, item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := replayCosts(tx, item.ID, time.Now(), CostMethodFIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.onHand != 5 || saved.value(CostMethodFIFO) != replayed.value(CostMethodFIFO) {
+		t.Errorf("saved position %d at %.2f, replayed ledger %d at %.2f",
+			saved.onHand, saved.value(CostMethodFIFO), replayed.onHand, replayed.value(CostMethodFIFO))
+	}
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// InvoiceLine is one order line as billed
+type InvoiceLine struct {
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unitPrice"`
+	Amount      float64 `json:"amount"`
+	TaxRate     float64 `json:"taxRate"` // percent
+	Tax         float64 `json:"tax"`
+}
+
+// InvoiceAdjustment is a charge or credit between the subtotal and the total, such as
+// a discount, the rush fee or a jurisdiction's sales tax. Credits are negative.
+type InvoiceAdjustment struct {
+	Label  string  `json:"label"`
+	Amount float64 `json:"amount"`
+}
+
+// Invoice is what the customer owes for an order. It is issued when the order's
+// prices were locked and falls due after the customer's payment terms.
+type Invoice struct {
+	Number          string              `json:"number"`
+	OrderID         uint                `json:"orderId"`
+	Currency        string              `json:"currency"`
+	IssuedAt        time.Time           `json:"issuedAt"`
+	DueAt           time.Time           `json:"dueAt"`
+	Terms           string              `json:"terms"`
+	Seller          LabelInput          `json:"seller"`
+	BillTo          LabelInput          `json:"billTo"`
+	ShipTo          *LabelInput         `json:"shipTo,omitempty"`
+	Lines           []InvoiceLine       `json:"lines"`
+	Subtotal        float64             `json:"subtotal"`
+	Adjustments     []InvoiceAdjustment `json:"adjustments"`
+	TaxTotal        float64             `json:"taxTotal"`
+	TaxCertificate  string              `json:"taxCertificate,omitempty"` // the exemption the order was not taxed under
+	Total           float64             `json:"total"`
+	Paid            float64             `json:"paid"`
+	BalanceDue      float64             `json:"balanceDue"`
+	PaymentStatus   string              `json:"paymentStatus"`
+	DepositRequired float64             `json:"depositRequired"`
+	DepositDue      float64             `json:"depositDue"`
+	Payments        []models.Payment    `json:"payments"`
+}
+
+// BuildInvoice gathers everything billed on an order, with the payments made so far
+func BuildInvoice(tx *gorm.DB, order *models.Order) (*Invoice, error) {
+	table, err := CurrentPriceTable()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := LoadOrderLines(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	location, err := FulfillingLocation(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	payments, err := OrderPaymentSummary(tx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	issued := order.CreatedAt
+	if order.PricedAt != nil {
+		issued = *order.PricedAt
+	}
+	invoice := &Invoice{
+		Number:          fmt.Sprintf("INV-%06d", order.ID),
+		OrderID:         order.ID,
+		Currency:        table.Currency,
+		IssuedAt:        issued,
+		DueAt:           issued,
+		Terms:           "Due on receipt",
+		Seller:          ShipFromAddress(location),
+		Subtotal:        order.Subtotal,
+		Adjustments:     []InvoiceAdjustment{},
+		TaxTotal:        order.TaxTotal,
+		TaxCertificate:  order.TaxCertificate,
+		Total:           order.Total,
+		Paid:            order.AmountPaid,
+		BalanceDue:      payments.BalanceDue,
+		PaymentStatus:   order.PaymentStatus,
+		DepositRequired: payments.DepositRequired,
+		DepositDue:      payments.DepositDue,
+		Payments:        payments.Payments,
+	}
+	for _, line := range lines {
+		description := fmt.Sprintf("%s %s %s", line.Product, line.Color, line.Size)
+		if placements := linePlacements(line); len(placements) > 0 {
+			description += " - " + strings.Join(placements, ", ")
+		}
+		invoice.Lines = append(invoice.Lines, InvoiceLine{
+			Description: strings.Join(strings.Fields(description), " "),
+			Quantity:    line.Quantity,
+			UnitPrice:   line.UnitPrice,
+			Amount:      line.LineTotal,
+			TaxRate:     line.TaxRate,
+			Tax:         line.Tax,
+		})
+	}
+	discounts, err := OrderDiscounts(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range discounts {
+		label := "Discount: " + d.Name
+		if d.Code != "" {
+			label += fmt.Sprintf(" (%s)", d.Code)
+		}
+		invoice.Adjustments = append(invoice.Adjustments, InvoiceAdjustment{Label: label, Amount: -d.Amount})
+	}
+	if order.RushFee != 0 {
+		invoice.Adjustments = append(invoice.Adjustments, InvoiceAdjustment{Label: fmt.Sprintf("Rush fee (%s)", order.Rush), Amount: order.RushFee})
+	}
+	taxes, err := invoiceTaxes(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Adjustments = append(invoice.Adjustments, taxes...)
+
+	shipTo, ok, err := OrderShipTo(tx, order)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		invoice.ShipTo = &shipTo
+		invoice.BillTo = shipTo
+	}
+	if order.CustomerID != nil {
+		if err := invoiceCustomer(tx, *order.CustomerID, invoice); err != nil {
+			return nil, err
+		}
+	}
+	return invoice, nil
+}
+
+// invoiceTaxes totals the order's sales tax by jurisdiction and rate, state first
+func invoiceTaxes(tx *gorm.DB, order *models.Order) ([]InvoiceAdjustment, error) {
+	taxes, err := OrderTaxes(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	var adjustments []InvoiceAdjustment
+	index := make(map[string]int)
+	for _, level := range []string{models.TaxLevelState, models.TaxLevelLocal} {
+		for _, t := range taxes {
+			if t.Level != level || t.Amount == 0 {
+				continue
+			}
+			label := fmt.Sprintf("Sales tax: %s %s%%", t.Jurisdiction, strconv.FormatFloat(t.Rate, 'f', -1, 64))
+			if i, ok := index[label]; ok {
+				adjustments[i].Amount = roundCents(adjustments[i].Amount + t.Amount)
+				continue
+			}
+			index[label] = len(adjustments)
+			adjustments = append(adjustments, InvoiceAdjustment{Label: label, Amount: t.Amount})
+		}
+	}
+	return adjustments, nil
+}
+
+// invoiceCustomer bills the customer's default billing address, when they have one,
+// and applies their payment terms
+func invoiceCustomer(tx *gorm.DB, customerID uint, invoice *Invoice) error {
+	var customer models.Customer
+	err := tx.First(&customer, customerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if customer.PaymentTermsDays > 0 {
+		invoice.Terms = fmt.Sprintf("Net %d", customer.PaymentTermsDays)
+		invoice.DueAt = invoice.IssuedAt.AddDate(0, 0, customer.PaymentTermsDays)
+	}
+
+	name := customer.Company
+	if name == "" {
+		name = customer.Name
+	}
+	billing, err := DefaultCustomerAddress(tx, customerID, models.AddressBilling)
+	if errors.Is(err, ErrUnknownAddress) {
+		if invoice.BillTo.Name == "" {
+			invoice.BillTo.Name = name
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if billing.Name != "" {
+		name = billing.Name
+	}
+	invoice.BillTo = LabelInput{Name: name, Address: billing.Address, City: billing.City, State: billing.State, Zip: billing.Zip}
+	return nil
+}
+
+// WriteInvoicePDF renders an invoice as a PDF
+func WriteInvoicePDF(w io.Writer, invoice *Invoice) error {
+	pdf := newDocument("PRINTFLOW INVOICE")
+
+	pdf.SetFont("Helvetica", "", 10)
+	top := pdf.GetY()
+	pdf.MultiCell(95, 5, "FROM:\n"+invoiceAddress(invoice.Seller), "", "", false)
+	pdf.SetXY(115, top)
+	pdf.MultiCell(0, 5, fmt.Sprintf(
+		"Invoice: %s\nOrder #%d\nIssued: %s\nDue: %s\nTerms: %s",
+		invoice.Number,
+		invoice.OrderID,
+		invoice.IssuedAt.Format("2006-01-02"),
+		invoice.DueAt.Format("2006-01-02"),
+		invoice.Terms,
+	), "", "", false)
+	pdf.Ln(6)
+
+	top = pdf.GetY()
+	pdf.MultiCell(95, 5, "BILL TO:\n"+invoiceAddress(invoice.BillTo), "", "", false)
+	bottom := pdf.GetY()
+	if invoice.ShipTo != nil {
+		pdf.SetXY(115, top)
+		pdf.MultiCell(0, 5, "SHIP TO:\n"+invoiceAddress(*invoice.ShipTo), "", "", false)
+		if pdf.GetY() > bottom {
+			bottom = pdf.GetY()
+		}
+	}
+	pdf.SetY(bottom + 8)
+
+	// Line items
+	widths := []float64{95, 15, 30, 20, 35}
+	pdf.SetFont("Helvetica", "B", 10)
+	for i, heading := range []string{"Description", "Qty", "Unit price", "Tax", "Amount"} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 7, heading, "B", 0, align, false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range invoice.Lines {
+		pdf.CellFormat(widths[0], 6, line.Description, "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, fmt.Sprintf("%d", line.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 6, money(line.UnitPrice), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, money(line.Tax), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, money(line.Amount), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	// Totals
+	labelWidth := widths[0] + widths[1] + widths[2] + widths[3]
+	totalRow := func(label string, amount float64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(labelWidth, 6, label, "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, money(amount), "", 1, "R", false, 0, "")
+	}
+	totalRow("Subtotal", invoice.Subtotal, false)
+	for _, adjustment := range invoice.Adjustments {
+		totalRow(adjustment.Label, adjustment.Amount, false)
+	}
+	totalRow(fmt.Sprintf("Total (%s)", invoice.Currency), invoice.Total, true)
+	totalRow("Paid", invoice.Paid, false)
+	totalRow("Balance due", invoice.BalanceDue, true)
+
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.Ln(6)
+	if invoice.TaxCertificate != "" {
+		pdf.MultiCell(0, 5, "Exempt from sales tax under certificate "+invoice.TaxCertificate+".", "", "", false)
+	}
+	if invoice.DepositRequired > 0 {
+		deposit := fmt.Sprintf("A deposit of %s is required before production", money(invoice.DepositRequired))
+		if invoice.DepositDue > 0 {
+			deposit += fmt.Sprintf("; %s of it is outstanding.", money(invoice.DepositDue))
+		} else {
+			deposit += " and has been received."
+		}
+		pdf.MultiCell(0, 5, deposit, "", "", false)
+	}
+	if len(invoice.Payments) > 0 {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.Cell(0, 5, "Payments")
+		pdf.Ln(5)
+		pdf.SetFont("Helvetica", "", 9)
+		for _, p := range invoice.Payments {
+			amount := p.Amount
+			if p.Kind == models.PaymentKindRefund {
+				amount = -amount
+			}
+			detail := strings.Join(strings.Fields(strings.ToLower(p.Kind)+" "+p.Method+" "+p.Reference), " ")
+			pdf.CellFormat(labelWidth, 5, p.CreatedAt.Format("2006-01-02")+"  "+detail, "", 0, "L", false, 0, "")
+			pdf.CellFormat(widths[4], 5, money(amount), "", 1, "R", false, 0, "")
+		}
+	}
+
+	return pdf.Output(w)
+}
+
+// invoiceAddress formats an address block, leaving out missing parts
+func invoiceAddress(address LabelInput) string {
+	place := strings.TrimSpace(address.State + " " + address.Zip)
+	if address.City != "" && place != "" {
+		place = address.City + ", " + place
+	} else if address.City != "" {
+		place = address.City
+	}
+	var lines []string
+	for _, line := range []string{address.Name, address.Address, place} {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func money(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
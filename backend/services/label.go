@@ -7,12 +7,17 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/code128"
 	"github.com/jung-kurt/gofpdf"
+	"printflow/models"
 )
 
+// maxLabelLines is how many line items fit above the barcode
+const maxLabelLines = 4
+
 type LabelInput struct {
 	Name    string `json:"name"`
 	Address string `json:"address"`
@@ -21,7 +26,9 @@ type LabelInput struct {
 	Zip     string `json:"zip"`
 }
 
-func GenerateLabel(orderID uint, input LabelInput) (string, error) {
+// GenerateLabel renders a shipping label PDF to input, shipped from the shipFrom address,
+// listing the order's line items
+func GenerateLabel(orderID uint, input LabelInput, shipFrom LabelInput, lines []models.OrderLine) (string, error) {
 	// Provide default values to make it fail-safe
 	if input.Name == "" {
 		input.Name = "Customer"
@@ -83,26 +90,38 @@ func GenerateLabel(orderID uint, input LabelInput) (string, error) {
 	}
 
 	// Create PDF
-	pdf := gofpdf.New("P", "mm", "Letter", "")
-	pdf.AddPage()
-
-	pdf.SetFont("Helvetica", "B", 18)
-	pdf.Cell(0, 12, "PRINTFLOW SHIPPING LABEL")
-	pdf.Ln(14)
+	pdf := newDocument("PRINTFLOW SHIPPING LABEL")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.MultiCell(0, 5, fmt.Sprintf(
+		"FROM:\n%s\n%s\n%s, %s %s",
+		shipFrom.Name,
+		shipFrom.Address,
+		shipFrom.City,
+		shipFrom.State,
+		shipFrom.Zip,
+	), "", "", false)
+	pdf.Ln(6)
 
 	pdf.SetFont("Helvetica", "", 12)
 	pdf.MultiCell(0, 8, fmt.Sprintf(
-		"TO:\n%s\n%s\n%s, %s %s\n\nOrder #%d\nProduct: %s\nSize: %s",
+		"TO:\n%s\n%s\n%s, %s %s\n\nOrder #%d",
 		input.Name,
 		input.Address,
 		input.City,
 		input.State,
 		input.Zip,
 		orderID,
-		"Custom Apparel", // We'd need to pass product info here
-		"Size TBD",       // We'd need to pass size info here
 	), "", "", false)
 
+	// List the contents, keeping the barcode clear on large orders
+	items := LineSummary(lines)
+	if len(items) > maxLabelLines {
+		items = append(items[:maxLabelLines-1], fmt.Sprintf("+ %d more lines", len(items)-maxLabelLines+1))
+	}
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 4, strings.Join(items, "\n"), "", "", false)
+
 	pdf.Ln(10)
 
 	pdf.Image(absBarcodePath, 10, 120, 150, 0, false, "", 0, "")
@@ -114,3 +133,15 @@ func GenerateLabel(orderID uint, input LabelInput) (string, error) {
 
 	return "/" + out, nil
 }
+
+// newDocument starts a Letter-sized PDF page headed with title, the layout shared by
+// labels and invoices
+func newDocument(title string) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.Cell(0, 12, title)
+	pdf.Ln(14)
+	return pdf
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// DefaultLocationCode is the location created at startup and used when none is given
+const DefaultLocationCode = "MAIN"
+
+var ErrUnknownLocation = errors.New("location not found")
+
+// EnsureDefaultLocation creates the main shop location if it is missing and moves any
+// stock recorded before locations existed onto it
+func EnsureDefaultLocation(tx *gorm.DB) error {
+	var location models.Location
+	err := tx.Where("code = ?", DefaultLocationCode).First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		location = models.Location{
+			Code:   DefaultLocationCode,
+			Name:   "Main Shop",
+			Active: true,
+		}
+		err = tx.Create(&location).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	var items []models.InventoryItem
+	err = tx.Where("id NOT IN (?)", tx.Model(&models.LocationStock{}).Select("inventory_item_id")).
+		Find(&items).Error
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		stock := models.LocationStock{
+			InventoryItemID: item.ID,
+			LocationID:      location.ID,
+			OnHand:          item.OnHand,
+			Reserved:        item.Reserved,
+			Available:       item.Available,
+		}
+		if err := tx.Create(&stock).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.StockMovement{}).Where("location_id = 0").Update("location_id", location.ID).Error
+}
+
+// DefaultLocation returns the main shop location
+func DefaultLocation(tx *gorm.DB) (*models.Location, error) {
+	var location models.Location
+	if err := tx.Where("code = ?", DefaultLocationCode).First(&location).Error; err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// CreateLocation registers a new stock location
+func CreateLocation(tx *gorm.DB, location models.Location) (*models.Location, error) {
+	location.Code = strings.ToUpper(strings.TrimSpace(location.Code))
+	if location.Code == "" || location.Name == "" {
+		return nil, errors.New("location code and name are required")
+	}
+
+	var count int64
+	tx.Model(&models.Location{}).Where("code = ?", location.Code).Count(&count)
+	if count > 0 {
+		return nil, fmt.Errorf("location %s already exists", location.Code)
+	}
+
+	location.Active = true
+	if err := tx.Create(&location).Error; err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// ChooseFulfillmentLocation picks the highest-priority active location that has enough
+// available stock of the SKU, falling back to the default location when none does
+func ChooseFulfillmentLocation(tx *gorm.DB, item *models.InventoryItem, quantity int) (*models.Location, error) {
+	var location models.Location
+	err := tx.Joins("JOIN location_stocks ON location_stocks.location_id = locations.id").
+		Where("locations.active = ? AND location_stocks.inventory_item_id = ? AND location_stocks.available >= ?",
+			true, item.ID, quantity).
+		Order("locations.priority, locations.id").
+		First(&location).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultLocation(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// TransferStock moves on-hand stock of a SKU from one location to another
+func TransferStock(tx *gorm.DB, itemID, fromLocationID, toLocationID uint, quantity int, note string) (*models.InventoryItem, error) {
+	if quantity <= 0 {
+		return nil, errors.New("transfer quantity must be positive")
+	}
+	if fromLocationID == toLocationID {
+		return nil, errors.New("cannot transfer stock to the same location")
+	}
+
+	from, err := resolveLocation(tx, fromLocationID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveLocation(tx, toLocationID)
+	if err != nil {
+		return nil, err
+	}
+	reference := fmt.Sprintf("TRANSFER %s->%s", from.Code, to.Code)
+
+	_, err = postMovement(tx, itemID, models.MovementTransferOut, MovementInput{
+		Quantity:   -quantity,
+		LocationID: from.ID,
+		Reference:  reference,
+		Note:       note,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return postMovement(tx, itemID, models.MovementTransferIn, MovementInput{
+		Quantity:   quantity,
+		LocationID: to.ID,
+		Reference:  reference,
+		Note:       note,
+	})
+}
+
+// FulfillingLocation returns the location an order ships from
+func FulfillingLocation(tx *gorm.DB, order *models.Order) (*models.Location, error) {
+	return resolveLocation(tx, order.LocationID)
+}
+
+// ShipFromAddress returns the label address for a location
+func ShipFromAddress(location *models.Location) LabelInput {
+	return LabelInput{
+		Name:    location.Name,
+		Address: location.Address,
+		City:    location.City,
+		State:   location.State,
+		Zip:     location.Zip,
+	}
+}
+
+// resolveLocation loads a location by ID, treating 0 as the default location
+func resolveLocation(tx *gorm.DB, locationID uint) (*models.Location, error) {
+	if locationID == 0 {
+		return DefaultLocation(tx)
+	}
+
+	var location models.Location
+	err := tx.First(&location, locationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownLocation, locationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &location, nil
+}
+
+// locationStock loads an item's stock row at a location, starting from zero if none exists yet
+func locationStock(tx *gorm.DB, itemID, locationID uint) (*models.LocationStock, error) {
+	var stock models.LocationStock
+	err := tx.Where("inventory_item_id = ? AND location_id = ?", itemID, locationID).First(&stock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.LocationStock{InventoryItemID: itemID, LocationID: locationID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
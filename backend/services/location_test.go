@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+func locationOnHand(tx *gorm.DB, itemID, locationID uint) int {
+	var stock models.LocationStock
+	tx.Where("inventory_item_id = ? AND location_id = ?", itemID, locationID).Find(&stock)
+	return stock.OnHand
+}
+
+// Transfers move stock between locations without changing the SKU's total, and orders
+// ship from the first location by priority that has them in stock
+func TestTransfersAndFulfillmentLocation(t *testing.T) {
+	tx := newTestDB(t)
+	main, err := DefaultLocation(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Model(main).Update("priority", 10)
+	west, err := CreateLocation(tx, models.Location{Code: "west", Name: "West Warehouse", Priority: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateLocation(tx, models.Location{Code: "WEST", Name: "Again"}); err == nil {
+		t.Error("duplicate location code was accepted")
+	}
+
+	itemID := stockBlanks(t, tx, 12)
+	item, _ := FindInventoryItem(tx, "T-Shirt", "black", "M")
+
+	// Only the main shop has stock, so it fulfils
+	location, err := ChooseFulfillmentLocation(tx, item, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.ID != main.ID {
+		t.Errorf("fulfilling from %s, want %s", location.Code, main.Code)
+	}
+
+	if _, err := TransferStock(tx, itemID, main.ID, west.ID, 5, "rebalance"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TransferStock(tx, itemID, main.ID, west.ID, 8, ""); !errors.Is(err, ErrNegativeStock) {
+		t.Errorf("transferring more than on hand: got %v, want ErrNegativeStock", err)
+	}
+	if _, err := TransferStock(tx, itemID, west.ID, west.ID, 1, ""); err == nil {
+		t.Error("transfer to the same location was accepted")
+	}
+	if got := locationOnHand(tx, itemID, main.ID); got != 7 {
+		t.Errorf("main has %d, want 7", got)
+	}
+	if got := locationOnHand(tx, itemID, west.ID); got != 5 {
+		t.Errorf("west has %d, want 5", got)
+	}
+	assertStock(t, tx, itemID, 12, 0)
+
+	// West is preferred once it has enough, and main still covers what it cannot
+	item, _ = FindInventoryItem(tx, "T-Shirt", "black", "M")
+	if location, _ = ChooseFulfillmentLocation(tx, item, 5); location.ID != west.ID {
+		t.Errorf("5 ship from %s, want WEST", location.Code)
+	}
+	if location, _ = ChooseFulfillmentLocation(tx, item, 6); location.ID != main.ID {
+		t.Errorf("6 ship from %s, want MAIN", location.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"gorm.io/gorm"
+	"printflow/models"
+	"printflow/testutil"
+)
+
+func TestMain(m *testing.M) {
+	if err := LoadWorkflows(testutil.ConfigDir + DefaultWorkflowFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := LoadPricing(testutil.ConfigDir + DefaultPricingFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := LoadTaxRates(testutil.ConfigDir + DefaultTaxFile); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestDB opens an empty database of its own for one test, with the main location
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	database := testutil.NewDB(t)
+	if err := EnsureDefaultLocation(database); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+// createTestOrder saves an order with one line of 10 garments, priced at total
+func createTestOrder(t *testing.T, tx *gorm.DB, status string, total float64) *models.Order {
+	t.Helper()
+	return testutil.CreateOrder(t, tx, status, total)
+}
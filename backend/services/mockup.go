@@ -19,6 +19,7 @@ type MockupConfig struct {
     LogoPosition image.Point
     LogoMaxSize  image.Point
     OutputDir    string
+    PixelsPerCm  float64 // template scale, used to turn the printed logo area into real-world size
 }
 
 // GetMockupConfig returns configuration based on product type
@@ -28,11 +29,13 @@ func GetMockupConfig(product string) MockupConfig {
             LogoPosition: image.Pt(240, 210), // Chest area (moved right from 220 to 240, down from 200 to 210)
             LogoMaxSize:  image.Pt(150, 150), // Max logo dimensions
             OutputDir:    "mockups",
+            PixelsPerCm:  5.0,                // 150px max ≈ 30cm chest print
         },
         "T-Shirt": {
             LogoPosition: image.Pt(220, 190), // Chest area (moved right from 200 to 220, down from 180 to 190)
             LogoMaxSize:  image.Pt(120, 120),
             OutputDir:    "mockups",
+            PixelsPerCm:  4.3,                // 120px max ≈ 28cm chest print
         },
     }
     
@@ -45,6 +48,7 @@ func GetMockupConfig(product string) MockupConfig {
         LogoPosition: image.Pt(180, 200), // Left chest default
         LogoMaxSize:  image.Pt(150, 150),
         OutputDir:    "mockups",
+        PixelsPerCm:  5.0,
     }
 }
 
@@ -92,7 +96,7 @@ func GenerateMockupWithProduct(orderID uint, logoURL string, product string, pro
     }
     
     // Save the final mockup
-    outputPath := fmt.Sprintf("mockups/order_%d.png", orderID)
+    outputPath := fmt.Sprintf("mockups/%s.png", mockupName(orderID, product, productColor))
     if err := saveMockup(composite, outputPath); err != nil {
         return "", fmt.Errorf("failed to save mockup: %v", err)
     }
@@ -100,6 +104,18 @@ func GenerateMockupWithProduct(orderID uint, logoURL string, product string, pro
     return "/" + outputPath, nil
 }
 
+// mockupName names an order's mockup file for one product and color, so each line's
+// garment gets its own image
+func mockupName(orderID uint, product string, productColor string) string {
+    slug := strings.Map(func(r rune) rune {
+        if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+            return r
+        }
+        return '-'
+    }, strings.ToLower(product+" "+productColor))
+    return fmt.Sprintf("order_%d_%s", orderID, slug)
+}
+
 // GenerateMockup creates a product mockup (backward compatibility)
 func GenerateMockup(orderID uint, logoURL string) (string, error) {
     return GenerateMockupWithProduct(orderID, logoURL, "Hoodie", "gray")
@@ -196,10 +212,7 @@ func compositeImages(template, logo image.Image, config MockupConfig) (image.Ima
     
     // Calculate logo position (center it at the specified position)
     logoBounds := resizedLogo.Bounds()
-    logoRect := image.Rectangle{
-        Min: config.LogoPosition.Sub(image.Pt(logoBounds.Dx()/2, logoBounds.Dy()/2)),
-        Max: config.LogoPosition.Add(image.Pt(logoBounds.Dx()/2, logoBounds.Dy()/2)),
-    }
+    logoRect := placeLogo(logoBounds.Size(), config)
     
     // Draw logo onto composite
     draw.Draw(composite, logoRect, resizedLogo, logoBounds.Min, draw.Over)
@@ -212,18 +225,7 @@ func resizeLogo(logo image.Image, maxSize image.Point) image.Image {
     bounds := logo.Bounds()
     width, height := bounds.Dx(), bounds.Dy()
     
-    // Calculate scale factor to fit within max dimensions
-    scaleX := float64(maxSize.X) / float64(width)
-    scaleY := float64(maxSize.Y) / float64(height)
-    scale := scaleX
-    if scaleY < scaleX {
-        scale = scaleY
-    }
-    
-    // If logo is already smaller, don't upscale
-    if scale > 1.0 {
-        scale = 1.0
-    }
+    scale := logoScale(width, height, maxSize)
     
     newWidth := int(float64(width) * scale)
     newHeight := int(float64(height) * scale)
@@ -243,6 +245,73 @@ func resizeLogo(logo image.Image, maxSize image.Point) image.Image {
     return resized
 }
 
+// logoScale returns the factor a logo is shrunk by to fit within max dimensions
+func logoScale(width, height int, maxSize image.Point) float64 {
+    // Calculate scale factor to fit within max dimensions
+    scaleX := float64(maxSize.X) / float64(width)
+    scaleY := float64(maxSize.Y) / float64(height)
+    scale := scaleX
+    if scaleY < scaleX {
+        scale = scaleY
+    }
+    
+    // If logo is already smaller, don't upscale
+    if scale > 1.0 {
+        scale = 1.0
+    }
+    return scale
+}
+
+// placeLogo returns the rectangle a resized logo occupies, centered at the configured position
+func placeLogo(logoSize image.Point, config MockupConfig) image.Rectangle {
+    return image.Rectangle{
+        Min: config.LogoPosition.Sub(image.Pt(logoSize.X/2, logoSize.Y/2)),
+        Max: config.LogoPosition.Add(image.Pt(logoSize.X/2, logoSize.Y/2)),
+    }
+}
+
+// PrintArea is the real-world size of a printed logo
+type PrintArea struct {
+    WidthCm  float64 `json:"widthCm"`
+    HeightCm float64 `json:"heightCm"`
+}
+
+// SquareCm returns the printed area in square centimeters
+func (a PrintArea) SquareCm() float64 {
+    return a.WidthCm * a.HeightCm
+}
+
+// MeasurePrintArea computes the size a logo prints at on a product, using the same
+// resizing and placement as compositeImages
+func MeasurePrintArea(logoURL string, product string) (PrintArea, error) {
+    config := GetMockupConfig(product)
+
+    logo, err := loadLogo("." + logoURL)
+    if err != nil {
+        return PrintArea{}, fmt.Errorf("failed to load logo: %v", err)
+    }
+
+    bounds := logo.Bounds()
+    scale := logoScale(bounds.Dx(), bounds.Dy(), config.LogoMaxSize)
+    size := image.Pt(int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale))
+    rect := placeLogo(size, config)
+
+    return PrintArea{
+        WidthCm:  float64(rect.Dx()) / config.PixelsPerCm,
+        HeightCm: float64(rect.Dy()) / config.PixelsPerCm,
+    }, nil
+}
+
+// MaxPrintArea is the largest area a product's print placement allows, used when
+// no logo was composited (e.g. AI-generated designs)
+func MaxPrintArea(product string) PrintArea {
+    config := GetMockupConfig(product)
+    return PrintArea{
+        WidthCm:  float64(config.LogoMaxSize.X) / config.PixelsPerCm,
+        HeightCm: float64(config.LogoMaxSize.Y) / config.PixelsPerCm,
+    }
+}
+
 // saveMockup saves the composite image to file
 func saveMockup(img image.Image, outputPath string) error {
     // Ensure directory exists
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// StatusChange is the payload posted to the order webhook
+type StatusChange struct {
+	Event     string       `json:"event"`
+	OrderID   uint         `json:"orderId"`
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Order     models.Order `json:"order"`
+	ChangedAt time.Time    `json:"changedAt"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendStatusEmail emails NOTIFY_EMAIL about a status change over SMTP_HOST. Without
+// SMTP settings the message is logged instead.
+func sendStatusEmail(db *gorm.DB, order models.Order, from string) error {
+	to := os.Getenv("NOTIFY_EMAIL")
+	host := os.Getenv("SMTP_HOST")
+	subject := fmt.Sprintf("Order #%d is now %s", order.ID, order.Status)
+	if to == "" || host == "" {
+		log.Printf("Email not configured, skipping: %s", subject)
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	sender := os.Getenv("SMTP_FROM")
+	if sender == "" {
+		sender = "printflow@localhost"
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	lines, err := LoadOrderLines(db, &order)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\nOrder #%d moved from %s to %s.\r\n\r\n%s\r\n",
+		sender, to, subject, order.ID, from, order.Status, strings.Join(LineSummary(lines), "\r\n"))
+	return smtp.SendMail(host+":"+port, auth, sender, []string{to}, []byte(body))
+}
+
+// enqueueLabel generates the order's shipping label from its fulfilling location to
+// its stored shipping address
+func enqueueLabel(db *gorm.DB, order models.Order, from string) error {
+	location, err := FulfillingLocation(db, &order)
+	if err != nil {
+		return err
+	}
+	lines, err := LoadOrderLines(db, &order)
+	if err != nil {
+		return err
+	}
+	shipTo, _, err := OrderShipTo(db, &order)
+	if err != nil {
+		return err
+	}
+	url, err := GenerateLabel(order.ID, shipTo, ShipFromAddress(location), lines)
+	if err != nil {
+		return err
+	}
+	log.Printf("Generated label %s for order %d", url, order.ID)
+	return nil
+}
+
+// postStatusWebhook posts the status change as JSON to WEBHOOK_URL, if set
+func postStatusWebhook(db *gorm.DB, order models.Order, from string) error {
+	url := os.Getenv("WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(StatusChange{
+		Event:     "order.status_changed",
+		OrderID:   order.ID,
+		From:      from,
+		To:        order.Status,
+		Order:     order,
+		ChangedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// DefaultOrderRetentionDays is how long deleted orders are kept when ORDER_RETENTION_DAYS is not set
+const DefaultOrderRetentionDays = 30
+
+var (
+	ErrUnknownOrder = errors.New("order not found")
+	ErrOrderLocked  = errors.New("order can no longer be changed")
+)
+
+// OrderLinePatch changes one line of an order. Nil fields are left as they are.
+type OrderLinePatch struct {
+	ID           uint     `json:"id"` // optional for single-line orders
+	Product      *string  `json:"product"`
+	Color        *string  `json:"color"`
+	Size         *string  `json:"size"`
+	Quantity     *int     `json:"quantity"`
+	Placements   []string `json:"placements"`
+	LogoColors   *int     `json:"logoColors"`
+	PrintAreaCm2 *float64 `json:"printAreaCm2"`
+}
+
+// OrderPatch is a partial update of an order. A zero CustomerID unlinks the customer.
+type OrderPatch struct {
+	Lines             []OrderLinePatch
+	CustomerID        *uint
+	ShippingAddressID *uint
+}
+
+// OrderValidationError lists every rejected field of an order patch, keyed by its
+// JSON path such as "lines[0].size"
+type OrderValidationError struct {
+	Fields map[string]string
+}
+
+func (e *OrderValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = key + ": " + e.Fields[key]
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidOrder, strings.Join(messages, "; "))
+}
+
+func (e *OrderValidationError) Is(target error) bool {
+	return target == ErrInvalidOrder
+}
+
+// Editable reports whether an order is still before approval, when its lines and
+// customer may be changed and it may be deleted
+func Editable(order *models.Order) bool {
+	if order.Status == models.StatusOnHold || order.Status == models.StatusCancelled {
+		return false
+	}
+	return !ReachedStatus(order, models.StatusApproved)
+}
+
+// UpdateOrder applies patch to an order that has not been approved. Moving a line to
+// another product or color gives it that garment's design without a mockup; if the
+// order had its mockups it goes back to REVISION_REQUESTED so a new one is generated.
+// It reports whether any mockup was invalidated. An edit only changes the order's
+// existing lines: patches naming a line the order does not have, or setting a
+// quantity of zero, are rejected rather than adding or removing lines.
+func UpdateOrder(tx *gorm.DB, order *models.Order, patch OrderPatch, meta TransitionMeta) (bool, error) {
+	if !Editable(order) {
+		return false, fmt.Errorf("%w: order is %s; only orders awaiting approval can be edited", ErrOrderLocked, order.Status)
+	}
+
+	lines, err := LoadOrderLines(tx, order)
+	if err != nil {
+		return false, err
+	}
+	invalid := make(map[string]string)
+
+	// Work out each line's new values before changing anything
+	updated := append([]models.OrderLine{}, lines...)
+	patched := make(map[int]bool)
+	for i, p := range patch.Lines {
+		field := fmt.Sprintf("lines[%d]", i)
+		index := -1
+		if p.ID == 0 && len(lines) == 1 {
+			index = 0
+		}
+		for j := range lines {
+			if p.ID != 0 && lines[j].ID == p.ID {
+				index = j
+			}
+		}
+		if index < 0 {
+			if p.ID == 0 {
+				invalid[field+".id"] = "id is required on orders with several lines"
+			} else {
+				invalid[field+".id"] = fmt.Sprintf("order has no line %d; lines cannot be added by an edit, place a new order", p.ID)
+			}
+			continue
+		}
+		if patched[index] {
+			invalid[field+".id"] = "line is changed twice"
+			continue
+		}
+		patched[index] = true
+
+		line := &updated[index]
+		if p.Product != nil {
+			line.Product = strings.TrimSpace(*p.Product)
+		}
+		if p.Color != nil {
+			line.Color = strings.TrimSpace(*p.Color)
+		}
+		if p.Size != nil {
+			line.Size = strings.TrimSpace(*p.Size)
+		}
+		if p.Quantity != nil {
+			if *p.Quantity <= 0 {
+				invalid[field+".quantity"] = "must be positive; lines cannot be removed by an edit"
+			}
+			line.Quantity = *p.Quantity
+		}
+		if p.Placements != nil {
+			placements, err := normalizePlacements(p.Placements)
+			if err != nil {
+				invalid[field+".placements"] = strings.TrimPrefix(err.Error(), ErrInvalidOrder.Error()+": ")
+			}
+			line.Placements = placements
+		}
+		if p.LogoColors != nil {
+			if *p.LogoColors < 0 {
+				invalid[field+".logoColors"] = "cannot be negative"
+			}
+			line.LogoColors = *p.LogoColors
+		}
+		if p.PrintAreaCm2 != nil {
+			if *p.PrintAreaCm2 < 0 {
+				invalid[field+".printAreaCm2"] = "cannot be negative"
+			}
+			line.PrintAreaCm2 = *p.PrintAreaCm2
+		}
+
+		if p.Product != nil || p.Color != nil || p.Size != nil {
+			item, err := FindInventoryItem(tx, line.Product, line.Color, line.Size)
+			if errors.Is(err, ErrUnknownSKU) {
+				invalid[field+".size"] = fmt.Sprintf("%s %s %s is not a stocked SKU", line.Color, line.Product, line.Size)
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			line.InventoryItemID = item.ID
+			line.Product, line.Color, line.Size = item.Product, item.Color, item.Size
+		}
+	}
+
+	// The order keeps its workflow, so every garment must stay on it
+	workflow, err := WorkflowFor(order.Product)
+	if err != nil {
+		return false, err
+	}
+	for i, line := range updated {
+		other, err := WorkflowFor(line.Product)
+		if err != nil {
+			return false, err
+		}
+		if other.Name != workflow.Name {
+			invalid[fmt.Sprintf("lines[%d].product", i)] = fmt.Sprintf("%s follows the %s workflow, not %s; place a new order", line.Product, other.Name, workflow.Name)
+		}
+	}
+
+	customerID, addressID, err := patchCustomer(tx, order, patch, invalid)
+	if err != nil {
+		return false, err
+	}
+
+	if len(invalid) > 0 {
+		return false, &OrderValidationError{Fields: invalid}
+	}
+
+	// Changed lines, or a new customer on another price list, are repriced at the
+	// current price table, replacing any quoted price. Discount codes that no longer
+	// apply are dropped.
+	options := PriceOptions{Rush: order.Rush}
+	if customerID != nil {
+		customer, err := GetCustomer(tx, *customerID)
+		if err != nil {
+			return false, err
+		}
+		options.PriceList = customer.PriceList
+	}
+	newCustomer := derefID(customerID) != derefID(order.CustomerID)
+	repriced := false
+	if len(patch.Lines) > 0 || (newCustomer && !strings.EqualFold(options.PriceList, order.PriceList)) {
+		if options.DiscountCodes, err = OrderDiscountCodes(tx, order.ID); err != nil {
+			return false, err
+		}
+		price, err := PriceOrderLines(updated, options)
+		if err != nil {
+			return false, err
+		}
+		applyPrice(order, updated, price)
+		// Orders from before pricing take the new prices but stay unpriced, so untaxed
+		if order.PricedAt != nil {
+			now := time.Now()
+			order.PricedAt = &now
+		}
+		if err := replaceOrderDiscounts(tx, order); err != nil {
+			return false, err
+		}
+		repriced = true
+	}
+
+	invalidated, err := reassignDesigns(tx, order.ID, lines, updated)
+	if err != nil {
+		return false, err
+	}
+	order.Lines = updated
+	order.Product, order.Color, order.Size = updated[0].Product, updated[0].Color, updated[0].Size
+	order.CustomerID, order.ShippingAddressID = customerID, addressID
+
+	// Tax follows the prices, where the order ships and whether the customer is exempt.
+	// Orders from before pricing are left untaxed, even when their lines are repriced.
+	retax := order.PricedAt != nil && (repriced || patch.CustomerID != nil || patch.ShippingAddressID != nil)
+	if retax {
+		if err := taxOrder(tx, order, updated); err != nil {
+			return false, err
+		}
+	}
+	if repriced || retax {
+		// Money already taken is never silently turned into an overpayment
+		if roundCents(order.Total) < roundCents(order.AmountPaid) {
+			return false, &OrderValidationError{Fields: map[string]string{
+				"total": fmt.Sprintf("the edit brings the total to %.2f, below the %.2f already paid; refund the difference first", order.Total, order.AmountPaid),
+			}}
+		}
+		order.PaymentStatus = paymentStatus(order.Total, order.AmountPaid, order.PaymentStatus == models.PaymentRefunded)
+	}
+	for i := range updated {
+		if err := tx.Omit("Taxes").Save(&updated[i]).Error; err != nil {
+			return false, err
+		}
+	}
+	if retax {
+		if err := replaceLineTaxes(tx, updated); err != nil {
+			return false, err
+		}
+	}
+
+	if invalidated && order.Status == models.StatusMockupGenerated {
+		meta.Reason = "order edited; mockup no longer matches"
+		return true, ApplyTransition(tx, order, models.StatusRevisionRequested, meta)
+	}
+	return invalidated, SaveOrder(tx, order)
+}
+
+// patchCustomer works out the order's customer and shipping address after patch.
+// Changing the customer picks their default shipping address unless one is given.
+func patchCustomer(tx *gorm.DB, order *models.Order, patch OrderPatch, invalid map[string]string) (*uint, *uint, error) {
+	customerID, addressID := order.CustomerID, order.ShippingAddressID
+	if patch.CustomerID == nil && patch.ShippingAddressID == nil {
+		return customerID, addressID, nil
+	}
+	if patch.CustomerID != nil {
+		customerID = nil
+		if *patch.CustomerID != 0 {
+			customerID = patch.CustomerID
+		}
+	}
+	var requested uint
+	if patch.ShippingAddressID != nil {
+		requested = *patch.ShippingAddressID
+	}
+
+	if customerID == nil {
+		if requested != 0 {
+			invalid["shippingAddressId"] = "a shipping address needs a customer"
+		}
+		return nil, nil, nil
+	}
+	if _, err := GetCustomer(tx, *customerID); errors.Is(err, ErrUnknownCustomer) {
+		invalid["customerId"] = err.Error()
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	address, err := ResolveShippingAddress(tx, *customerID, requested)
+	if errors.Is(err, ErrUnknownAddress) || errors.Is(err, ErrInvalidCustomer) {
+		invalid["shippingAddressId"] = err.Error()
+		return nil, nil, nil
+	}
+	if err != nil || address == nil {
+		return customerID, nil, err
+	}
+	return customerID, &address.ID, nil
+}
+
+// reassignDesigns points lines whose product or color changed at the asset for their
+// new garment, creating it from their old artwork when the order has none, and drops
+// assets no line uses any more. It reports whether any line lost its mockup.
+func reassignDesigns(tx *gorm.DB, orderID uint, before, after []models.OrderLine) (bool, error) {
+	assets, err := OrderAssets(tx, orderID)
+	if err != nil {
+		return false, err
+	}
+	byID := make(map[uint]*models.Asset)
+	byKey := make(map[string]*models.Asset)
+	for i := range assets {
+		byID[assets[i].ID] = &assets[i]
+		byKey[designKey(assets[i].Product, assets[i].Color)] = &assets[i]
+	}
+
+	invalidated := false
+	for i := range after {
+		key := designKey(after[i].Product, after[i].Color)
+		if key == designKey(before[i].Product, before[i].Color) {
+			continue
+		}
+
+		asset, ok := byKey[key]
+		if !ok {
+			// Keep the artwork but not the mockup, which showed the old garment
+			asset = &models.Asset{OrderID: orderID, Product: after[i].Product, Color: after[i].Color}
+			if old := before[i].AssetID; old != nil && byID[*old] != nil {
+				asset.LogoURL = byID[*old].LogoURL
+				asset.AIGenerated = byID[*old].AIGenerated
+				asset.AIPrompt = byID[*old].AIPrompt
+			}
+			if err := tx.Create(asset).Error; err != nil {
+				return false, err
+			}
+			byID[asset.ID] = asset
+			byKey[key] = asset
+		}
+		if asset.MockupURL == "" {
+			invalidated = true
+		}
+		after[i].AssetID = &asset.ID
+	}
+
+	used := make(map[uint]bool)
+	for _, line := range after {
+		if line.AssetID != nil {
+			used[*line.AssetID] = true
+		}
+	}
+	for id := range byID {
+		if !used[id] {
+			if err := tx.Delete(&models.Asset{}, id).Error; err != nil {
+				return false, err
+			}
+		}
+	}
+	return invalidated, nil
+}
+
+// DeleteOrder soft-deletes an order awaiting approval or cancelled, so it no longer
+// appears anywhere until restored or purged. Orders holding money that has not been
+// refunded cannot be deleted.
+func DeleteOrder(tx *gorm.DB, order *models.Order) error {
+	if !Editable(order) && order.Status != models.StatusCancelled {
+		return fmt.Errorf("%w: order is %s; cancel it before deleting", ErrOrderLocked, order.Status)
+	}
+	payments, err := OrderPayments(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	paid, refunded := paymentTotals(payments)
+	if net := roundCents(paid - refunded); order.AmountPaid > 0 || net > 0 {
+		return fmt.Errorf("%w: order has %.2f paid; refund it before deleting", ErrOrderLocked, max(net, order.AmountPaid))
+	}
+	if err := SaveOrder(tx, order); err != nil {
+		return err
+	}
+	return tx.Delete(order).Error
+}
+
+// DeletedOrder loads a deleted order that has not been purged
+func DeletedOrder(tx *gorm.DB, id uint) (*models.Order, error) {
+	var order models.Order
+	err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(&order, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownOrder
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// RestoreOrder brings back a deleted order
+func RestoreOrder(tx *gorm.DB, order *models.Order) error {
+	order.DeletedAt = gorm.DeletedAt{}
+	return SaveOrder(tx.Unscoped(), order)
+}
+
+// PurgeDeletedOrders permanently removes orders deleted before cutoff along with their
+// lines, their taxes, artwork and history. Stock movements stay in the ledger, and
+// orders with payments, even refunded ones, are kept so the payments keep their order.
+func PurgeDeletedOrders(tx *gorm.DB, cutoff time.Time) (int, error) {
+	var ids []uint
+	err := tx.Unscoped().Model(&models.Order{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+		Where("id NOT IN (?)", tx.Model(&models.Payment{}).Select("order_id")).
+		Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	lineIDs := tx.Model(&models.OrderLine{}).Select("id").Where("order_id IN ?", ids)
+	if err := tx.Where("order_line_id IN (?)", lineIDs).Delete(&models.OrderLineTax{}).Error; err != nil {
+		return 0, err
+	}
+	for _, model := range []interface{}{&models.OrderLine{}, &models.OrderDiscount{}, &models.Asset{}, &models.OrderEvent{}} {
+		if err := tx.Where("order_id IN ?", ids).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Unscoped().Delete(&models.Order{}, ids).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// OrderRetention returns how long deleted orders are kept, from ORDER_RETENTION_DAYS.
+// Zero disables purging.
+func OrderRetention() time.Duration {
+	days := DefaultOrderRetentionDays
+	if value := os.Getenv("ORDER_RETENTION_DAYS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			days = n
+		} else {
+			log.Printf("Ignoring invalid ORDER_RETENTION_DAYS %q", value)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StartOrderPurge purges orders deleted longer than retention ago now and then every
+// interval, in the background
+func StartOrderPurge(db *gorm.DB, retention, interval time.Duration) {
+	if retention <= 0 {
+		log.Println("Order purge disabled")
+		return
+	}
+	purge := func() {
+		var purged int
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			purged, err = PurgeDeletedOrders(tx, time.Now().Add(-retention))
+			return err
+		})
+		if err != nil {
+			log.Printf("Order purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted orders", purged)
+		}
+	}
+
+	go func() {
+		purge()
+		for range time.Tick(interval) {
+			purge()
+		}
+	}()
+}
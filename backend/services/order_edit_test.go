@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"printflow/models"
+)
+
+func TestOrderWithPaymentsIsNotDeletedOrPurged(t *testing.T) {
+	tx := newTestDB(t)
+	order := createTestOrder(t, tx, models.StatusCreated, 100)
+	if _, err := RecordPayment(tx, order, PaymentInput{Amount: 40}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteOrder(tx, order); !errors.Is(err, ErrOrderLocked) {
+		t.Fatalf("deleting a paid order: got %v, want ErrOrderLocked", err)
+	}
+
+	// Refunded, the order can be deleted, but the purge keeps it for its payments
+	if _, err := RecordPayment(tx, order, PaymentInput{Kind: "refund", Amount: 40}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteOrder(tx, order); err != nil {
+		t.Fatalf("deleting a refunded order: %v", err)
+	}
+	purged, err := PurgeDeletedOrders(tx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 {
+		t.Fatalf("purged %d orders, want 0", purged)
+	}
+	var payments int64
+	tx.Model(&models.Payment{}).Where("order_id = ?", order.ID).Count(&payments)
+	var orders int64
+	tx.Unscoped().Model(&models.Order{}).Where("id = ?", order.ID).Count(&orders)
+	if payments != 2 || orders != 1 {
+		t.Fatalf("after purge: %d payments and %d orders, want 2 and 1", payments, orders)
+	}
+}
+
+func TestPurgeRemovesDeletedOrdersWithoutPayments(t *testing.T) {
+	tx := newTestDB(t)
+	order := createTestOrder(t, tx, models.StatusCreated, 100)
+	if err := DeleteOrder(tx, order); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := PurgeDeletedOrders(tx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines int64
+	tx.Model(&models.OrderLine{}).Where("order_id = ?", order.ID).Count(&lines)
+	if purged != 1 || lines != 0 {
+		t.Fatalf("purged %d orders leaving %d lines, want 1 and 0", purged, lines)
+	}
+}
+
+func TestEditCannotTakeTotalBelowAmountPaid(t *testing.T) {
+	tx := newTestDB(t)
+	order := createTestOrder(t, tx, models.StatusCreated, 200)
+	if _, err := RecordPayment(tx, order, PaymentInput{Amount: 150}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	one := 1
+	_, err := UpdateOrder(tx, order, OrderPatch{Lines: []OrderLinePatch{{Quantity: &one}}}, TransitionMeta{Actor: "test"})
+	var validationErr *OrderValidationError
+	if !errors.As(err, &validationErr) || validationErr.Fields["total"] == "" {
+		t.Fatalf("got %v, want a validation error on total", err)
+	}
+
+	// Refunded down to the new total, the same edit goes through
+	if _, err := RecordPayment(tx, order, PaymentInput{Kind: "refund", Amount: 150}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateOrder(tx, order, OrderPatch{Lines: []OrderLinePatch{{Quantity: &one}}}, TransitionMeta{Actor: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if order.Total >= 150 || order.Total < order.AmountPaid {
+		t.Errorf("total %.2f with %.2f paid", order.Total, order.AmountPaid)
+	}
+}
+
+func TestEditTaxesOnlyOrdersPricedAtCreation(t *testing.T) {
+	tx := newTestDB(t)
+	address := models.CustomerAddress{Kind: models.AddressShipping, Address: "1 Congress Ave", City: "Austin", State: "TX", Zip: "78701"}
+	if err := tx.Create(&address).Error; err != nil {
+		t.Fatal(err)
+	}
+	twelve := 12
+	edit := OrderPatch{Lines: []OrderLinePatch{{Quantity: &twelve}}}
+
+	legacy := createTestOrder(t, tx, models.StatusCreated, 100)
+	legacy.ShippingAddressID = &address.ID
+	if _, err := UpdateOrder(tx, legacy, edit, TransitionMeta{Actor: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.PricedAt != nil || legacy.TaxTotal != 0 || legacy.Total != legacy.Subtotal-legacy.DiscountTotal+legacy.RushFee {
+		t.Errorf("order from before pricing: priced at %v with %.2f tax, want it repriced but untaxed", legacy.PricedAt, legacy.TaxTotal)
+	}
+
+	priced := createTestOrder(t, tx, models.StatusCreated, 100)
+	pricedAt := time.Now().Add(-time.Hour)
+	priced.PricedAt, priced.ShippingAddressID = &pricedAt, &address.ID
+	if _, err := UpdateOrder(tx, priced, edit, TransitionMeta{Actor: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if !priced.PricedAt.After(pricedAt) || priced.TaxTotal <= 0 || priced.TaxState != "TX" {
+		t.Errorf("priced order: %.2f tax for %q, want it retaxed for TX", priced.TaxTotal, priced.TaxState)
+	}
+}
+
+func TestEditCannotAddOrRemoveLines(t *testing.T) {
+	tx := newTestDB(t)
+	order := createTestOrder(t, tx, models.StatusCreated, 100)
+	zero := 0
+	size := "L"
+	cases := []struct {
+		name  string
+		patch OrderLinePatch
+		field string
+	}{
+		{"new line", OrderLinePatch{ID: order.Lines[0].ID + 100, Size: &size}, "lines[0].id"},
+		{"removed line", OrderLinePatch{Quantity: &zero}, "lines[0].quantity"},
+	}
+	for _, tc := range cases {
+		_, err := UpdateOrder(tx, order, OrderPatch{Lines: []OrderLinePatch{tc.patch}}, TransitionMeta{Actor: "test"})
+		var validationErr *OrderValidationError
+		if !errors.As(err, &validationErr) || validationErr.Fields[tc.field] == "" {
+			t.Errorf("%s: got %v, want a validation error on %s", tc.name, err, tc.field)
+		}
+	}
+}
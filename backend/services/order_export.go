@@ -0,0 +1,564 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// Export formats
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// exportBatchSize is how many orders are read at a time; each batch is a short query,
+// so a long export neither holds every order in memory nor keeps the database locked
+const exportBatchSize = 500
+
+// ExportedOrder is one order as exported, with its customer, lines and artwork
+type ExportedOrder struct {
+	ID              uint             `json:"id"`
+	Status          string           `json:"status"`
+	LocationID      uint             `json:"locationId"`
+	CostOfGoods     float64          `json:"costOfGoods"`
+	Rush            string           `json:"rush"`
+	Subtotal        float64          `json:"subtotal"`
+	PriceList       string           `json:"priceList"`
+	DiscountTotal   float64          `json:"discountTotal"`
+	RushFee         float64          `json:"rushFee"`
+	TaxTotal        float64          `json:"taxTotal"`
+	TaxCertificate  string           `json:"taxCertificate,omitempty"`
+	Total           float64          `json:"total"`
+	PaymentStatus   string           `json:"paymentStatus"`
+	AmountPaid      float64          `json:"amountPaid"`
+	CreatedAt       time.Time        `json:"createdAt"`
+	StatusChangedAt *time.Time       `json:"statusChangedAt"`
+	DeletedAt       *time.Time       `json:"deletedAt,omitempty"`
+	Customer        *ExportedParty   `json:"customer"`
+	ShipTo          *ExportedAddress `json:"shipTo"`
+	Lines           []ExportedLine   `json:"lines"`
+	Assets          []ExportedAsset  `json:"assets"`
+}
+
+type ExportedParty struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Company string `json:"company"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+}
+
+type ExportedAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Zip     string `json:"zip"`
+}
+
+type ExportedLine struct {
+	ID         uint    `json:"id"`
+	Product    string  `json:"product"`
+	Color      string  `json:"color"`
+	Size       string  `json:"size"`
+	Quantity   int     `json:"quantity"`
+	Placements string  `json:"placements"`
+	UnitPrice  float64 `json:"unitPrice"`
+	LineTotal  float64 `json:"lineTotal"`
+	Discount   float64 `json:"discount"`
+	TaxRate    float64 `json:"taxRate"`
+	Tax        float64 `json:"tax"`
+	AssetID    *uint   `json:"assetId"`
+}
+
+type ExportedAsset struct {
+	ID            uint    `json:"id"`
+	Product       string  `json:"product"`
+	Color         string  `json:"color"`
+	LogoURL       string  `json:"logoUrl"`
+	MockupURL     string  `json:"mockupUrl"`
+	AIPrompt      string  `json:"aiPrompt"`
+	PrintWidthCm  float64 `json:"printWidthCm"`
+	PrintHeightCm float64 `json:"printHeightCm"`
+}
+
+// exportColumns heads CSV and XLSX exports, which have one row per order line
+var exportColumns = []string{
+	"orderId", "status", "createdAt", "statusChangedAt", "deletedAt", "locationId", "orderCostOfGoods",
+	"rush", "orderSubtotal", "priceList", "orderDiscount", "orderRushFee", "orderTax", "taxCertificate", "orderTotal", "paymentStatus", "amountPaid",
+	"customerId", "customerName", "customerCompany", "customerEmail", "customerPhone",
+	"shipToName", "shipToAddress", "shipToCity", "shipToState", "shipToZip",
+	"lineId", "product", "color", "size", "quantity", "placements", "unitPrice", "lineTotal", "lineDiscount", "lineTaxRate", "lineTax", "logoUrl", "mockupUrl", "aiPrompt",
+}
+
+// ExportContentType is the Content-Type to serve an export format with
+func ExportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return ""
+}
+
+// ExportOptions controls an export. Asset paths such as /mockups/... are prefixed
+// with BaseURL so the export links work outside the app.
+type ExportOptions struct {
+	Format  string
+	BaseURL string
+}
+
+// ExportOrders writes every order matching q, ignoring its limit and offset, to w in
+// the chosen format. The query and format are checked before anything is written, so
+// an ErrInvalidOrderQuery can still be reported in place of the export.
+func ExportOrders(tx *gorm.DB, q OrderQuery, options ExportOptions, w io.Writer) error {
+	if q.Sort == "" {
+		q.Sort = DefaultOrderSort
+	}
+	ordering, err := orderOrdering(q.Sort)
+	if err != nil {
+		return err
+	}
+	if ExportContentType(options.Format) == "" {
+		return fmt.Errorf("%w: format must be csv, ndjson or xlsx", ErrInvalidOrderQuery)
+	}
+
+	// Stop at the newest order that exists now, so orders created during the export
+	// are left for the next one
+	var lastID uint
+	if err := tx.Unscoped().Model(&models.Order{}).Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error; err != nil {
+		return err
+	}
+
+	out, err := newOrderExportWriter(w, options.Format)
+	if err != nil {
+		return err
+	}
+	// Batches continue after the last order exported rather than at an offset, so
+	// orders deleted or changed mid-export do not shift later batches
+	column := orderSortColumns[strings.TrimPrefix(q.Sort, "-")]
+	after := "(%[1]s > ? OR (%[1]s = ? AND orders.id > ?))"
+	if strings.HasPrefix(q.Sort, "-") {
+		after = "(%[1]s < ? OR (%[1]s = ? AND orders.id < ?))"
+	}
+	var cursor *exportCursor
+	for {
+		query := orderScope(tx, q).Where("orders.id <= ?", lastID)
+		if len(q.Statuses) > 0 {
+			query = query.Where("orders.status IN ?", q.Statuses)
+		}
+		if cursor != nil {
+			query = query.Where(fmt.Sprintf(after, column), cursor.SortKey, cursor.SortKey, cursor.ID)
+		}
+		page, err := exportPage(query.Select(fmt.Sprintf("orders.id, %s", column)).Order(ordering).Limit(exportBatchSize))
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return out.close()
+		}
+		cursor = &page[len(page)-1]
+
+		ids := make([]uint, len(page))
+		for i, row := range page {
+			ids[i] = row.ID
+		}
+		orders, err := exportOrdersByID(tx, ids, q.Deleted)
+		if err != nil {
+			return err
+		}
+		exported, err := exportBatch(tx, orders, options.BaseURL)
+		if err != nil {
+			return err
+		}
+		for _, order := range exported {
+			if err := out.write(order); err != nil {
+				return err
+			}
+		}
+		if err := out.flush(); err != nil {
+			return err
+		}
+		if len(page) < exportBatchSize {
+			return out.close()
+		}
+	}
+}
+
+// exportCursor is an exported order's position in the export's sort order
+type exportCursor struct {
+	ID      uint
+	SortKey interface{}
+}
+
+// exportPage reads the ids and sort keys query selects. The keys are scanned as
+// whatever the database stored so they bind back unchanged in the next batch.
+func exportPage(query *gorm.DB) ([]exportCursor, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var page []exportCursor
+	for rows.Next() {
+		var row exportCursor
+		if err := rows.Scan(&row.ID, &row.SortKey); err != nil {
+			return nil, err
+		}
+		page = append(page, row)
+	}
+	return page, rows.Err()
+}
+
+// exportOrdersByID loads orders with their lines, in the order of ids
+func exportOrdersByID(tx *gorm.DB, ids []uint, deleted bool) ([]models.Order, error) {
+	query := tx
+	if deleted {
+		query = query.Unscoped()
+	}
+	var found []models.Order
+	err := query.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("id IN ?", ids).
+		Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Order, len(found))
+	for _, order := range found {
+		byID[order.ID] = order
+	}
+	orders := make([]models.Order, 0, len(ids))
+	for _, id := range ids {
+		if order, ok := byID[id]; ok {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// exportBatch gathers the customers, addresses, artwork and last status change of a
+// batch of orders in one query each
+func exportBatch(tx *gorm.DB, orders []models.Order, baseURL string) ([]ExportedOrder, error) {
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	var orderIDs, customerIDs, addressIDs []uint
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.ID)
+		if order.CustomerID != nil {
+			customerIDs = append(customerIDs, *order.CustomerID)
+		}
+		if order.ShippingAddressID != nil {
+			addressIDs = append(addressIDs, *order.ShippingAddressID)
+		}
+	}
+
+	var assets []models.Asset
+	if err := tx.Where("order_id IN ?", orderIDs).Order("id").Find(&assets).Error; err != nil {
+		return nil, err
+	}
+	assetsByOrder := make(map[uint][]ExportedAsset)
+	for _, asset := range assets {
+		assetsByOrder[asset.OrderID] = append(assetsByOrder[asset.OrderID], ExportedAsset{
+			ID:            asset.ID,
+			Product:       asset.Product,
+			Color:         asset.Color,
+			LogoURL:       absoluteURL(baseURL, asset.LogoURL),
+			MockupURL:     absoluteURL(baseURL, asset.MockupURL),
+			AIPrompt:      asset.AIPrompt,
+			PrintWidthCm:  asset.PrintWidthCm,
+			PrintHeightCm: asset.PrintHeightCm,
+		})
+	}
+
+	customers := make(map[uint]models.Customer)
+	if len(customerIDs) > 0 {
+		var found []models.Customer
+		if err := tx.Where("id IN ?", customerIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, customer := range found {
+			customers[customer.ID] = customer
+		}
+	}
+	addresses := make(map[uint]models.CustomerAddress)
+	if len(addressIDs) > 0 {
+		var found []models.CustomerAddress
+		if err := tx.Where("id IN ?", addressIDs).Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, address := range found {
+			addresses[address.ID] = address
+		}
+	}
+
+	var events []models.OrderEvent
+	latest := tx.Model(&models.OrderEvent{}).Select("MAX(id)").Where("order_id IN ?", orderIDs).Group("order_id")
+	if err := tx.Where("id IN (?)", latest).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	changedAt := make(map[uint]time.Time)
+	for _, event := range events {
+		changedAt[event.OrderID] = event.CreatedAt
+	}
+
+	exported := make([]ExportedOrder, len(orders))
+	for i, order := range orders {
+		e := ExportedOrder{
+			ID:             order.ID,
+			Status:         order.Status,
+			LocationID:     order.LocationID,
+			CostOfGoods:    order.CostOfGoods,
+			Rush:           order.Rush,
+			Subtotal:       order.Subtotal,
+			PriceList:      order.PriceList,
+			DiscountTotal:  order.DiscountTotal,
+			RushFee:        order.RushFee,
+			TaxTotal:       order.TaxTotal,
+			TaxCertificate: order.TaxCertificate,
+			Total:          order.Total,
+			PaymentStatus:  order.PaymentStatus,
+			AmountPaid:     order.AmountPaid,
+			CreatedAt:      order.CreatedAt,
+			Lines:          make([]ExportedLine, len(order.Lines)),
+			Assets:         assetsByOrder[order.ID],
+		}
+		if at, ok := changedAt[order.ID]; ok {
+			e.StatusChangedAt = &at
+		}
+		if order.DeletedAt.Valid {
+			e.DeletedAt = &order.DeletedAt.Time
+		}
+		if order.CustomerID != nil {
+			if customer, ok := customers[*order.CustomerID]; ok {
+				e.Customer = &ExportedParty{ID: customer.ID, Name: customer.Name, Company: customer.Company, Email: customer.Email, Phone: customer.Phone}
+			}
+		}
+		if order.ShippingAddressID != nil {
+			if address, ok := addresses[*order.ShippingAddressID]; ok {
+				e.ShipTo = &ExportedAddress{Name: address.Name, Address: address.Address, City: address.City, State: address.State, Zip: address.Zip}
+			}
+		}
+		if e.Assets == nil {
+			e.Assets = []ExportedAsset{}
+		}
+		for j, line := range order.Lines {
+			e.Lines[j] = ExportedLine{
+				ID:         line.ID,
+				Product:    line.Product,
+				Color:      line.Color,
+				Size:       line.Size,
+				Quantity:   line.Quantity,
+				Placements: line.Placements,
+				UnitPrice:  line.UnitPrice,
+				LineTotal:  line.LineTotal,
+				Discount:   line.Discount,
+				TaxRate:    line.TaxRate,
+				Tax:        line.Tax,
+				AssetID:    line.AssetID,
+			}
+		}
+		exported[i] = e
+	}
+	return exported, nil
+}
+
+// orderExportWriter writes exported orders in one format
+type orderExportWriter interface {
+	write(order ExportedOrder) error
+	flush() error
+	close() error
+}
+
+func newOrderExportWriter(w io.Writer, format string) (orderExportWriter, error) {
+	var table exportTable
+	switch format {
+	case ExportNDJSON:
+		return &ndjsonExport{w: w, encoder: json.NewEncoder(w)}, nil
+	case ExportXLSX:
+		sheet, err := newXLSXWriter(w, "Orders")
+		if err != nil {
+			return nil, err
+		}
+		table = sheet
+	default:
+		table = &csvTable{csv.NewWriter(w)}
+	}
+
+	header := make([]interface{}, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column
+	}
+	return &tableExport{w: w, table: table}, table.WriteRow(header)
+}
+
+// ndjsonExport writes one JSON object per order per line
+type ndjsonExport struct {
+	w       io.Writer
+	encoder *json.Encoder
+}
+
+func (n *ndjsonExport) write(order ExportedOrder) error { return n.encoder.Encode(order) }
+func (n *ndjsonExport) flush() error                    { flushHTTP(n.w); return nil }
+func (n *ndjsonExport) close() error                    { return nil }
+
+// exportTable is a sink of spreadsheet rows
+type exportTable interface {
+	WriteRow(values []interface{}) error
+	Flush() error
+	Close() error
+}
+
+// tableExport writes one row per order line, repeating the order's columns; an order
+// without lines still gets a row
+type tableExport struct {
+	w     io.Writer
+	table exportTable
+}
+
+func (t *tableExport) write(order ExportedOrder) error {
+	var customer ExportedParty
+	if order.Customer != nil {
+		customer = *order.Customer
+	}
+	var shipTo ExportedAddress
+	if order.ShipTo != nil {
+		shipTo = *order.ShipTo
+	}
+	assets := make(map[uint]ExportedAsset)
+	for _, asset := range order.Assets {
+		assets[asset.ID] = asset
+	}
+
+	lines := order.Lines
+	if len(lines) == 0 {
+		lines = []ExportedLine{{}}
+	}
+	for _, line := range lines {
+		var asset ExportedAsset
+		if line.AssetID != nil {
+			asset = assets[*line.AssetID]
+		}
+		row := []interface{}{
+			order.ID, order.Status, order.CreatedAt, order.StatusChangedAt, order.DeletedAt, order.LocationID, order.CostOfGoods,
+			order.Rush, order.Subtotal, order.PriceList, order.DiscountTotal, order.RushFee, order.TaxTotal, order.TaxCertificate, order.Total, order.PaymentStatus, order.AmountPaid,
+			optionalID(customer.ID), customer.Name, customer.Company, customer.Email, customer.Phone,
+			shipTo.Name, shipTo.Address, shipTo.City, shipTo.State, shipTo.Zip,
+			optionalID(line.ID), line.Product, line.Color, line.Size, optionalQuantity(line.Quantity), line.Placements, line.UnitPrice, line.LineTotal, line.Discount, line.TaxRate, line.Tax,
+			asset.LogoURL, asset.MockupURL, asset.AIPrompt,
+		}
+		if err := t.table.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tableExport) flush() error {
+	if err := t.table.Flush(); err != nil {
+		return err
+	}
+	flushHTTP(t.w)
+	return nil
+}
+
+func (t *tableExport) close() error {
+	if err := t.table.Close(); err != nil {
+		return err
+	}
+	flushHTTP(t.w)
+	return nil
+}
+
+type csvTable struct {
+	w *csv.Writer
+}
+
+func (c *csvTable) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case int:
+			record[i] = strconv.Itoa(v)
+		case uint:
+			record[i] = strconv.FormatUint(uint64(v), 10)
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			record[i] = exportText(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvTable) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvTable) Close() error { return c.Flush() }
+
+// exportText formats a non-numeric cell; times are RFC 3339 and nil is blank. Text
+// a spreadsheet would run as a formula, such as a customer named "=HYPERLINK(...)",
+// is prefixed with ' so it shows as typed.
+func exportText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// optionalID and optionalQuantity leave a cell blank instead of writing 0
+func optionalID(id uint) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func optionalQuantity(quantity int) interface{} {
+	if quantity == 0 {
+		return nil
+	}
+	return quantity
+}
+
+func absoluteURL(baseURL, path string) string {
+	if path == "" || !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return strings.TrimSuffix(baseURL, "/") + path
+}
+
+// flushHTTP sends what has been written so far when w is a streaming HTTP response
+func flushHTTP(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// deletingWriter soft-deletes the first order written, as a user deleting an order
+// mid-export would
+type deletingWriter struct {
+	bytes.Buffer
+	tx      *gorm.DB
+	deleted bool
+}
+
+func (w *deletingWriter) Write(p []byte) (int, error) {
+	if !w.deleted {
+		var first ExportedOrder
+		line, _, _ := bytes.Cut(p, []byte("\n"))
+		if err := json.Unmarshal(line, &first); err != nil {
+			return 0, err
+		}
+		w.tx.Delete(&models.Order{}, first.ID)
+		w.deleted = true
+	}
+	return w.Buffer.Write(p)
+}
+
+// Every order is exported once however the batches fall, even on sorts full of ties
+// and with an order deleted after the first batch went out
+func TestExportPagesPastChangesMadeDuringIt(t *testing.T) {
+	for name, sort := range map[string]string{"default": "", "customer": "customer", "status": "-status", "id": "id"} {
+		t.Run(name, func(t *testing.T) {
+			tx := newTestDB(t)
+			count := exportBatchSize + 20
+			for i := 0; i < count; i++ {
+				createTestOrder(t, tx, models.StatusCreated, 100)
+			}
+
+			w := &deletingWriter{tx: tx}
+			if err := ExportOrders(tx, OrderQuery{Sort: sort}, ExportOptions{Format: ExportNDJSON}, w); err != nil {
+				t.Fatal(err)
+			}
+			decoder := json.NewDecoder(&w.Buffer)
+			seen := make(map[uint]bool)
+			for decoder.More() {
+				var order ExportedOrder
+				if err := decoder.Decode(&order); err != nil {
+					t.Fatal(err)
+				}
+				if seen[order.ID] {
+					t.Errorf("order %d exported twice", order.ID)
+				}
+				seen[order.ID] = true
+			}
+			if len(seen) != count {
+				t.Errorf("exported %d of %d orders", len(seen), count)
+			}
+		})
+	}
+}
+
+func TestExportFiltersAndDefusesFormulas(t *testing.T) {
+	tx := newTestDB(t)
+	customer := models.Customer{Name: "=HYPERLINK(\"http://evil\")", Company: "@Acme", Email: "ann@acme.com"}
+	tx.Create(&customer)
+	order := createTestOrder(t, tx, models.StatusApproved, 100)
+	order.CustomerID = &customer.ID
+	tx.Save(order)
+	tx.Create(&models.Asset{OrderID: order.ID, AIPrompt: "-tiger"})
+	tx.Model(&models.OrderLine{}).Where("order_id = ?", order.ID).Update("asset_id", gorm.Expr("(SELECT MAX(id) FROM assets)"))
+	createTestOrder(t, tx, models.StatusCreated, 50)
+
+	var out bytes.Buffer
+	q := OrderQuery{Statuses: []string{models.StatusApproved}}
+	if err := ExportOrders(tx, q, ExportOptions{Format: ExportCSV}, &out); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d rows, want the header and the approved order's line", len(records))
+	}
+	row := make(map[string]string)
+	for i, column := range records[0] {
+		row[column] = records[1][i]
+	}
+	want := map[string]string{
+		"status":          models.StatusApproved,
+		"customerName":    `'=HYPERLINK("http://evil")`,
+		"customerCompany": "'@Acme",
+		"customerEmail":   "ann@acme.com",
+		"aiPrompt":        "'-tiger",
+		"orderTotal":      "100",
+	}
+	for column, value := range want {
+		if row[column] != value {
+			t.Errorf("%s = %q, want %q", column, row[column], value)
+		}
+	}
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// MaxImportRows caps one import so a runaway spreadsheet cannot lock the database for long
+const MaxImportRows = 5000
+
+// MaxLogoBytes is the largest logo an import will download
+const MaxLogoBytes = 10 << 20
+
+var (
+	ErrInvalidImport = errors.New("invalid import")
+	// ErrImportRejected means at least one row failed and nothing was imported
+	ErrImportRejected = errors.New("import rejected")
+)
+
+// importColumns maps accepted column names, lowercased without spaces, dashes or
+// underscores, to the ImportRow field they fill
+var importColumns = map[string]string{
+	"order": "order", "orderref": "order", "reference": "order", "ponumber": "order", "po": "order",
+	"product": "product",
+	"color":   "color", "colour": "color",
+	"size":     "size",
+	"quantity": "quantity", "qty": "quantity",
+	"sizerun":    "sizeRun",
+	"placements": "placements", "placement": "placements",
+	"logo": "logo", "logourl": "logo", "logofile": "logo", "logofilename": "logo",
+	"aiprompt": "aiPrompt", "prompt": "aiPrompt",
+	"logocolors": "logoColors", "colors": "logoColors", "inkcolors": "logoColors",
+	"rush":     "rush",
+	"customer": "customer", "customername": "customer",
+	"company": "company",
+	"email":   "email", "customeremail": "email",
+	"phone":   "phone",
+	"address": "address", "address1": "address", "street": "address",
+	"city":  "city",
+	"state": "state",
+	"zip":   "zip", "postcode": "zip", "postalcode": "zip",
+}
+
+// ImportRow is one line of an import. Rows sharing an Order reference become one
+// order; a row without one is an order on its own.
+type ImportRow struct {
+	Row        int // 1-based data row, not counting a CSV header
+	Order      string
+	Product    string
+	Color      string
+	Size       string
+	Quantity   string
+	SizeRun    string
+	Placements string // separated by commas or semicolons
+	Logo       string // file name in uploads/, /uploads/ path, or http(s) URL
+	AIPrompt   string
+	LogoColors string
+	Rush       string // order level, taken from the order's first row
+	Customer   string
+	Company    string
+	Email      string
+	Phone      string
+	Address    string
+	City       string
+	State      string
+	Zip        string
+}
+
+// ImportRowError is a problem with one row; Field is the column at fault, if known
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Order   string `json:"order,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportResult reports what an import did, or would do in a dry run
+type ImportResult struct {
+	DryRun  bool             `json:"dryRun"`
+	Rows    int              `json:"rows"`
+	Orders  int              `json:"orders"`
+	Created []uint           `json:"created"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// ImportOptions controls an import. UploadDir is where logo files are looked up and
+// downloaded logos saved; Logos are the import's logo URLs from FetchImportLogos.
+type ImportOptions struct {
+	DryRun    bool
+	UploadDir string
+	Logos     *ImportLogos
+}
+
+// ImportLogos are an import's logo URLs, checked and downloaded ahead of its
+// transaction so the database is not locked while they download
+type ImportLogos struct {
+	paths      map[string]string // URL to the /uploads/ path it was saved as
+	errors     map[string]error  // URL to why it cannot be used
+	downloaded []string          // files saved
+}
+
+// FetchImportLogos checks every http(s) logo URL in rows and, unless it is a dry run,
+// downloads each one once into options.UploadDir. Call it before opening the import's
+// transaction and pass the result as ImportOptions.Logos; ImportOrders reports the
+// logos that failed against their rows.
+func FetchImportLogos(rows []ImportRow, options ImportOptions) *ImportLogos {
+	logos := &ImportLogos{paths: make(map[string]string), errors: make(map[string]error)}
+	for _, row := range rows {
+		value := row.Logo
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			continue
+		}
+		if _, ok := logos.paths[value]; ok {
+			continue
+		}
+		if _, ok := logos.errors[value]; ok {
+			continue
+		}
+
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Host == "" {
+			logos.errors[value] = fmt.Errorf("%q is not a valid URL", value)
+			continue
+		}
+		if err := checkLogoHost(parsed.Hostname()); err != nil {
+			logos.errors[value] = err
+			continue
+		}
+		if options.DryRun {
+			logos.paths[value] = value
+			continue
+		}
+		name, err := downloadLogo(logoClient, parsed, options.UploadDir)
+		if err != nil {
+			logos.errors[value] = err
+			continue
+		}
+		logos.downloaded = append(logos.downloaded, filepath.Join(options.UploadDir, name))
+		logos.paths[value] = "/uploads/" + name
+	}
+	return logos
+}
+
+// RemoveDownloads deletes the logos that were downloaded. Call it when the import is
+// not kept, so rejected or failed imports leave no files behind.
+func (l *ImportLogos) RemoveDownloads() {
+	if l == nil {
+		return
+	}
+	for _, file := range l.downloaded {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Could not remove downloaded logo %s: %v", file, err)
+		}
+	}
+	l.downloaded = nil
+}
+
+// ParseImportCSV reads rows from CSV with a header row naming the columns
+func ParseImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	fields := make([]string, len(header))
+	for i, name := range header {
+		if fields[i] = importColumn(name); fields[i] == "" && strings.TrimSpace(name) != "" {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidImport, name)
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+		}
+		values := make(map[string]string)
+		blank := true
+		for i, value := range record {
+			if i < len(fields) && fields[i] != "" {
+				values[fields[i]] = strings.TrimSpace(value)
+				blank = blank && values[fields[i]] == ""
+			}
+		}
+		if blank {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, MaxImportRows)
+		}
+		rows = append(rows, importRow(len(rows)+1, values))
+	}
+	return rows, nil
+}
+
+// ParseImportJSON reads rows from a JSON array of objects keyed by column name
+func ParseImportJSON(r io.Reader) ([]ImportRow, error) {
+	var objects []map[string]interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, fmt.Errorf("%w: expected a JSON array of objects: %v", ErrInvalidImport, err)
+	}
+	if len(objects) > MaxImportRows {
+		return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, MaxImportRows)
+	}
+
+	rows := make([]ImportRow, len(objects))
+	for i, object := range objects {
+		values := make(map[string]string)
+		for name, value := range object {
+			field := importColumn(name)
+			if field == "" {
+				return nil, fmt.Errorf("%w: row %d: unknown field %q", ErrInvalidImport, i+1, name)
+			}
+			switch v := value.(type) {
+			case nil:
+			case string:
+				values[field] = strings.TrimSpace(v)
+			case json.Number:
+				values[field] = v.String()
+			case []interface{}:
+				// placements may be given as a list
+				parts := make([]string, len(v))
+				for j, part := range v {
+					parts[j] = fmt.Sprint(part)
+				}
+				values[field] = strings.Join(parts, ",")
+			default:
+				values[field] = fmt.Sprint(v)
+			}
+		}
+		rows[i] = importRow(i+1, values)
+	}
+	return rows, nil
+}
+
+// ImportOrders validates every row, then creates the orders with their customers and
+// artwork. Logo URLs must have been fetched with FetchImportLogos first. If any row
+// fails nothing is kept: the result lists every error and ErrImportRejected is
+// returned so the caller rolls back tx and removes the downloaded logos. A dry run
+// validates the same way and always leaves the caller to roll back.
+func ImportOrders(tx *gorm.DB, rows []ImportRow, options ImportOptions, meta TransitionMeta) (*ImportResult, error) {
+	result := &ImportResult{DryRun: options.DryRun, Rows: len(rows), Created: []uint{}, Errors: []ImportRowError{}}
+	if len(rows) == 0 {
+		return result, fmt.Errorf("%w: no rows", ErrInvalidImport)
+	}
+
+	// Group rows into orders, keeping the order they first appear in
+	var groups [][]ImportRow
+	byRef := make(map[string]int)
+	for _, row := range rows {
+		if row.Order == "" {
+			groups = append(groups, []ImportRow{row})
+			continue
+		}
+		if i, ok := byRef[row.Order]; ok {
+			groups[i] = append(groups[i], row)
+			continue
+		}
+		byRef[row.Order] = len(groups)
+		groups = append(groups, []ImportRow{row})
+	}
+	result.Orders = len(groups)
+
+	customers := make(map[string]uint)
+	for _, group := range groups {
+		order, rowErrors := importOrder(tx, group, options, customers, meta)
+		if len(rowErrors) > 0 {
+			sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+			result.Errors = append(result.Errors, rowErrors...)
+			continue
+		}
+		if order != nil && !options.DryRun {
+			result.Created = append(result.Created, order.ID)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		result.Created = []uint{}
+		return result, ErrImportRejected
+	}
+	return result, nil
+}
+
+// importOrder creates one order from its rows, or reports why it cannot
+func importOrder(tx *gorm.DB, group []ImportRow, options ImportOptions, customers map[string]uint, meta TransitionMeta) (*models.Order, []ImportRowError) {
+	first := group[0]
+	var rowErrors []ImportRowError
+	fail := func(row ImportRow, field, format string, args ...interface{}) {
+		rowErrors = append(rowErrors, ImportRowError{Row: row.Row, Order: row.Order, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Order-level columns come from the first row; later rows may repeat them
+	for _, row := range group[1:] {
+		for _, column := range []struct{ field, first, value string }{
+			{"customer", first.Customer, row.Customer},
+			{"email", normalizeEmail(first.Email), normalizeEmail(row.Email)},
+			{"address", first.Address, row.Address},
+			{"rush", first.Rush, row.Rush},
+		} {
+			if column.value != "" && !strings.EqualFold(column.first, column.value) {
+				fail(row, column.field, "%s differs from row %d of the same order", column.field, first.Row)
+			}
+		}
+	}
+
+	input := OrderInput{Rush: first.Rush}
+	for _, row := range group {
+		line := OrderLineInput{Product: row.Product, Color: row.Color, Size: row.Size, SizeRun: row.SizeRun, AIPrompt: row.AIPrompt, UseAI: row.AIPrompt != ""}
+		if row.Product == "" {
+			fail(row, "product", "product is required")
+		}
+		var sizes []string
+		switch {
+		case row.SizeRun != "" && (row.Size != "" || row.Quantity != ""):
+			fail(row, "sizeRun", "give either size and quantity or sizeRun, not both")
+		case row.SizeRun != "":
+			run, err := ParseSizeRun(row.SizeRun)
+			if err != nil {
+				fail(row, "sizeRun", "%s", importMessage(err))
+			}
+			for _, sq := range run {
+				sizes = append(sizes, sq.Size)
+			}
+		case row.Size == "":
+			fail(row, "size", "size or sizeRun is required")
+		default:
+			sizes = []string{row.Size}
+			if row.Quantity != "" {
+				quantity, err := strconv.Atoi(row.Quantity)
+				if err != nil || quantity <= 0 {
+					fail(row, "quantity", "quantity %q must be a positive whole number", row.Quantity)
+				}
+				line.Quantity = quantity
+			}
+		}
+		for _, size := range sizes {
+			if row.Product == "" {
+				break
+			}
+			if _, err := FindInventoryItem(tx, row.Product, row.Color, size); errors.Is(err, ErrUnknownSKU) {
+				fail(row, "size", "%s is not a stocked SKU", strings.Join(strings.Fields(row.Color+" "+row.Product+" "+size), " "))
+			} else if err != nil {
+				fail(row, "", "%v", err)
+			}
+		}
+		if row.LogoColors != "" {
+			colors, err := strconv.Atoi(row.LogoColors)
+			if err != nil || colors <= 0 {
+				fail(row, "logoColors", "logoColors %q must be a positive whole number", row.LogoColors)
+			}
+			line.LogoColors = colors
+		}
+		if row.Placements != "" {
+			line.Placements = strings.FieldsFunc(row.Placements, func(r rune) bool { return r == ',' || r == ';' })
+		}
+
+		logo, err := resolveImportLogo(row.Logo, options)
+		if err != nil {
+			fail(row, "logo", "%v", err)
+		}
+		line.LogoURL = logo
+		input.Lines = append(input.Lines, line)
+	}
+	if len(rowErrors) > 0 {
+		return nil, rowErrors
+	}
+
+	if first.Customer != "" || first.Company != "" || first.Email != "" {
+		customerID, addressID, err := importCustomer(tx, first, customers)
+		if err != nil {
+			fail(first, "customer", "%v", err)
+			return nil, rowErrors
+		}
+		input.CustomerID, input.ShippingAddressID = customerID, addressID
+	} else if first.Address != "" {
+		fail(first, "customer", "an address needs a customer name, company or email")
+		return nil, rowErrors
+	}
+
+	order, err := CreateOrder(tx, input, meta)
+	if err != nil {
+		fail(first, "", "%s", importMessage(err))
+		return nil, rowErrors
+	}
+	return order, nil
+}
+
+// importCustomer finds the row's customer by email, then by name or company, creating
+// them if needed, and finds or adds the row's address to their address book
+func importCustomer(tx *gorm.DB, row ImportRow, seen map[string]uint) (uint, uint, error) {
+	email := normalizeEmail(row.Email)
+	key := email
+	if key == "" {
+		key = strings.ToLower(row.Customer + "|" + row.Company)
+	}
+
+	customerID, ok := seen[key]
+	if !ok {
+		var customer models.Customer
+		query := tx.Where("LOWER(name) = LOWER(?) AND LOWER(company) = LOWER(?)", row.Customer, row.Company)
+		if email != "" {
+			query = tx.Where("email = ?", email)
+		}
+		err := query.Order("id").First(&customer).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			created, err := CreateCustomer(tx, CustomerInput{Name: row.Customer, Company: row.Company, Email: row.Email, Phone: row.Phone})
+			if err != nil {
+				return 0, 0, err
+			}
+			customerID = created.ID
+		case err != nil:
+			return 0, 0, err
+		default:
+			customerID = customer.ID
+		}
+		seen[key] = customerID
+	}
+	if row.Address == "" {
+		return customerID, 0, nil
+	}
+
+	var address models.CustomerAddress
+	err := tx.Where("customer_id = ? AND kind = ? AND LOWER(address) = LOWER(?) AND LOWER(city) = LOWER(?) AND zip = ?",
+		customerID, models.AddressShipping, row.Address, row.City, row.Zip).First(&address).Error
+	if err == nil {
+		return customerID, address.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, err
+	}
+	added, err := AddCustomerAddress(tx, customerID, AddressInput{
+		Kind:    models.AddressShipping,
+		Name:    row.Customer,
+		Address: row.Address,
+		City:    row.City,
+		State:   row.State,
+		Zip:     row.Zip,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return customerID, added.ID, nil
+}
+
+// resolveImportLogo turns a logo cell into an /uploads/ URL. File names must already
+// be in the upload directory; http(s) URLs take the path FetchImportLogos saved them
+// at, or in a dry run are kept as they are.
+func resolveImportLogo(value string, options ImportOptions) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+		if options.Logos != nil {
+			if err, ok := options.Logos.errors[value]; ok {
+				return "", err
+			}
+			if saved, ok := options.Logos.paths[value]; ok {
+				return saved, nil
+			}
+		}
+		return "", fmt.Errorf("logo %s was not fetched before the import", value)
+	}
+
+	name := filepath.Base(strings.TrimPrefix(value, "/uploads/"))
+	if name != strings.TrimPrefix(value, "/uploads/") {
+		return "", fmt.Errorf("%q must be a file name in the uploads folder or a URL", value)
+	}
+	if _, err := os.Stat(filepath.Join(options.UploadDir, name)); err != nil {
+		return "", fmt.Errorf("logo %q has not been uploaded", name)
+	}
+	return "/uploads/" + name, nil
+}
+
+// errPrivateLogoHost keeps imports from using the server to reach its own network
+var errPrivateLogoHost = errors.New("logo URL must be on a public host")
+
+// logoClient only connects to public addresses. The check runs on the address being
+// dialled, so redirects and a host that resolves differently the second time are
+// caught too. Proxies are not used, since they would dial on the client's behalf.
+var logoClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// checkLogoHost resolves host and rejects it unless every address is public
+func checkLogoHost(host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip", host)
+	if err != nil {
+		return fmt.Errorf("logo host %s: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if addr = addr.Unmap(); !publicAddress(addr) {
+			return fmt.Errorf("%w: %s is %s", errPrivateLogoHost, host, addr)
+		}
+	}
+	return nil
+}
+
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !publicAddress(addr) {
+		return fmt.Errorf("%w: %s", errPrivateLogoHost, host)
+	}
+	return nil
+}
+
+// sharedAddressSpace is carrier-grade NAT space, private in practice though not in name
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether addr is reachable on the internet rather than a
+// loopback, private, link-local, multicast or unspecified address
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// downloadLogo saves the image at source in uploadDir and returns its file name. The
+// image is checked in full before anything is written: it must be a PNG or JPEG,
+// served as one, that decodes and is no larger than MaxLogoBytes.
+func downloadLogo(client *http.Client, source *url.URL, uploadDir string) (string, error) {
+	ext := strings.ToLower(path.Ext(source.Path))
+	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" {
+		return "", fmt.Errorf("logo URL %s must end in .png, .jpg or .jpeg", source)
+	}
+	resp, err := client.Get(source.String())
+	if err != nil {
+		return "", fmt.Errorf("downloading logo: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading logo %s: %s", source, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "image/png" && mediaType != "image/jpeg" {
+		return "", fmt.Errorf("logo %s is served as %q, not a PNG or JPEG image", source, mediaType)
+	}
+
+	// Read one byte past the limit so an oversized logo is rejected, not cut short
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxLogoBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("downloading logo: %v", err)
+	}
+	if len(data) > MaxLogoBytes {
+		return "", fmt.Errorf("logo %s is larger than %d MB", source, MaxLogoBytes>>20)
+	}
+	if _, format, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("logo %s is not a readable image: %v", source, err)
+	} else if format != "png" && format != "jpeg" {
+		return "", fmt.Errorf("logo %s is a %s image, not a PNG or JPEG", source, format)
+	}
+
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return "", err
+	}
+	base := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, path.Base(source.Path))
+	name := fmt.Sprintf("logo_%d_%s", time.Now().UnixNano(), base)
+	if err := os.WriteFile(filepath.Join(uploadDir, name), data, 0644); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// importMessage drops the "invalid order: " wrapping, which says nothing in a row error
+func importMessage(err error) string {
+	return strings.Replace(err.Error(), ErrInvalidOrder.Error()+": ", "", 1)
+}
+
+func importColumn(name string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, strings.TrimSpace(name))
+	return importColumns[normalized]
+}
+
+func importRow(number int, values map[string]string) ImportRow {
+	return ImportRow{
+		Row:        number,
+		Order:      values["order"],
+		Product:    values["product"],
+		Color:      values["color"],
+		Size:       values["size"],
+		Quantity:   values["quantity"],
+		SizeRun:    values["sizeRun"],
+		Placements: values["placements"],
+		Logo:       values["logo"],
+		AIPrompt:   values["aiPrompt"],
+		LogoColors: values["logoColors"],
+		Rush:       values["rush"],
+		Customer:   values["customer"],
+		Company:    values["company"],
+		Email:      values["email"],
+		Phone:      values["phone"],
+		Address:    values["address"],
+		City:       values["city"],
+		State:      values["state"],
+		Zip:        values["zip"],
+	}
+}
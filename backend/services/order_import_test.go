@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"printflow/models"
+)
+
+func TestImportMatchesCustomerEmailLikeCreateCustomer(t *testing.T) {
+	tx := newTestDB(t)
+	existing, err := CreateCustomer(tx, CustomerInput{Name: "Jane Doe", Email: "Jane@Example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := ImportRow{Row: 1, Customer: "Jane Doe", Email: " jane@example.COM "}
+	customerID, _, err := importCustomer(tx, row, map[string]uint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customerID != existing.ID {
+		t.Errorf("matched customer %d, want %d", customerID, existing.ID)
+	}
+	var count int64
+	tx.Model(&models.Customer{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d customers, want 1", count)
+	}
+}
+
+func TestLogoDownloadsStayOffPrivateNetworks(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("publicAddress(%s) = %v, want %v", addr, got, public)
+		}
+	}
+
+	var downloaded []string
+	for _, logo := range []string{"http://127.0.0.1/logo.png", "http://localhost:8080/logo.png", "http://169.254.169.254/logo.png"} {
+		for _, dryRun := range []bool{true, false} {
+			options := ImportOptions{DryRun: dryRun, UploadDir: t.TempDir()}
+			options.Logos = FetchImportLogos([]ImportRow{{Row: 1, Logo: logo}}, options)
+			downloaded = append(downloaded, options.Logos.downloaded...)
+			if _, err := resolveImportLogo(logo, options); !errors.Is(err, errPrivateLogoHost) {
+				t.Errorf("%s (dry run %v): got %v, want errPrivateLogoHost", logo, dryRun, err)
+			}
+		}
+	}
+
+	// The client refuses private addresses on its own, for redirects and changed DNS
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private server was reached")
+	}))
+	defer server.Close()
+	if _, err := logoClient.Get(server.URL + "/logo.png"); !errors.Is(err, errPrivateLogoHost) {
+		t.Errorf("got %v, want errPrivateLogoHost", err)
+	}
+	if len(downloaded) != 0 {
+		t.Errorf("downloaded %v", downloaded)
+	}
+}
+
+func TestRemoveDownloadsDeletesImportedLogos(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "logo_1_a.png")
+	if err := os.WriteFile(file, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logos := &ImportLogos{downloaded: []string{file, filepath.Join(dir, "missing.png")}}
+	logos.RemoveDownloads()
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s was kept: %v", file, err)
+	}
+}
+
+func TestLogoDownloadsAreCheckedBeforeSaving(t *testing.T) {
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	oversized := append(append([]byte{}, logo.Bytes()...), make([]byte, MaxLogoBytes)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.png", "/big.png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/page.png":
+			w.Header().Set("Content-Type", "text/html")
+		case "/broken.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("not an image"))
+			return
+		}
+		if r.URL.Path == "/big.png" {
+			w.Write(oversized)
+			return
+		}
+		w.Write(logo.Bytes())
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	download := func(name string) (string, error) {
+		source, err := url.Parse(server.URL + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return downloadLogo(server.Client(), source, dir)
+	}
+
+	for name, want := range map[string]string{
+		"big.png":    "larger than 10 MB",
+		"page.png":   `served as "text/html"`,
+		"broken.png": "not a readable image",
+	} {
+		if _, err := download(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an error saying %s", name, err, want)
+		}
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("rejected logos left %d files behind", len(files))
+	}
+
+	name, err := download("logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(saved, logo.Bytes()) {
+		t.Errorf("saved logo differs from the one served (%v)", err)
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+const (
+	DefaultOrderPageSize = 50
+	MaxOrderPageSize     = 200
+	DefaultOrderSort     = "-createdAt"
+)
+
+var ErrInvalidOrderQuery = errors.New("invalid order query")
+
+// orderSortColumns maps the sort keys clients may use to columns
+var orderSortColumns = map[string]string{
+	"id":          "orders.id",
+	"createdAt":   "orders.created_at",
+	"status":      "orders.status",
+	"product":     "orders.product",
+	"costOfGoods": "orders.cost_of_goods",
+	"customer":    "COALESCE(customers.name, '')", // orders without a customer sort as unnamed
+}
+
+// OrderQuery filters, sorts and pages the order list. Zero values do not filter.
+type OrderQuery struct {
+	Statuses   []string
+	Product    string // matches any line's product
+	Color      string // matches any line's color
+	CustomerID uint
+	From       *time.Time // created at or after
+	To         *time.Time // created before
+	Search     string     // every word must match the customer's name, company or email, an AI prompt, or the order number
+	Sort       string     // a key of orderSortColumns, prefixed with "-" for descending
+	Deleted    bool       // list deleted orders instead of live ones
+	Limit      int
+	Offset     int
+}
+
+// OrderPage is one page of orders with the number matching the query. StatusCounts
+// counts the matches per status ignoring the status filter, for filter tabs.
+type OrderPage struct {
+	Orders       []models.Order   `json:"orders"`
+	Total        int64            `json:"total"`
+	Limit        int              `json:"limit"`
+	Offset       int              `json:"offset"`
+	Sort         string           `json:"sort"`
+	StatusCounts map[string]int64 `json:"statusCounts"`
+}
+
+// ParseOrderDate reads a date range bound given as RFC 3339 or YYYY-MM-DD. With
+// endOfDay a plain date covers the whole day, so "to=2024-05-31" includes May 31.
+func ParseOrderDate(value string, endOfDay bool) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is not a date (use YYYY-MM-DD or RFC 3339)", ErrInvalidOrderQuery, value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// ListOrders returns the page of orders matching q, with their lines
+func ListOrders(tx *gorm.DB, q OrderQuery) (*OrderPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultOrderPageSize
+	}
+	if q.Limit > MaxOrderPageSize {
+		q.Limit = MaxOrderPageSize
+	}
+	if q.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset cannot be negative", ErrInvalidOrderQuery)
+	}
+	if q.Sort == "" {
+		q.Sort = DefaultOrderSort
+	}
+	ordering, err := orderOrdering(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	base := func() *gorm.DB { return orderScope(tx, q) }
+
+	page := OrderPage{Limit: q.Limit, Offset: q.Offset, Sort: q.Sort, StatusCounts: make(map[string]int64)}
+
+	// Count per status before applying the status filter so every tab shows its size
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	if err := base().Select("orders.status, COUNT(*) AS count").Group("orders.status").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]bool)
+	for _, s := range q.Statuses {
+		statuses[s] = true
+	}
+	for _, count := range counts {
+		page.StatusCounts[count.Status] = count.Count
+		if len(statuses) == 0 || statuses[count.Status] {
+			page.Total += count.Count
+		}
+	}
+
+	query := base()
+	if len(q.Statuses) > 0 {
+		query = query.Where("orders.status IN ?", q.Statuses)
+	}
+	err = query.Select("orders.*").
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Order(ordering).
+		Limit(q.Limit).Offset(q.Offset).
+		Find(&page.Orders).Error
+	if err != nil {
+		return nil, err
+	}
+	if page.Orders == nil {
+		page.Orders = []models.Order{}
+	}
+	return &page, nil
+}
+
+// orderScope selects the live or deleted orders matching every filter in q except
+// status, joined to their customer
+func orderScope(tx *gorm.DB, q OrderQuery) *gorm.DB {
+	query := tx.Model(&models.Order{})
+	if q.Deleted {
+		query = query.Unscoped().Where("orders.deleted_at IS NOT NULL")
+	}
+	return filterOrders(query.Joins("LEFT JOIN customers ON customers.id = orders.customer_id"), q)
+}
+
+// orderOrdering turns a sort key into an ORDER BY clause, breaking ties on id so
+// pages do not overlap
+func orderOrdering(sort string) (string, error) {
+	column, ok := orderSortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return "", fmt.Errorf("%w: cannot sort by %q", ErrInvalidOrderQuery, sort)
+	}
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+	}
+	return fmt.Sprintf("%s %s, orders.id %s", column, direction, direction), nil
+}
+
+// filterOrders applies every filter in q except status
+func filterOrders(query *gorm.DB, q OrderQuery) *gorm.DB {
+	if q.Product != "" || q.Color != "" {
+		lines := query.Session(&gorm.Session{NewDB: true}).Model(&models.OrderLine{}).
+			Select("1").Where("order_lines.order_id = orders.id")
+		if q.Product != "" {
+			lines = lines.Where("LOWER(order_lines.product) = LOWER(?)", q.Product)
+		}
+		if q.Color != "" {
+			lines = lines.Where("LOWER(order_lines.color) = LOWER(?)", q.Color)
+		}
+		query = query.Where("EXISTS (?)", lines)
+	}
+	if q.CustomerID != 0 {
+		query = query.Where("orders.customer_id = ?", q.CustomerID)
+	}
+	if q.From != nil {
+		query = query.Where("orders.created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		query = query.Where("orders.created_at < ?", *q.To)
+	}
+
+	for _, word := range strings.Fields(q.Search) {
+		like := "%" + escapeLike(word) + "%"
+		// gorm names the AIPrompt column a_iprompt
+		prompts := query.Session(&gorm.Session{NewDB: true}).Model(&models.Asset{}).
+			Select("1").Where("assets.order_id = orders.id AND assets.a_iprompt LIKE ? ESCAPE '\\'", like)
+		query = query.Where(
+			"customers.name LIKE ? ESCAPE '\\' OR customers.company LIKE ? ESCAPE '\\' OR customers.email LIKE ? ESCAPE '\\' OR EXISTS (?) OR CAST(orders.id AS TEXT) = ?",
+			like, like, like, prompts, strings.TrimPrefix(word, "#"),
+		)
+	}
+	return query
+}
+
+// escapeLike stops % and _ in search text from acting as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"printflow/models"
+)
+
+func TestListOrdersFiltersSortsAndPages(t *testing.T) {
+	tx := newTestDB(t)
+	acme := models.Customer{Name: "Ann Lee", Company: "Acme Corp", Email: "ann@acme.com"}
+	tx.Create(&acme)
+
+	// Three orders: a white one for Acme with an AI prompt, a black approved one
+	// from last month, and a black one with 100% in its prompt
+	first := createTestOrder(t, tx, models.StatusCreated, 100)
+	first.CustomerID = &acme.ID
+	tx.Save(first)
+	tx.Model(&models.OrderLine{}).Where("order_id = ?", first.ID).Update("color", "white")
+	tx.Create(&models.Asset{OrderID: first.ID, AIPrompt: "a roaring tiger"})
+
+	second := createTestOrder(t, tx, models.StatusApproved, 300)
+	tx.Model(second).Update("created_at", time.Now().AddDate(0, -1, 0))
+
+	third := createTestOrder(t, tx, models.StatusCreated, 200)
+	tx.Create(&models.Asset{OrderID: third.ID, AIPrompt: "100% cotton vibes"})
+
+	ids := func(q OrderQuery) []uint {
+		t.Helper()
+		page, err := ListOrders(tx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint
+		for _, o := range page.Orders {
+			ids = append(ids, o.ID)
+		}
+		if int(page.Total) < len(ids) {
+			t.Errorf("total %d is less than the %d orders on the page", page.Total, len(ids))
+		}
+		return ids
+	}
+	expect := func(name string, got []uint, want ...uint) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s: got orders %v, want %v", name, got, want)
+			return
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got orders %v, want %v", name, got, want)
+				return
+			}
+		}
+	}
+
+	expect("default newest first", ids(OrderQuery{}), third.ID, first.ID, second.ID)
+	expect("by id", ids(OrderQuery{Sort: "id"}), first.ID, second.ID, third.ID)
+	expect("status", ids(OrderQuery{Statuses: []string{models.StatusApproved}}), second.ID)
+	expect("color", ids(OrderQuery{Color: "WHITE"}), first.ID)
+	expect("customer", ids(OrderQuery{CustomerID: acme.ID}), first.ID)
+	since := time.Now().AddDate(0, 0, -1)
+	expect("date range", ids(OrderQuery{From: &since, Sort: "id"}), first.ID, third.ID)
+	expect("customer search", ids(OrderQuery{Search: "acme ann"}), first.ID)
+	expect("prompt search", ids(OrderQuery{Search: "tiger"}), first.ID)
+	expect("literal %", ids(OrderQuery{Search: "100%"}), third.ID)
+	expect("order number", ids(OrderQuery{Search: "#2"}), second.ID)
+	expect("second page", ids(OrderQuery{Sort: "id", Limit: 2, Offset: 2}), third.ID)
+
+	page, err := ListOrders(tx, OrderQuery{Statuses: []string{models.StatusCreated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.StatusCounts[models.StatusCreated] != 2 || page.StatusCounts[models.StatusApproved] != 1 {
+		t.Errorf("total %d with counts %v, want 2, with 2 created and 1 approved", page.Total, page.StatusCounts)
+	}
+
+	if _, err := ListOrders(tx, OrderQuery{Sort: "total"}); !errors.Is(err, ErrInvalidOrderQuery) {
+		t.Errorf("sorting by an unknown key: got %v, want ErrInvalidOrderQuery", err)
+	}
+}
@@ -0,0 +1,442 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+var ErrInvalidOrder = errors.New("invalid order")
+
+// OrderLineInput is one garment on a new order. Either Size and Quantity or a size
+// run such as "S:4, M:10, L:8, XL:2" may be given; a size run becomes one line per size.
+type OrderLineInput struct {
+	Product    string   `json:"product"`
+	Color      string   `json:"color"`
+	Size       string   `json:"size"`
+	Quantity   int      `json:"quantity"`
+	SizeRun    string   `json:"sizeRun"`
+	Placements []string `json:"placements"`
+	LogoURL    string   `json:"logoUrl"`
+	AIPrompt   string   `json:"aiPrompt"`
+	UseAI      bool     `json:"useAI"`
+	// Pricing inputs: ink colors in the logo and printed area per placement
+	LogoColors   int     `json:"logoColors"`
+	PrintAreaCm2 float64 `json:"printAreaCm2"`
+}
+
+// OrderInput describes a new order. Artwork given on the order applies to every
+// line that does not bring its own.
+type OrderInput struct {
+	Lines             []OrderLineInput `json:"lines"`
+	LocationID        uint             `json:"locationId"`
+	CustomerID        uint             `json:"customerId"`
+	ShippingAddressID uint             `json:"shippingAddressId"` // defaults to the customer's default shipping address
+	LogoURL           string           `json:"logoUrl"`
+	AIPrompt          string           `json:"aiPrompt"`
+	UseAI             bool             `json:"useAI"`
+	Rush              string           `json:"rush"`          // a rush option from the price table
+	DiscountCodes     []string         `json:"discountCodes"` // codes for discounts from the price table
+}
+
+// SizeQuantity is one size of a size run
+type SizeQuantity struct {
+	Size     string
+	Quantity int
+}
+
+// ParseSizeRun reads a size run such as "S:4, M:10, L:8, XL:2"
+func ParseSizeRun(run string) ([]SizeQuantity, error) {
+	var sizes []SizeQuantity
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(run, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		size, qty, ok := strings.Cut(part, ":")
+		size = strings.TrimSpace(size)
+		quantity, err := strconv.Atoi(strings.TrimSpace(qty))
+		if !ok || size == "" || err != nil {
+			return nil, fmt.Errorf("%w: size run entry %q should look like M:10", ErrInvalidOrder, part)
+		}
+		if quantity <= 0 {
+			return nil, fmt.Errorf("%w: size %s needs a positive quantity", ErrInvalidOrder, size)
+		}
+		if seen[strings.ToUpper(size)] {
+			return nil, fmt.Errorf("%w: size %s is listed twice", ErrInvalidOrder, size)
+		}
+		seen[strings.ToUpper(size)] = true
+		sizes = append(sizes, SizeQuantity{Size: size, Quantity: quantity})
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("%w: size run is empty", ErrInvalidOrder)
+	}
+	return sizes, nil
+}
+
+// CreateOrder validates every line against stocked SKUs, picks a fulfilling location,
+// and saves the order with its lines and one asset per product and color
+func CreateOrder(tx *gorm.DB, input OrderInput, meta TransitionMeta) (*models.Order, error) {
+	plan, err := planOrder(tx, input)
+	if err != nil {
+		return nil, err
+	}
+	price, err := priceNewOrder(tx, input, plan.lines)
+	if err != nil {
+		return nil, err
+	}
+	return createPlannedOrder(tx, input, plan, price, nil, meta)
+}
+
+// priceNewOrder prices the lines of a new order or quote at the customer's price list,
+// rejecting discount codes that do not apply
+func priceNewOrder(tx *gorm.DB, input OrderInput, lines []models.OrderLine) (*Price, error) {
+	options := PriceOptions{Rush: input.Rush, DiscountCodes: input.DiscountCodes}
+	if input.CustomerID != 0 {
+		customer, err := GetCustomer(tx, input.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		options.PriceList = customer.PriceList
+	}
+	price, err := PriceOrderLines(lines, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDiscountCodes(price); err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+type orderArtwork struct {
+	logoURL, aiPrompt string
+	useAI             bool
+}
+
+// orderPlan is a validated new order: its lines, one artwork per product and color,
+// and the workflow it will follow
+type orderPlan struct {
+	lines       []models.OrderLine
+	designs     map[string]orderArtwork
+	designOrder []string
+	workflow    *WorkflowDefinition
+}
+
+// planOrder expands size runs into lines and checks every SKU, design and workflow
+// without saving anything
+func planOrder(tx *gorm.DB, input OrderInput) (*orderPlan, error) {
+	if len(input.Lines) == 0 {
+		return nil, fmt.Errorf("%w: an order needs at least one line", ErrInvalidOrder)
+	}
+
+	var lines []models.OrderLine
+	designs := make(map[string]orderArtwork)
+	var designOrder []string
+	for i, in := range input.Lines {
+		sizes := []SizeQuantity{{Size: in.Size, Quantity: in.Quantity}}
+		if in.SizeRun != "" {
+			var err error
+			if sizes, err = ParseSizeRun(in.SizeRun); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		} else if in.Quantity == 0 {
+			sizes[0].Quantity = 1
+		} else if in.Quantity < 0 {
+			return nil, fmt.Errorf("%w: line %d quantity must be positive", ErrInvalidOrder, i+1)
+		}
+
+		placements, err := normalizePlacements(in.Placements)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		if in.LogoColors < 0 || in.PrintAreaCm2 < 0 {
+			return nil, fmt.Errorf("%w: line %d logo colors and print area cannot be negative", ErrInvalidOrder, i+1)
+		}
+
+		art := orderArtwork{in.LogoURL, in.AIPrompt, in.UseAI}
+		if art.logoURL == "" && art.aiPrompt == "" {
+			art = orderArtwork{input.LogoURL, input.AIPrompt, input.UseAI}
+		}
+
+		for _, sq := range sizes {
+			item, err := FindInventoryItem(tx, in.Product, in.Color, sq.Size)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+
+			key := designKey(item.Product, item.Color)
+			if existing, ok := designs[key]; !ok {
+				designs[key] = art
+				designOrder = append(designOrder, key)
+			} else if existing != art {
+				return nil, fmt.Errorf("%w: %s %s lines must share one design", ErrInvalidOrder, item.Color, item.Product)
+			}
+
+			lines = append(lines, models.OrderLine{
+				InventoryItemID: item.ID,
+				Product:         item.Product,
+				Color:           item.Color,
+				Size:            item.Size,
+				Quantity:        sq.Quantity,
+				Placements:      placements,
+				LogoColors:      in.LogoColors,
+				PrintAreaCm2:    in.PrintAreaCm2,
+			})
+		}
+	}
+
+	// One order follows one workflow, so every garment must share it
+	workflow, err := WorkflowFor(lines[0].Product)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines[1:] {
+		other, err := WorkflowFor(line.Product)
+		if err != nil {
+			return nil, err
+		}
+		if other.Name != workflow.Name {
+			return nil, fmt.Errorf("%w: %s (%s) and %s (%s) follow different workflows; place separate orders",
+				ErrInvalidOrder, lines[0].Product, workflow.Name, line.Product, other.Name)
+		}
+	}
+	return &orderPlan{lines: lines, designs: designs, designOrder: designOrder, workflow: workflow}, nil
+}
+
+// createPlannedOrder saves a planned order at price. quote is the quote being
+// converted, if any.
+func createPlannedOrder(tx *gorm.DB, input OrderInput, plan *orderPlan, price *Price, quote *models.Quote, meta TransitionMeta) (*models.Order, error) {
+	lines := plan.lines
+	if len(price.Lines) != len(lines) {
+		return nil, fmt.Errorf("%w: the quote no longer matches the order's lines", ErrInvalidOrder)
+	}
+
+	var err error
+	var customerID, shippingAddressID *uint
+	if input.CustomerID != 0 {
+		if _, err := GetCustomer(tx, input.CustomerID); err != nil {
+			return nil, err
+		}
+		address, err := ResolveShippingAddress(tx, input.CustomerID, input.ShippingAddressID)
+		if err != nil {
+			return nil, err
+		}
+		customerID = &input.CustomerID
+		if address != nil {
+			shippingAddressID = &address.ID
+		}
+	} else if input.ShippingAddressID != 0 {
+		return nil, fmt.Errorf("%w: a shipping address needs a customer", ErrInvalidOrder)
+	}
+
+	location, err := orderLocation(tx, input, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	order := models.Order{
+		Product:           lines[0].Product,
+		Color:             lines[0].Color,
+		Size:              lines[0].Size,
+		Status:            plan.workflow.Initial,
+		LocationID:        location.ID,
+		CustomerID:        customerID,
+		ShippingAddressID: shippingAddressID,
+		PricedAt:          &now,
+		PaymentStatus:     models.PaymentUnpaid,
+		Lines:             lines,
+	}
+	applyPrice(&order, order.Lines, price)
+	if err := taxOrder(tx, &order, order.Lines); err != nil {
+		return nil, err
+	}
+	if quote != nil {
+		order.QuoteID = &quote.ID
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+
+	// Store the artwork per garment; mockups are generated later
+	assetIDs := make(map[string]uint)
+	for _, key := range plan.designOrder {
+		art := plan.designs[key]
+		product, color, _ := strings.Cut(key, "|")
+		asset := models.Asset{
+			OrderID:     order.ID,
+			Product:     product,
+			Color:       color,
+			LogoURL:     art.logoURL,
+			AIGenerated: art.useAI,
+			AIPrompt:    art.aiPrompt,
+		}
+		if err := tx.Create(&asset).Error; err != nil {
+			return nil, err
+		}
+		assetIDs[key] = asset.ID
+	}
+	for i := range order.Lines {
+		assetID := assetIDs[designKey(order.Lines[i].Product, order.Lines[i].Color)]
+		order.Lines[i].AssetID = &assetID
+		if err := tx.Model(&order.Lines[i]).Update("asset_id", assetID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := RecordOrderEvent(tx, &order, "", meta); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// orderLocation is the location the input asks for, or the one chosen for its lines
+func orderLocation(tx *gorm.DB, input OrderInput, lines []models.OrderLine) (*models.Location, error) {
+	if input.LocationID != 0 {
+		return resolveLocation(tx, input.LocationID)
+	}
+	return ChooseOrderLocation(tx, lines)
+}
+
+// LoadOrderLines returns an order's lines, loading them if the order came without them
+func LoadOrderLines(tx *gorm.DB, order *models.Order) ([]models.OrderLine, error) {
+	if len(order.Lines) > 0 {
+		return order.Lines, nil
+	}
+	var lines []models.OrderLine
+	if err := tx.Where("order_id = ?", order.ID).Order("id").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// OrderAssets returns an order's artwork, one asset per product and color
+func OrderAssets(tx *gorm.DB, orderID uint) ([]models.Asset, error) {
+	var assets []models.Asset
+	err := tx.Where("order_id = ?", orderID).Order("id").Find(&assets).Error
+	return assets, err
+}
+
+// ChooseOrderLocation picks the highest-priority active location with every line
+// available, falling back to the default location when none has them all
+func ChooseOrderLocation(tx *gorm.DB, lines []models.OrderLine) (*models.Location, error) {
+	needed := make(map[uint]int)
+	for _, line := range lines {
+		needed[line.InventoryItemID] += line.Quantity
+	}
+	var locations []models.Location
+	if err := tx.Where("active = ?", true).Order("priority, id").Find(&locations).Error; err != nil {
+		return nil, err
+	}
+	for i := range locations {
+		hasAll := true
+		for itemID, quantity := range needed {
+			stock, err := locationStock(tx, itemID, locations[i].ID)
+			if err != nil {
+				return nil, err
+			}
+			if stock.Available < quantity {
+				hasAll = false
+				break
+			}
+		}
+		if hasAll {
+			return &locations[i], nil
+		}
+	}
+	return DefaultLocation(tx)
+}
+
+// EnsureOrderLines gives every order saved before line items existed a single line
+// for its product, color and size, attached to its existing asset
+func EnsureOrderLines(tx *gorm.DB) error {
+	var orders []models.Order
+	err := tx.Where("id NOT IN (?)", tx.Model(&models.OrderLine{}).Select("order_id")).Find(&orders).Error
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		line := models.OrderLine{
+			OrderID:    order.ID,
+			Product:    order.Product,
+			Color:      order.Color,
+			Size:       order.Size,
+			Quantity:   1,
+			Placements: DefaultPlacement,
+		}
+		if item, err := FindInventoryItem(tx, order.Product, order.Color, order.Size); err == nil {
+			line.InventoryItemID = item.ID
+		}
+
+		var asset models.Asset
+		if err := tx.Where("order_id = ?", order.ID).First(&asset).Error; err == nil {
+			line.AssetID = &asset.ID
+			err := tx.Model(&asset).Updates(map[string]interface{}{
+				"product": order.Product,
+				"color":   order.Color,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&line).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LineSummary describes an order's lines for labels and packing slips, e.g.
+// "10 x T-Shirt Black M"
+func LineSummary(lines []models.OrderLine) []string {
+	sorted := append([]models.OrderLine{}, lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return designKey(sorted[i].Product, sorted[i].Color) < designKey(sorted[j].Product, sorted[j].Color)
+	})
+	summary := make([]string, len(sorted))
+	for i, line := range sorted {
+		summary[i] = fmt.Sprintf("%d x %s %s %s", line.Quantity, line.Product, line.Color, line.Size)
+	}
+	return summary
+}
+
+// linePlacements splits a line's stored placements
+func linePlacements(line models.OrderLine) []string {
+	if line.Placements == "" {
+		return []string{DefaultPlacement}
+	}
+	return strings.Split(line.Placements, ",")
+}
+
+func normalizePlacements(placements []string) (string, error) {
+	if len(placements) == 0 {
+		return DefaultPlacement, nil
+	}
+	seen := make(map[string]bool)
+	var cleaned []string
+	for _, p := range placements {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" || strings.Contains(p, ",") {
+			return "", fmt.Errorf("%w: invalid placement %q", ErrInvalidOrder, p)
+		}
+		if !seen[p] {
+			seen[p] = true
+			cleaned = append(cleaned, p)
+		}
+	}
+	return strings.Join(cleaned, ","), nil
+}
+
+func designKey(product, color string) string {
+	return product + "|" + color
+}
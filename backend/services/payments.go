@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// PaymentInput records money received for an order, or refunded with kind "refund"
+type PaymentInput struct {
+	Kind      string  `json:"kind"` // "payment" (default) or "refund"
+	Amount    float64 `json:"amount"`
+	Method    string  `json:"method"`
+	Reference string  `json:"reference"`
+	Note      string  `json:"note"`
+}
+
+// PaymentSummary is where an order's payments stand
+type PaymentSummary struct {
+	Status          string           `json:"status"`
+	Total           float64          `json:"total"`
+	Paid            float64          `json:"paid"`     // payments received
+	Refunded        float64          `json:"refunded"` // refunds given
+	AmountPaid      float64          `json:"amountPaid"`
+	BalanceDue      float64          `json:"balanceDue"`
+	DepositRequired float64          `json:"depositRequired"` // from the customer's deposit percent
+	DepositDue      float64          `json:"depositDue"`      // still to collect before approval
+	Payments        []models.Payment `json:"payments"`
+}
+
+// RecordPayment adds a payment or refund to an order and updates its payment status.
+// Payments cannot exceed the balance due and refunds cannot exceed what was paid.
+func RecordPayment(tx *gorm.DB, order *models.Order, input PaymentInput, actor string) (*models.Payment, error) {
+	kind := strings.ToUpper(strings.TrimSpace(input.Kind))
+	if kind == "" {
+		kind = models.PaymentKindPayment
+	}
+	if kind != models.PaymentKindPayment && kind != models.PaymentKindRefund {
+		return nil, fmt.Errorf("%w: kind must be payment or refund", ErrInvalidPayment)
+	}
+	amount := roundCents(input.Amount)
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidPayment)
+	}
+
+	switch kind {
+	case models.PaymentKindPayment:
+		if order.Status == models.StatusCancelled {
+			return nil, fmt.Errorf("%w: order %d is cancelled", ErrInvalidPayment, order.ID)
+		}
+		if due := roundCents(order.Total - order.AmountPaid); amount > due {
+			return nil, fmt.Errorf("%w: %.2f is more than the %.2f due", ErrInvalidPayment, amount, due)
+		}
+	case models.PaymentKindRefund:
+		if amount > roundCents(order.AmountPaid) {
+			return nil, fmt.Errorf("%w: %.2f is more than the %.2f paid", ErrInvalidPayment, amount, order.AmountPaid)
+		}
+	}
+
+	payment := models.Payment{
+		OrderID:   order.ID,
+		Kind:      kind,
+		Amount:    amount,
+		Method:    strings.TrimSpace(input.Method),
+		Reference: strings.TrimSpace(input.Reference),
+		Note:      input.Note,
+		Actor:     actor,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return nil, err
+	}
+
+	payments, err := OrderPayments(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	paid, refunded := paymentTotals(payments)
+	order.AmountPaid = roundCents(paid - refunded)
+	order.PaymentStatus = paymentStatus(order.Total, order.AmountPaid, refunded > 0)
+	if err := SaveOrder(tx, order); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// OrderPayments returns an order's payments and refunds, oldest first
+func OrderPayments(tx *gorm.DB, orderID uint) ([]models.Payment, error) {
+	payments := []models.Payment{}
+	if err := tx.Where("order_id = ?", orderID).Order("id").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// OrderPaymentSummary totals an order's payments against its total and deposit
+func OrderPaymentSummary(tx *gorm.DB, order *models.Order) (*PaymentSummary, error) {
+	payments, err := OrderPayments(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	deposit, err := RequiredDeposit(tx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &PaymentSummary{
+		Status:          order.PaymentStatus,
+		Total:           order.Total,
+		AmountPaid:      order.AmountPaid,
+		BalanceDue:      roundCents(order.Total - order.AmountPaid),
+		DepositRequired: deposit,
+		Payments:        payments,
+	}
+	summary.Paid, summary.Refunded = paymentTotals(payments)
+	if due := roundCents(deposit - order.AmountPaid); due > 0 {
+		summary.DepositDue = due
+	}
+	return summary, nil
+}
+
+// RequiredDeposit is the customer's deposit percent of the order's total, or 0 for
+// orders without a customer
+func RequiredDeposit(tx *gorm.DB, order *models.Order) (float64, error) {
+	if order.CustomerID == nil {
+		return 0, nil
+	}
+	var customer models.Customer
+	err := tx.First(&customer, *order.CustomerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return roundCents(order.Total * customer.DepositPercent / 100), nil
+}
+
+// depositReceived blocks a transition until the customer's deposit has been paid
+func depositReceived(tx *gorm.DB, order *models.Order) error {
+	deposit, err := RequiredDeposit(tx, order)
+	if err != nil {
+		return err
+	}
+	if roundCents(order.AmountPaid) < deposit {
+		return fmt.Errorf("a deposit of %.2f is required and %.2f has been paid", deposit, order.AmountPaid)
+	}
+	return nil
+}
+
+// paymentTotals adds up payments received and refunds given
+func paymentTotals(payments []models.Payment) (paid, refunded float64) {
+	for _, p := range payments {
+		if p.Kind == models.PaymentKindRefund {
+			refunded += p.Amount
+		} else {
+			paid += p.Amount
+		}
+	}
+	return roundCents(paid), roundCents(refunded)
+}
+
+// paymentStatus works out the status of an order with total that has net paid to it.
+// An order that was refunded down to nothing stays REFUNDED rather than UNPAID.
+func paymentStatus(total, net float64, refunded bool) string {
+	switch {
+	case net <= 0 && refunded:
+		return models.PaymentRefunded
+	case net <= 0:
+		return models.PaymentUnpaid
+	case net >= roundCents(total):
+		return models.PaymentPaid
+	default:
+		return models.PaymentPartial
+	}
+}
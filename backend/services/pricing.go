@@ -0,0 +1,408 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"printflow/models"
+)
+
+// DefaultPricingFile is read at startup when PRICING_FILE is not set
+const DefaultPricingFile = "pricing.json"
+
+var ErrPricingNotLoaded = errors.New("price table has not been loaded")
+
+// ProductPrice is the price of a blank garment. The entry without a product applies
+// to every product not listed.
+type ProductPrice struct {
+	Product   string  `json:"product"`
+	BasePrice float64 `json:"basePrice"`
+}
+
+// ColorPricing charges for logo colors beyond those included in a placement's price
+type ColorPricing struct {
+	Included      int     `json:"included"`
+	PerExtraColor float64 `json:"perExtraColor"`
+}
+
+// AreaPricing charges for printed area beyond IncludedCm2 per placement
+type AreaPricing struct {
+	IncludedCm2 float64 `json:"includedCm2"`
+	PerCm2      float64 `json:"perCm2"`
+}
+
+// QuantityBreak takes PercentOff the unit price of orders of at least MinQuantity garments
+type QuantityBreak struct {
+	MinQuantity int     `json:"minQuantity"`
+	PercentOff  float64 `json:"percentOff"`
+}
+
+// RushFee is added to the subtotal of a rushed order: Percent of it plus Flat
+type RushFee struct {
+	Percent float64 `json:"percent"`
+	Flat    float64 `json:"flat"`
+}
+
+// PriceList gives the customers assigned to it their own base and placement prices.
+// Products and placements it does not list keep the table's prices.
+type PriceList struct {
+	Name       string             `json:"name"`
+	Products   []ProductPrice     `json:"products"`
+	Placements map[string]float64 `json:"placements"`
+}
+
+// PriceTable is the contents of the pricing file. Prices are per garment except rush fees.
+type PriceTable struct {
+	Currency         string             `json:"currency"`
+	Products         []ProductPrice     `json:"products"`
+	SizeUpcharges    map[string]float64 `json:"sizeUpcharges"`
+	Placements       map[string]float64 `json:"placements"`
+	DefaultPlacement float64            `json:"defaultPlacement"` // for placements not listed
+	Colors           ColorPricing       `json:"colors"`
+	PrintArea        AreaPricing        `json:"printArea"`
+	QuantityBreaks   []QuantityBreak    `json:"quantityBreaks"`
+	Rush             map[string]RushFee `json:"rush"`
+	QuoteValidDays   int                `json:"quoteValidDays"`
+	PriceLists       []PriceList        `json:"priceLists"`
+	Discounts        []DiscountRule     `json:"discounts"`
+}
+
+// LinePrice breaks down the unit price of one order line
+type LinePrice struct {
+	BasePrice       float64 `json:"basePrice"`
+	SizeUpcharge    float64 `json:"sizeUpcharge"`
+	DecorationPrice float64 `json:"decorationPrice"`
+	QuantityBreak   float64 `json:"quantityBreak"`
+	UnitPrice       float64 `json:"unitPrice"`
+	LineTotal       float64 `json:"lineTotal"`
+	Discount        float64 `json:"discount"` // the line's share of the order's discounts
+}
+
+// Price is what a set of order lines costs the customer
+type Price struct {
+	Currency             string            `json:"currency"`
+	Lines                []LinePrice       `json:"lines"`
+	Quantity             int               `json:"quantity"`
+	QuantityBreakPercent float64           `json:"quantityBreakPercent"`
+	Subtotal             float64           `json:"subtotal"`
+	PriceList            string            `json:"priceList,omitempty"`
+	Discounts            []AppliedDiscount `json:"discounts"`
+	DiscountTotal        float64           `json:"discountTotal"`
+	Rush                 string            `json:"rush"`
+	RushFee              float64           `json:"rushFee"`
+	Total                float64           `json:"total"`
+
+	// unapplied explains each discount code given that did not apply
+	unapplied []string
+}
+
+// PriceOptions are the order-wide inputs to pricing besides its lines
+type PriceOptions struct {
+	Rush          string    // a rush option from the table
+	PriceList     string    // the customer's price list; empty for the table's prices
+	DiscountCodes []string  // codes unlocking discounts that have one
+	Date          time.Time // decides which dated discounts apply; zero for today
+}
+
+var priceTable *PriceTable
+
+// PricingFile returns the pricing file path from PRICING_FILE, or the default
+func PricingFile() string {
+	if path := os.Getenv("PRICING_FILE"); path != "" {
+		return path
+	}
+	return DefaultPricingFile
+}
+
+// LoadPricing reads, validates and installs the price table in path
+func LoadPricing(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading pricing file: %w", err)
+	}
+	defer file.Close()
+
+	var table PriceTable
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&table); err != nil {
+		return fmt.Errorf("parsing pricing file %s: %w", path, err)
+	}
+	if err := validatePriceTable(&table); err != nil {
+		return fmt.Errorf("invalid pricing file %s: %w", path, err)
+	}
+	priceTable = &table
+	return nil
+}
+
+// CurrentPriceTable returns the loaded price table
+func CurrentPriceTable() (*PriceTable, error) {
+	if priceTable == nil {
+		return nil, ErrPricingNotLoaded
+	}
+	return priceTable, nil
+}
+
+// validatePriceTable checks table and normalises it in place: quantity breaks are
+// sorted, rush options are keyed in lower case and QuoteValidDays defaults to 30
+func validatePriceTable(table *PriceTable) error {
+	if table.Currency == "" {
+		return errors.New("currency is required")
+	}
+	products := make(map[string]bool)
+	hasDefault := false
+	for _, product := range table.Products {
+		key := strings.ToLower(product.Product)
+		if products[key] {
+			return fmt.Errorf("product %q is priced twice", product.Product)
+		}
+		products[key] = true
+		hasDefault = hasDefault || key == ""
+		if product.BasePrice < 0 {
+			return fmt.Errorf("product %q has a negative base price", product.Product)
+		}
+	}
+	if !hasDefault {
+		return errors.New("products needs an entry without a product for everything not listed")
+	}
+
+	for name, prices := range map[string]map[string]float64{"sizeUpcharges": table.SizeUpcharges, "placements": table.Placements} {
+		for key, price := range prices {
+			if price < 0 {
+				return fmt.Errorf("%s %q is negative", name, key)
+			}
+		}
+	}
+	if table.DefaultPlacement < 0 || table.Colors.Included < 0 || table.Colors.PerExtraColor < 0 ||
+		table.PrintArea.IncludedCm2 < 0 || table.PrintArea.PerCm2 < 0 {
+		return errors.New("prices cannot be negative")
+	}
+
+	sort.Slice(table.QuantityBreaks, func(i, j int) bool {
+		return table.QuantityBreaks[i].MinQuantity < table.QuantityBreaks[j].MinQuantity
+	})
+	for i, b := range table.QuantityBreaks {
+		if b.MinQuantity <= 0 || b.PercentOff < 0 || b.PercentOff >= 100 {
+			return fmt.Errorf("quantity break for %d needs a positive quantity and a percent off below 100", b.MinQuantity)
+		}
+		if i > 0 && table.QuantityBreaks[i-1].MinQuantity == b.MinQuantity {
+			return fmt.Errorf("two quantity breaks start at %d", b.MinQuantity)
+		}
+	}
+	// Rush options are looked up by their lower-case name
+	rush := make(map[string]RushFee, len(table.Rush))
+	for name, fee := range table.Rush {
+		if name == "" || fee.Percent < 0 || fee.Flat < 0 {
+			return fmt.Errorf("rush option %q needs a name and non-negative fees", name)
+		}
+		key := strings.ToLower(name)
+		if _, ok := rush[key]; ok {
+			return fmt.Errorf("rush option %q is listed twice", key)
+		}
+		rush[key] = fee
+	}
+	table.Rush = rush
+	if table.QuoteValidDays <= 0 {
+		table.QuoteValidDays = 30
+	}
+
+	lists := make(map[string]bool)
+	for _, list := range table.PriceLists {
+		key := strings.ToLower(list.Name)
+		if key == "" || lists[key] {
+			return fmt.Errorf("price list %q needs a unique name", list.Name)
+		}
+		lists[key] = true
+		for _, product := range list.Products {
+			if product.BasePrice < 0 {
+				return fmt.Errorf("price list %q has a negative base price for %q", list.Name, product.Product)
+			}
+		}
+		for placement, price := range list.Placements {
+			if price < 0 {
+				return fmt.Errorf("price list %q has a negative price for placement %q", list.Name, placement)
+			}
+		}
+	}
+	return validateDiscounts(table.Discounts, lists)
+}
+
+// HasPriceList reports whether the loaded table has a price list named name
+func HasPriceList(name string) bool {
+	table, err := CurrentPriceTable()
+	if err != nil {
+		return false
+	}
+	return table.priceList(name) != nil
+}
+
+// PriceOrderLines prices lines with the loaded price table. Quantity breaks apply to
+// the garments across every line; discounts come off the subtotal before the rush fee.
+func PriceOrderLines(lines []models.OrderLine, options PriceOptions) (*Price, error) {
+	table, err := CurrentPriceTable()
+	if err != nil {
+		return nil, err
+	}
+	var fee RushFee
+	if options.Rush != "" {
+		var ok bool
+		if fee, ok = table.Rush[strings.ToLower(options.Rush)]; !ok {
+			return nil, fmt.Errorf("%w: unknown rush option %q", ErrInvalidOrder, options.Rush)
+		}
+	}
+	var list *PriceList
+	if options.PriceList != "" {
+		if list = table.priceList(options.PriceList); list == nil {
+			return nil, fmt.Errorf("%w: price list %q is not in the price table", ErrInvalidOrder, options.PriceList)
+		}
+	}
+
+	price := &Price{Currency: table.Currency, Lines: make([]LinePrice, len(lines)), Rush: strings.ToLower(options.Rush), Discounts: []AppliedDiscount{}}
+	if list != nil {
+		price.PriceList = list.Name
+	}
+	for _, line := range lines {
+		price.Quantity += line.Quantity
+	}
+	for _, b := range table.QuantityBreaks {
+		if price.Quantity >= b.MinQuantity {
+			price.QuantityBreakPercent = b.PercentOff
+		}
+	}
+
+	for i, line := range lines {
+		lp := LinePrice{
+			BasePrice:    table.basePrice(line.Product, list),
+			SizeUpcharge: lookupPrice(table.SizeUpcharges, line.Size),
+		}
+		colors := line.LogoColors
+		if colors < 1 {
+			colors = 1
+		}
+		for _, placement := range linePlacements(line) {
+			lp.DecorationPrice += table.placementPrice(placement, list)
+			if extra := colors - table.Colors.Included; extra > 0 {
+				lp.DecorationPrice += float64(extra) * table.Colors.PerExtraColor
+			}
+			if extra := line.PrintAreaCm2 - table.PrintArea.IncludedCm2; extra > 0 {
+				lp.DecorationPrice += extra * table.PrintArea.PerCm2
+			}
+		}
+		lp.DecorationPrice = roundCents(lp.DecorationPrice)
+
+		full := lp.BasePrice + lp.SizeUpcharge + lp.DecorationPrice
+		lp.QuantityBreak = roundCents(full * price.QuantityBreakPercent / 100)
+		lp.UnitPrice = roundCents(full - lp.QuantityBreak)
+		lp.LineTotal = roundCents(lp.UnitPrice * float64(line.Quantity))
+		price.Lines[i] = lp
+		price.Subtotal += lp.LineTotal
+	}
+	price.Subtotal = roundCents(price.Subtotal)
+
+	if err := applyDiscounts(table, list, lines, price, options); err != nil {
+		return nil, err
+	}
+	discounted := price.Subtotal - price.DiscountTotal
+	price.RushFee = roundCents(discounted*fee.Percent/100 + fee.Flat)
+	price.Total = roundCents(discounted + price.RushFee)
+	return price, nil
+}
+
+// checkDiscountCodes rejects a price where a discount code the customer gave did not apply
+func checkDiscountCodes(price *Price) error {
+	if len(price.unapplied) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidOrder, strings.Join(price.unapplied, "; "))
+	}
+	return nil
+}
+
+// applyPrice locks price onto an order and its lines
+func applyPrice(order *models.Order, lines []models.OrderLine, price *Price) {
+	for i := range lines {
+		lines[i].UnitPrice = price.Lines[i].UnitPrice
+		lines[i].LineTotal = price.Lines[i].LineTotal
+		lines[i].Discount = price.Lines[i].Discount
+	}
+	order.Rush = price.Rush
+	order.PriceList = price.PriceList
+	order.Subtotal = price.Subtotal
+	order.DiscountTotal = price.DiscountTotal
+	order.RushFee = price.RushFee
+	order.Total = price.Total
+	order.Discounts = make([]models.OrderDiscount, len(price.Discounts))
+	for i, d := range price.Discounts {
+		order.Discounts[i] = models.OrderDiscount{Name: d.Name, Code: d.Code, Type: d.Type, Amount: d.Amount}
+	}
+}
+
+// priceList finds a price list by case-insensitive name
+func (t *PriceTable) priceList(name string) *PriceList {
+	for i := range t.PriceLists {
+		if strings.EqualFold(t.PriceLists[i].Name, name) {
+			return &t.PriceLists[i]
+		}
+	}
+	return nil
+}
+
+// placementPrice is the price of one placement, from the price list when it has one
+func (t *PriceTable) placementPrice(placement string, list *PriceList) float64 {
+	if list != nil {
+		if price, ok := lookupPriceOK(list.Placements, placement); ok {
+			return price
+		}
+	}
+	if price, ok := lookupPriceOK(t.Placements, placement); ok {
+		return price
+	}
+	return t.DefaultPlacement
+}
+
+// basePrice is the price of a blank, from the price list when it names the product
+func (t *PriceTable) basePrice(product string, list *PriceList) float64 {
+	if list != nil {
+		for _, p := range list.Products {
+			if strings.EqualFold(p.Product, product) {
+				return p.BasePrice
+			}
+		}
+	}
+	var fallback float64
+	for _, p := range t.Products {
+		if strings.EqualFold(p.Product, product) {
+			return p.BasePrice
+		}
+		if p.Product == "" {
+			fallback = p.BasePrice
+		}
+	}
+	return fallback
+}
+
+// lookupPrice finds a price by case-insensitive key, or 0
+func lookupPrice(prices map[string]float64, key string) float64 {
+	price, _ := lookupPriceOK(prices, key)
+	return price
+}
+
+func lookupPriceOK(prices map[string]float64, key string) (float64, bool) {
+	if price, ok := prices[key]; ok {
+		return price, true
+	}
+	for k, price := range prices {
+		if strings.EqualFold(k, key) {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"printflow/models"
+)
+
+func TestPriceOrderLines(t *testing.T) {
+	lines := []models.OrderLine{
+		{Product: "T-Shirt", Size: "M", Quantity: 10, Placements: "front"},
+		{Product: "Hoodie", Size: "xxl", Quantity: 40, Placements: "front,back", LogoColors: 3, PrintAreaCm2: 700},
+	}
+	price, err := PriceOrderLines(lines, PriceOptions{Rush: "Express"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 50 garments earn the 10% break. The hoodie pays its size upcharge, and per
+	// placement two extra colors and 100 cm² beyond the included area.
+	want := []LinePrice{
+		{BasePrice: 8, DecorationPrice: 5, QuantityBreak: 1.3, UnitPrice: 11.7, LineTotal: 117},
+		{BasePrice: 22, SizeUpcharge: 2, DecorationPrice: 14, QuantityBreak: 3.8, UnitPrice: 34.2, LineTotal: 1368},
+	}
+	for i, lp := range price.Lines {
+		if lp != want[i] {
+			t.Errorf("line %d priced %+v, want %+v", i, lp, want[i])
+		}
+	}
+	if price.Quantity != 50 || price.QuantityBreakPercent != 10 || price.Subtotal != 1485 ||
+		price.Rush != "express" || price.RushFee != 767.5 || price.Total != 2252.5 {
+		t.Errorf("got %+v, want a 1485 subtotal, a 767.50 express fee and a 2252.50 total", price)
+	}
+
+	// The price list replaces the prices it lists and keeps the table's for the rest
+	price, err = PriceOrderLines([]models.OrderLine{{Product: "T-Shirt", Size: "M", Quantity: 1, Placements: "front,sleeve"}}, PriceOptions{PriceList: "Wholesale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price.PriceList != "wholesale" || price.Lines[0].UnitPrice != 13 || price.Total != 13 {
+		t.Errorf("wholesale shirt with front and sleeve prints priced %+v, want 6.50 + 4.00 + 2.50", price)
+	}
+
+	if _, err := PriceOrderLines(lines, PriceOptions{Rush: "overnight"}); err == nil {
+		t.Error("an unknown rush option was priced")
+	}
+}
+
+func TestPriceTableRushOptionsIgnoreCase(t *testing.T) {
+	table := func(rush map[string]RushFee) *PriceTable {
+		return &PriceTable{Currency: "USD", Products: []ProductPrice{{BasePrice: 10}}, Rush: rush}
+	}
+
+	valid := table(map[string]RushFee{"Express": {Percent: 50}})
+	if err := validatePriceTable(valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := valid.Rush["express"]; !ok || len(valid.Rush) != 1 {
+		t.Errorf("rush options %v, want express keyed in lower case", valid.Rush)
+	}
+
+	duplicated := table(map[string]RushFee{"Express": {Percent: 50}, "express": {Percent: 40}})
+	if err := validatePriceTable(duplicated); err == nil || !strings.Contains(err.Error(), "listed twice") {
+		t.Errorf("got %v, want rush options differing only by case rejected", err)
+	}
+}
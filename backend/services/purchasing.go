@@ -0,0 +1,250 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"printflow/models"
+)
+
+var (
+	ErrInvalidPurchaseOrderTransition = errors.New("invalid purchase order status transition")
+	ErrEmptyPurchaseOrder             = errors.New("purchase order needs at least one line")
+	ErrOverReceipt                    = errors.New("received quantity exceeds quantity outstanding")
+)
+
+// PurchaseOrderLineInput is a requested line on a new purchase order
+type PurchaseOrderLineInput struct {
+	InventoryItemID uint    `json:"inventoryItemId"`
+	Quantity        int     `json:"quantity"`
+	UnitCost        float64 `json:"unitCost"`
+}
+
+// ReceiptInput records the quantity received against one purchase order line
+type ReceiptInput struct {
+	LineID   uint `json:"lineId"`
+	Quantity int  `json:"quantity"`
+}
+
+// SuggestedPurchaseOrders is the result of turning the low-stock report into draft POs
+type SuggestedPurchaseOrders struct {
+	PurchaseOrders []models.PurchaseOrder `json:"purchaseOrders"`
+	Unassigned     []LowStockLine         `json:"unassigned"`
+}
+
+func transitionPurchaseOrder(po *models.PurchaseOrder, newStatus string) error {
+	validTransitions := map[string][]string{
+		models.PurchaseOrderDraft:             {models.PurchaseOrderSent},
+		models.PurchaseOrderSent:              {models.PurchaseOrderPartiallyReceived, models.PurchaseOrderReceived},
+		models.PurchaseOrderPartiallyReceived: {models.PurchaseOrderPartiallyReceived, models.PurchaseOrderReceived, models.PurchaseOrderClosed},
+		models.PurchaseOrderReceived:          {models.PurchaseOrderClosed},
+	}
+
+	for _, s := range validTransitions[po.Status] {
+		if s == newStatus {
+			po.Status = newStatus
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s to %s", ErrInvalidPurchaseOrderTransition, po.Status, newStatus)
+}
+
+// CreatePurchaseOrder opens a draft purchase order with the given lines
+func CreatePurchaseOrder(tx *gorm.DB, supplierID uint, notes string, lines []PurchaseOrderLineInput) (*models.PurchaseOrder, error) {
+	if len(lines) == 0 {
+		return nil, ErrEmptyPurchaseOrder
+	}
+	var supplier models.Supplier
+	if err := tx.First(&supplier, supplierID).Error; err != nil {
+		return nil, fmt.Errorf("supplier %d not found", supplierID)
+	}
+
+	po := models.PurchaseOrder{
+		SupplierID: supplierID,
+		Status:     models.PurchaseOrderDraft,
+		Notes:      notes,
+	}
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("line for item %d: quantity must be positive", line.InventoryItemID)
+		}
+		var item models.InventoryItem
+		if err := tx.First(&item, line.InventoryItemID).Error; err != nil {
+			return nil, fmt.Errorf("inventory item %d not found", line.InventoryItemID)
+		}
+		po.Lines = append(po.Lines, models.PurchaseOrderLine{
+			InventoryItemID: item.ID,
+			SKU:             item.SKU,
+			Quantity:        line.Quantity,
+			UnitCost:        line.UnitCost,
+		})
+	}
+
+	if err := tx.Create(&po).Error; err != nil {
+		return nil, err
+	}
+	po.Supplier = supplier
+	return &po, nil
+}
+
+// SendPurchaseOrder marks a draft purchase order as sent to the supplier
+func SendPurchaseOrder(tx *gorm.DB, po *models.PurchaseOrder) error {
+	if err := transitionPurchaseOrder(po, models.PurchaseOrderSent); err != nil {
+		return err
+	}
+	now := time.Now()
+	po.SentAt = &now
+	return tx.Omit(clause.Associations).Save(po).Error
+}
+
+// ClosePurchaseOrder closes a received or short-shipped purchase order
+func ClosePurchaseOrder(tx *gorm.DB, po *models.PurchaseOrder) error {
+	if err := transitionPurchaseOrder(po, models.PurchaseOrderClosed); err != nil {
+		return err
+	}
+	now := time.Now()
+	po.ClosedAt = &now
+	return tx.Omit(clause.Associations).Save(po).Error
+}
+
+// ReceivePurchaseOrder books received quantities into inventory at a location and advances the PO status
+func ReceivePurchaseOrder(tx *gorm.DB, po *models.PurchaseOrder, locationID uint, receipts []ReceiptInput) error {
+	if len(receipts) == 0 {
+		return errors.New("no receipt lines given")
+	}
+	if po.Status != models.PurchaseOrderSent && po.Status != models.PurchaseOrderPartiallyReceived {
+		return fmt.Errorf("%w: nothing can be received on a %s purchase order", ErrInvalidPurchaseOrderTransition, po.Status)
+	}
+
+	lines := make(map[uint]*models.PurchaseOrderLine, len(po.Lines))
+	for i := range po.Lines {
+		lines[po.Lines[i].ID] = &po.Lines[i]
+	}
+
+	for _, receipt := range receipts {
+		line, ok := lines[receipt.LineID]
+		if !ok {
+			return fmt.Errorf("line %d is not on purchase order %d", receipt.LineID, po.ID)
+		}
+		if receipt.Quantity <= 0 {
+			return fmt.Errorf("line %d: received quantity must be positive", receipt.LineID)
+		}
+		if line.ReceivedQuantity+receipt.Quantity > line.Quantity {
+			return fmt.Errorf("%w: line %d has %d outstanding", ErrOverReceipt, line.ID, line.Quantity-line.ReceivedQuantity)
+		}
+
+		_, err := ReceiveStock(tx, line.InventoryItemID, MovementInput{
+			Quantity:   receipt.Quantity,
+			LocationID: locationID,
+			UnitCost:   line.UnitCost,
+			Reference:  purchaseOrderReference(po),
+		})
+		if err != nil {
+			return err
+		}
+
+		line.ReceivedQuantity += receipt.Quantity
+		if err := tx.Save(line).Error; err != nil {
+			return err
+		}
+	}
+
+	newStatus := models.PurchaseOrderReceived
+	for _, line := range po.Lines {
+		if line.ReceivedQuantity < line.Quantity {
+			newStatus = models.PurchaseOrderPartiallyReceived
+			break
+		}
+	}
+	if err := transitionPurchaseOrder(po, newStatus); err != nil {
+		return err
+	}
+	return tx.Omit(clause.Associations).Save(po).Error
+}
+
+// QuantityOnOrder returns the quantity still expected from open purchase orders, keyed by inventory item
+func QuantityOnOrder(tx *gorm.DB) (map[uint]int, error) {
+	var rows []struct {
+		InventoryItemID uint
+		Outstanding     int
+	}
+	err := tx.Model(&models.PurchaseOrderLine{}).
+		Select("purchase_order_lines.inventory_item_id, SUM(purchase_order_lines.quantity - purchase_order_lines.received_quantity) AS outstanding").
+		Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.purchase_order_id").
+		Where("purchase_orders.status IN ?", []string{
+			models.PurchaseOrderDraft,
+			models.PurchaseOrderSent,
+			models.PurchaseOrderPartiallyReceived,
+		}).
+		Group("purchase_order_lines.inventory_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	onOrder := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		onOrder[row.InventoryItemID] = row.Outstanding
+	}
+	return onOrder, nil
+}
+
+// SuggestPurchaseOrders drafts one purchase order per supplier covering every low-stock SKU
+// not already on order. SKUs without a preferred supplier fall back to defaultSupplierID,
+// or are returned as unassigned when that is nil.
+func SuggestPurchaseOrders(tx *gorm.DB, defaultSupplierID *uint) (*SuggestedPurchaseOrders, error) {
+	report, err := LowStockReport(tx)
+	if err != nil {
+		return nil, err
+	}
+	onOrder, err := QuantityOnOrder(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SuggestedPurchaseOrders{
+		PurchaseOrders: []models.PurchaseOrder{},
+		Unassigned:     []LowStockLine{},
+	}
+	bySupplier := make(map[uint][]PurchaseOrderLineInput)
+	var supplierOrder []uint
+	for _, line := range report {
+		quantity := line.SuggestedQuantity - onOrder[line.Item.ID]
+		if quantity <= 0 {
+			continue
+		}
+
+		supplierID := line.Item.SupplierID
+		if supplierID == nil {
+			supplierID = defaultSupplierID
+		}
+		if supplierID == nil {
+			result.Unassigned = append(result.Unassigned, line)
+			continue
+		}
+
+		if _, seen := bySupplier[*supplierID]; !seen {
+			supplierOrder = append(supplierOrder, *supplierID)
+		}
+		bySupplier[*supplierID] = append(bySupplier[*supplierID], PurchaseOrderLineInput{
+			InventoryItemID: line.Item.ID,
+			Quantity:        quantity,
+		})
+	}
+
+	for _, supplierID := range supplierOrder {
+		po, err := CreatePurchaseOrder(tx, supplierID, "Suggested from low-stock report", bySupplier[supplierID])
+		if err != nil {
+			return nil, err
+		}
+		result.PurchaseOrders = append(result.PurchaseOrders, *po)
+	}
+	return result, nil
+}
+
+func purchaseOrderReference(po *models.PurchaseOrder) string {
+	return fmt.Sprintf("PO-%d", po.ID)
+}
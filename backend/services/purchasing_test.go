@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"printflow/models"
+)
+
+func TestPurchaseOrderReceivingBooksStock(t *testing.T) {
+	tx := newTestDB(t)
+	supplier := models.Supplier{Name: "Blanks Co"}
+	if err := tx.Create(&supplier).Error; err != nil {
+		t.Fatal(err)
+	}
+	item, err := CreateInventoryItem(tx, "T-Shirt", "navy", "S")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreatePurchaseOrder(tx, supplier.ID, "", nil); !errors.Is(err, ErrEmptyPurchaseOrder) {
+		t.Errorf("empty purchase order: got %v, want ErrEmptyPurchaseOrder", err)
+	}
+	po, err := CreatePurchaseOrder(tx, supplier.ID, "", []PurchaseOrderLineInput{{InventoryItemID: item.ID, Quantity: 24, UnitCost: 2.75}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := po.Lines[0].ID
+
+	// Drafts are sent before anything can arrive against them
+	if err := ReceivePurchaseOrder(tx, po, 0, []ReceiptInput{{LineID: line, Quantity: 1}}); !errors.Is(err, ErrInvalidPurchaseOrderTransition) {
+		t.Errorf("receiving a draft: got %v, want ErrInvalidPurchaseOrderTransition", err)
+	}
+	assertStock(t, tx, item.ID, 0, 0)
+
+	if err := SendPurchaseOrder(tx, po); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReceivePurchaseOrder(tx, po, 0, []ReceiptInput{{LineID: line, Quantity: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if po.Status != models.PurchaseOrderPartiallyReceived {
+		t.Errorf("status %s after a part delivery, want %s", po.Status, models.PurchaseOrderPartiallyReceived)
+	}
+	onOrder, err := QuantityOnOrder(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if onOrder[item.ID] != 14 {
+		t.Errorf("%d on order, want 14", onOrder[item.ID])
+	}
+
+	if err := ReceivePurchaseOrder(tx, po, 0, []ReceiptInput{{LineID: line, Quantity: 15}}); !errors.Is(err, ErrOverReceipt) {
+		t.Errorf("receiving more than ordered: got %v, want ErrOverReceipt", err)
+	}
+	if err := ReceivePurchaseOrder(tx, po, 0, []ReceiptInput{{LineID: line, Quantity: 14}}); err != nil {
+		t.Fatal(err)
+	}
+	if po.Status != models.PurchaseOrderReceived {
+		t.Errorf("status %s, want %s", po.Status, models.PurchaseOrderReceived)
+	}
+	assertStock(t, tx, item.ID, 24, 0)
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+var (
+	ErrUnknownQuote = errors.New("quote not found")
+	ErrQuoteClosed  = errors.New("quote can no longer be converted")
+)
+
+// CreateQuote prices an order without placing it. The quote keeps the order input so
+// it can be converted later at the same prices.
+func CreateQuote(tx *gorm.DB, input OrderInput) (*models.Quote, error) {
+	plan, err := planOrder(tx, input)
+	if err != nil {
+		return nil, err
+	}
+	// The tax is estimated on an order as it would be placed now
+	var draft models.Order
+	if input.CustomerID != 0 {
+		if _, err := GetCustomer(tx, input.CustomerID); err != nil {
+			return nil, err
+		}
+		address, err := ResolveShippingAddress(tx, input.CustomerID, input.ShippingAddressID)
+		if err != nil {
+			return nil, err
+		}
+		draft.CustomerID = &input.CustomerID
+		if address != nil {
+			draft.ShippingAddressID = &address.ID
+		}
+	} else if input.ShippingAddressID != 0 {
+		return nil, fmt.Errorf("%w: a shipping address needs a customer", ErrInvalidOrder)
+	}
+	location, err := orderLocation(tx, input, plan.lines)
+	if err != nil {
+		return nil, err
+	}
+	draft.LocationID = location.ID
+
+	price, err := priceNewOrder(tx, input, plan.lines)
+	if err != nil {
+		return nil, err
+	}
+	applyPrice(&draft, plan.lines, price)
+	if err := taxOrder(tx, &draft, plan.lines); err != nil {
+		return nil, err
+	}
+	table, err := CurrentPriceTable()
+	if err != nil {
+		return nil, err
+	}
+	request, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := models.Quote{
+		Status:               models.QuoteOpen,
+		Request:              string(request),
+		Currency:             price.Currency,
+		Rush:                 price.Rush,
+		Quantity:             price.Quantity,
+		QuantityBreakPercent: price.QuantityBreakPercent,
+		Subtotal:             price.Subtotal,
+		PriceList:            price.PriceList,
+		DiscountTotal:        price.DiscountTotal,
+		RushFee:              price.RushFee,
+		TaxTotal:             draft.TaxTotal,
+		Total:                draft.Total,
+		ExpiresAt:            time.Now().AddDate(0, 0, table.QuoteValidDays),
+	}
+	if input.CustomerID != 0 {
+		quote.CustomerID = &input.CustomerID
+	}
+	for i, line := range plan.lines {
+		lp := price.Lines[i]
+		quote.Lines = append(quote.Lines, models.QuoteLine{
+			Product:         line.Product,
+			Color:           line.Color,
+			Size:            line.Size,
+			Quantity:        line.Quantity,
+			Placements:      line.Placements,
+			LogoColors:      line.LogoColors,
+			PrintAreaCm2:    line.PrintAreaCm2,
+			BasePrice:       lp.BasePrice,
+			SizeUpcharge:    lp.SizeUpcharge,
+			DecorationPrice: lp.DecorationPrice,
+			QuantityBreak:   lp.QuantityBreak,
+			UnitPrice:       lp.UnitPrice,
+			LineTotal:       lp.LineTotal,
+			Discount:        lp.Discount,
+			TaxRate:         line.TaxRate,
+			Tax:             line.Tax,
+		})
+	}
+	for _, d := range price.Discounts {
+		quote.Discounts = append(quote.Discounts, models.QuoteDiscount{Name: d.Name, Code: d.Code, Type: d.Type, Amount: d.Amount})
+	}
+	if err := tx.Create(&quote).Error; err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// GetQuote loads a quote with its lines
+func GetQuote(tx *gorm.DB, id uint) (*models.Quote, error) {
+	var quote models.Quote
+	err := tx.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		First(&quote, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownQuote
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// ListQuotes returns quotes newest first, optionally for one customer or status
+func ListQuotes(tx *gorm.DB, customerID uint, status string) ([]models.Quote, error) {
+	query := tx.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Order("id DESC")
+	if customerID != 0 {
+		query = query.Where("customer_id = ?", customerID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	quotes := []models.Quote{}
+	if err := query.Find(&quotes).Error; err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+// ConvertQuote places the order a quote was made for at the quoted prices, even if
+// the price table has changed since. Tax is worked out again at the current rates.
+// A quote converts once, before it expires.
+func ConvertQuote(tx *gorm.DB, id uint, meta TransitionMeta) (*models.Order, *models.Quote, error) {
+	quote, err := GetQuote(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if quote.Status != models.QuoteOpen {
+		return nil, nil, fmt.Errorf("%w: it was already converted to order %d", ErrQuoteClosed, derefID(quote.OrderID))
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, nil, fmt.Errorf("%w: it expired on %s; request a new quote", ErrQuoteClosed, quote.ExpiresAt.Format("2006-01-02"))
+	}
+
+	var input OrderInput
+	if err := json.Unmarshal([]byte(quote.Request), &input); err != nil {
+		return nil, nil, fmt.Errorf("reading quote %d: %w", quote.ID, err)
+	}
+	plan, err := planOrder(tx, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	price, err := quotedPrice(quote, plan.lines)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if meta.Reason == "" {
+		meta.Reason = fmt.Sprintf("converted from quote %d", quote.ID)
+	}
+	order, err := createPlannedOrder(tx, input, plan, price, quote, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only one conversion may win if two race
+	converted := tx.Model(quote).Where("status = ?", models.QuoteOpen).
+		Updates(map[string]interface{}{"status": models.QuoteConverted, "order_id": order.ID})
+	if converted.Error != nil {
+		return nil, nil, converted.Error
+	}
+	if converted.RowsAffected == 0 {
+		return nil, nil, fmt.Errorf("%w: it was converted by another request", ErrQuoteClosed)
+	}
+	quote.Status, quote.OrderID = models.QuoteConverted, &order.ID
+	return order, quote, nil
+}
+
+// quotedPrice rebuilds the price locked on a quote, before tax, checking the lines
+// still match
+func quotedPrice(quote *models.Quote, lines []models.OrderLine) (*Price, error) {
+	if len(lines) != len(quote.Lines) {
+		return nil, fmt.Errorf("%w: the quote no longer matches the order's lines", ErrInvalidOrder)
+	}
+	price := &Price{
+		Currency:             quote.Currency,
+		Lines:                make([]LinePrice, len(lines)),
+		Quantity:             quote.Quantity,
+		QuantityBreakPercent: quote.QuantityBreakPercent,
+		Subtotal:             quote.Subtotal,
+		PriceList:            quote.PriceList,
+		Discounts:            make([]AppliedDiscount, len(quote.Discounts)),
+		DiscountTotal:        quote.DiscountTotal,
+		Rush:                 quote.Rush,
+		RushFee:              quote.RushFee,
+		Total:                roundCents(quote.Total - quote.TaxTotal),
+	}
+	for i, line := range lines {
+		q := quote.Lines[i]
+		if line.Product != q.Product || line.Color != q.Color || line.Size != q.Size || line.Quantity != q.Quantity {
+			return nil, fmt.Errorf("%w: quote line %d no longer matches %s %s %s", ErrInvalidOrder, i+1, line.Color, line.Product, line.Size)
+		}
+		price.Lines[i] = LinePrice{
+			BasePrice:       q.BasePrice,
+			SizeUpcharge:    q.SizeUpcharge,
+			DecorationPrice: q.DecorationPrice,
+			QuantityBreak:   q.QuantityBreak,
+			UnitPrice:       q.UnitPrice,
+			LineTotal:       q.LineTotal,
+			Discount:        q.Discount,
+		}
+	}
+	for i, d := range quote.Discounts {
+		price.Discounts[i] = AppliedDiscount{Name: d.Name, Code: d.Code, Type: d.Type, Amount: d.Amount}
+	}
+	return price, nil
+}
+
+func derefID(id *uint) uint {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
@@ -0,0 +1,552 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// DefaultTaxFile is read at startup when TAX_FILE is not set
+const DefaultTaxFile = "tax.json"
+
+var ErrTaxNotLoaded = errors.New("tax table has not been loaded")
+
+// LocalTax is a county, city or zip code within a state that charges its own rate
+// on top of the state's. Zips are five digit zip codes or prefixes of them; an order
+// ships into the local jurisdiction with the longest zip matching its address.
+type LocalTax struct {
+	Name       string             `json:"name"`
+	Zips       []string           `json:"zips"`
+	Rate       float64            `json:"rate"`       // percent
+	Categories map[string]float64 `json:"categories"` // rates for product tax categories that differ from Rate
+}
+
+// StateTax is the sales tax charged on orders shipped into a state
+type StateTax struct {
+	State       string             `json:"state"` // two letter code, as on addresses
+	Rate        float64            `json:"rate"`  // percent
+	Categories  map[string]float64 `json:"categories"`
+	RushTaxable bool               `json:"rushTaxable"` // rush fees are taxed with the goods, by the state and its local jurisdictions
+	Local       []LocalTax         `json:"local"`
+}
+
+// TaxTable is the contents of the tax file. States it does not list are not taxed.
+type TaxTable struct {
+	DefaultCategory string            `json:"defaultCategory"` // for products not listed
+	Products        map[string]string `json:"products"`        // product to tax category
+	States          []StateTax        `json:"states"`
+}
+
+// LineTax is the sales tax on one order line
+type LineTax struct {
+	Category      string                `json:"category"`
+	Rate          float64               `json:"rate"`
+	Amount        float64               `json:"amount"`
+	Jurisdictions []models.OrderLineTax `json:"jurisdictions"`
+}
+
+// SalesTax is the tax on a set of order lines shipped to one address
+type SalesTax struct {
+	State       string    `json:"state"`
+	Zip         string    `json:"zip"`
+	Certificate string    `json:"certificate,omitempty"` // the exemption certificate the lines were not taxed under
+	Lines       []LineTax `json:"lines"`
+	Total       float64   `json:"total"`
+}
+
+var taxTable *TaxTable
+
+// TaxFile returns the tax file path from TAX_FILE, or the default
+func TaxFile() string {
+	if path := os.Getenv("TAX_FILE"); path != "" {
+		return path
+	}
+	return DefaultTaxFile
+}
+
+// LoadTaxRates reads, validates and installs the tax table in path
+func LoadTaxRates(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading tax file: %w", err)
+	}
+	defer file.Close()
+
+	var table TaxTable
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&table); err != nil {
+		return fmt.Errorf("parsing tax file %s: %w", path, err)
+	}
+	if err := validateTaxTable(&table); err != nil {
+		return fmt.Errorf("invalid tax file %s: %w", path, err)
+	}
+	taxTable = &table
+	return nil
+}
+
+// CurrentTaxTable returns the loaded tax table
+func CurrentTaxTable() (*TaxTable, error) {
+	if taxTable == nil {
+		return nil, ErrTaxNotLoaded
+	}
+	return taxTable, nil
+}
+
+// validateTaxTable checks the table and keys its products, states and categories
+// by lower case name so lookups ignore case
+func validateTaxTable(table *TaxTable) error {
+	table.DefaultCategory = strings.ToLower(strings.TrimSpace(table.DefaultCategory))
+	if table.DefaultCategory == "" {
+		return errors.New("defaultCategory is required")
+	}
+	categories := map[string]bool{table.DefaultCategory: true}
+	products := make(map[string]string, len(table.Products))
+	for product, category := range table.Products {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if product == "" || category == "" {
+			return fmt.Errorf("product %q needs a tax category", product)
+		}
+		products[strings.ToLower(product)] = category
+		categories[category] = true
+	}
+	table.Products = products
+
+	checkRates := func(where string, rate float64, rates map[string]float64) (map[string]float64, error) {
+		if rate < 0 || rate >= 100 {
+			return nil, fmt.Errorf("%s needs a rate from 0 to below 100", where)
+		}
+		keyed := make(map[string]float64, len(rates))
+		for category, rate := range rates {
+			key := strings.ToLower(category)
+			if !categories[key] {
+				return nil, fmt.Errorf("%s has a rate for unknown category %q", where, category)
+			}
+			if rate < 0 || rate >= 100 {
+				return nil, fmt.Errorf("%s needs a rate from 0 to below 100 for %q", where, category)
+			}
+			keyed[key] = rate
+		}
+		return keyed, nil
+	}
+
+	states := make(map[string]bool)
+	for i := range table.States {
+		state := &table.States[i]
+		state.State = strings.ToUpper(strings.TrimSpace(state.State))
+		if state.State == "" || states[state.State] {
+			return fmt.Errorf("state %q needs a unique code", state.State)
+		}
+		states[state.State] = true
+		var err error
+		if state.Categories, err = checkRates("state "+state.State, state.Rate, state.Categories); err != nil {
+			return err
+		}
+
+		zips := make(map[string]string)
+		for j := range state.Local {
+			local := &state.Local[j]
+			where := fmt.Sprintf("%s local jurisdiction %q", state.State, local.Name)
+			if strings.TrimSpace(local.Name) == "" {
+				return fmt.Errorf("%s local jurisdiction %d needs a name", state.State, j+1)
+			}
+			if len(local.Zips) == 0 {
+				return fmt.Errorf("%s needs at least one zip", where)
+			}
+			for _, zip := range local.Zips {
+				if len(zip) == 0 || len(zip) > 5 || strings.IndexFunc(zip, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+					return fmt.Errorf("%s has zip %q; zips are up to five digits", where, zip)
+				}
+				if other, ok := zips[zip]; ok {
+					return fmt.Errorf("zip %q is in both %q and %q", zip, other, local.Name)
+				}
+				zips[zip] = local.Name
+			}
+			if local.Categories, err = checkRates(where, local.Rate, local.Categories); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TaxOrderLines works out the sales tax on priced lines shipped to address, on each
+// line's total less its discount. Where the state taxes rush fees, rushFee is shared
+// across the lines by their sales and taxed with them. Lines are taxed by the state on
+// the address and the local jurisdiction its zip falls in, at the rates for the
+// product's tax category. With an exemption certificate the lines are recorded as
+// exempt sales.
+//
+// Each line's tax is rounded once, at its combined rate, and the jurisdictions' shares
+// are adjusted to add up to it, so the liability report matches order totals.
+func TaxOrderLines(lines []models.OrderLine, address LabelInput, certificate string, rushFee float64) (*SalesTax, error) {
+	table, err := CurrentTaxTable()
+	if err != nil {
+		return nil, err
+	}
+	tax := &SalesTax{
+		State:       strings.ToUpper(strings.TrimSpace(address.State)),
+		Zip:         taxZip(address.Zip),
+		Certificate: strings.TrimSpace(certificate),
+		Lines:       make([]LineTax, len(lines)),
+	}
+	state := table.state(tax.State)
+	var local *LocalTax
+	if state != nil {
+		local = state.local(tax.Zip)
+	}
+
+	sales := make([]float64, len(lines))
+	for i, line := range lines {
+		sales[i] = roundCents(line.LineTotal - line.Discount)
+	}
+	if state != nil && state.RushTaxable && rushFee != 0 {
+		for i, share := range shareCents(rushFee, sales) {
+			sales[i] = roundCents(sales[i] + share)
+		}
+	}
+
+	for i, line := range lines {
+		lt := LineTax{Category: table.category(line.Product), Jurisdictions: []models.OrderLineTax{}}
+		charge := func(name, level string, rate float64) {
+			row := models.OrderLineTax{
+				State:        state.State,
+				Jurisdiction: name,
+				Level:        level,
+				Category:     lt.Category,
+				Sales:        sales[i],
+				Exempt:       tax.Certificate != "",
+			}
+			if !row.Exempt {
+				row.Rate = rate
+				row.Amount = roundCents(sales[i] * rate / 100)
+			}
+			lt.Rate += row.Rate
+			lt.Jurisdictions = append(lt.Jurisdictions, row)
+		}
+		if state != nil {
+			charge(state.State, models.TaxLevelState, categoryRate(state.Rate, state.Categories, lt.Category))
+		}
+		if local != nil {
+			charge(local.Name, models.TaxLevelLocal, categoryRate(local.Rate, local.Categories, lt.Category))
+		}
+
+		lt.Amount = roundCents(sales[i] * lt.Rate / 100)
+		allocateLineTax(&lt)
+		tax.Lines[i] = lt
+		tax.Total += lt.Amount
+	}
+	tax.Total = roundCents(tax.Total)
+	return tax, nil
+}
+
+// allocateLineTax puts the cent left over from rounding each jurisdiction on its own
+// on the jurisdiction with the highest rate, so the rows add up to the line's tax
+func allocateLineTax(lt *LineTax) {
+	if len(lt.Jurisdictions) == 0 {
+		return
+	}
+	sum, highest := 0.0, 0
+	for j, row := range lt.Jurisdictions {
+		sum += row.Amount
+		if row.Rate > lt.Jurisdictions[highest].Rate {
+			highest = j
+		}
+	}
+	if diff := roundCents(lt.Amount - sum); diff != 0 {
+		row := &lt.Jurisdictions[highest]
+		row.Amount = roundCents(row.Amount + diff)
+	}
+}
+
+// shareCents splits amount across weights in proportion, to the cent, with the cents
+// lost to rounding on the largest weight. Without any weight it all goes on the first.
+func shareCents(amount float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+	var total float64
+	largest := 0
+	for i, w := range weights {
+		total += w
+		if w > weights[largest] {
+			largest = i
+		}
+	}
+	if total == 0 {
+		shares[0] = roundCents(amount)
+		return shares
+	}
+	var given float64
+	for i, w := range weights {
+		shares[i] = roundCents(amount * w / total)
+		given += shares[i]
+	}
+	shares[largest] = roundCents(shares[largest] + amount - given)
+	return shares
+}
+
+// state finds a state's rates by its code
+func (t *TaxTable) state(code string) *StateTax {
+	for i := range t.States {
+		if t.States[i].State == code {
+			return &t.States[i]
+		}
+	}
+	return nil
+}
+
+// category is the product's tax category, or the default for products not listed
+func (t *TaxTable) category(product string) string {
+	if category, ok := t.Products[strings.ToLower(product)]; ok {
+		return category
+	}
+	return t.DefaultCategory
+}
+
+// local finds the local jurisdiction with the longest zip matching zip, if any
+func (s *StateTax) local(zip string) *LocalTax {
+	var best *LocalTax
+	longest := 0
+	for i := range s.Local {
+		for _, prefix := range s.Local[i].Zips {
+			if len(prefix) > longest && strings.HasPrefix(zip, prefix) {
+				best, longest = &s.Local[i], len(prefix)
+			}
+		}
+	}
+	return best
+}
+
+// categoryRate is the rate for a category, falling back to the jurisdiction's rate
+func categoryRate(rate float64, categories map[string]float64, category string) float64 {
+	if r, ok := categories[category]; ok {
+		return r
+	}
+	return rate
+}
+
+// taxZip keeps the five digit zip code of a zip such as "78701-1234"
+func taxZip(zip string) string {
+	zip = strings.TrimSpace(zip)
+	if i := strings.IndexFunc(zip, func(r rune) bool { return !unicode.IsDigit(r) }); i >= 0 {
+		zip = zip[:i]
+	}
+	if len(zip) > 5 {
+		zip = zip[:5]
+	}
+	return zip
+}
+
+// taxOrder works out the tax on an order's priced lines for where it ships, or for
+// the fulfilling location when it has no shipping address, and adds it to the order's
+// total. Callers store the lines' jurisdictions with the lines.
+func taxOrder(tx *gorm.DB, order *models.Order, lines []models.OrderLine) error {
+	address, ok, err := OrderShipTo(tx, order)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		location, err := FulfillingLocation(tx, order)
+		if err != nil {
+			return err
+		}
+		address = ShipFromAddress(location)
+	}
+	certificate := ""
+	if order.CustomerID != nil {
+		var customer models.Customer
+		err := tx.First(&customer, *order.CustomerID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if customer.TaxExempt {
+			certificate = customer.TaxCertificate
+		}
+	}
+
+	tax, err := TaxOrderLines(lines, address, certificate, order.RushFee)
+	if err != nil {
+		return err
+	}
+	for i := range lines {
+		lines[i].TaxCategory = tax.Lines[i].Category
+		lines[i].TaxRate = tax.Lines[i].Rate
+		lines[i].Tax = tax.Lines[i].Amount
+		lines[i].Taxes = tax.Lines[i].Jurisdictions
+	}
+	order.TaxState, order.TaxZip, order.TaxCertificate = tax.State, tax.Zip, tax.Certificate
+	order.TaxTotal = tax.Total
+	order.Total = roundCents(order.Subtotal - order.DiscountTotal + order.RushFee + order.TaxTotal)
+	return nil
+}
+
+// replaceLineTaxes stores the jurisdictions taxOrder put on already saved lines
+func replaceLineTaxes(tx *gorm.DB, lines []models.OrderLine) error {
+	for i := range lines {
+		if err := tx.Where("order_line_id = ?", lines[i].ID).Delete(&models.OrderLineTax{}).Error; err != nil {
+			return err
+		}
+		for j := range lines[i].Taxes {
+			lines[i].Taxes[j].ID = 0
+			lines[i].Taxes[j].OrderLineID = lines[i].ID
+			if err := tx.Create(&lines[i].Taxes[j]).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OrderTaxes returns the tax each jurisdiction charges on an order's lines
+func OrderTaxes(tx *gorm.DB, orderID uint) ([]models.OrderLineTax, error) {
+	taxes := []models.OrderLineTax{}
+	err := tx.Where("order_line_id IN (?)", tx.Model(&models.OrderLine{}).Select("id").Where("order_id = ?", orderID)).
+		Order("order_line_id, id").Find(&taxes).Error
+	return taxes, err
+}
+
+// TaxLiability is the tax owed to one jurisdiction for sales in one period. Exempt
+// sales are those to exempt customers or in categories the jurisdiction does not tax.
+type TaxLiability struct {
+	Period       string  `json:"period"`
+	State        string  `json:"state"`
+	Jurisdiction string  `json:"jurisdiction"`
+	Level        string  `json:"level"`
+	Orders       int     `json:"orders"`
+	Sales        float64 `json:"sales"`
+	ExemptSales  float64 `json:"exemptSales"`
+	TaxableSales float64 `json:"taxableSales"`
+	Tax          float64 `json:"tax"`
+}
+
+// TaxLiabilityReport totals the sales tax charged on orders sold within a period,
+// by jurisdiction and by month, quarter or year
+type TaxLiabilityReport struct {
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	GroupBy      string         `json:"groupBy"`
+	Liabilities  []TaxLiability `json:"liabilities"`
+	Sales        float64        `json:"sales"` // the jurisdictions' sales added up, so lines taxed locally count twice
+	ExemptSales  float64        `json:"exemptSales"`
+	TaxableSales float64        `json:"taxableSales"`
+	Tax          float64        `json:"tax"`
+}
+
+// TaxLiabilities reports the tax on orders sold between from and to, grouped by
+// groupBy ("month", "quarter" or "year"). An order is sold when its prices were
+// locked. Cancelled and deleted orders are left out.
+func TaxLiabilities(tx *gorm.DB, from, to time.Time, groupBy string) (*TaxLiabilityReport, error) {
+	if groupBy == "" {
+		groupBy = "month"
+	}
+	if groupBy != "month" && groupBy != "quarter" && groupBy != "year" {
+		return nil, fmt.Errorf("groupBy must be month, quarter or year")
+	}
+
+	var rows []struct {
+		OrderID      uint
+		SoldAt       string
+		State        string
+		Jurisdiction string
+		Level        string
+		Sales        float64
+		Rate         float64
+		Amount       float64
+		Exempt       bool
+	}
+	soldAt := "COALESCE(orders.priced_at, orders.created_at)"
+	err := tx.Table("order_line_taxes").
+		Joins("JOIN order_lines ON order_lines.id = order_line_taxes.order_line_id").
+		Joins("JOIN orders ON orders.id = order_lines.order_id").
+		Where("orders.deleted_at IS NULL AND orders.status <> ?", models.StatusCancelled).
+		Where(soldAt+" >= ? AND "+soldAt+" <= ?", from, to).
+		Select("orders.id AS order_id, " + soldAt + " AS sold_at, order_line_taxes.state, order_line_taxes.jurisdiction, " +
+			"order_line_taxes.level, order_line_taxes.sales, order_line_taxes.rate, order_line_taxes.amount, order_line_taxes.exempt").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TaxLiabilityReport{From: from, To: to, GroupBy: groupBy, Liabilities: []TaxLiability{}}
+	liabilities := make(map[string]*TaxLiability)
+	orders := make(map[string]map[uint]bool)
+	for _, row := range rows {
+		period := taxPeriod(parseDBTime(row.SoldAt), groupBy)
+		key := strings.Join([]string{period, row.State, row.Level, row.Jurisdiction}, "|")
+		l, ok := liabilities[key]
+		if !ok {
+			l = &TaxLiability{Period: period, State: row.State, Jurisdiction: row.Jurisdiction, Level: row.Level}
+			liabilities[key] = l
+			orders[key] = make(map[uint]bool)
+		}
+		orders[key][row.OrderID] = true
+		l.Sales += row.Sales
+		if row.Exempt || row.Rate == 0 {
+			l.ExemptSales += row.Sales
+		}
+		l.Tax += row.Amount
+	}
+	for key, l := range liabilities {
+		l.Orders = len(orders[key])
+		l.Sales, l.ExemptSales, l.Tax = roundCents(l.Sales), roundCents(l.ExemptSales), roundCents(l.Tax)
+		l.TaxableSales = roundCents(l.Sales - l.ExemptSales)
+		report.Liabilities = append(report.Liabilities, *l)
+		report.Sales += l.Sales
+		report.ExemptSales += l.ExemptSales
+		report.Tax += l.Tax
+	}
+	sort.Slice(report.Liabilities, func(i, j int) bool {
+		a, b := report.Liabilities[i], report.Liabilities[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		if a.Level != b.Level {
+			return a.Level == models.TaxLevelState
+		}
+		return a.Jurisdiction < b.Jurisdiction
+	})
+	report.Sales, report.ExemptSales, report.Tax = roundCents(report.Sales), roundCents(report.ExemptSales), roundCents(report.Tax)
+	report.TaxableSales = roundCents(report.Sales - report.ExemptSales)
+	return report, nil
+}
+
+// taxPeriod names the month, quarter or year t falls in, e.g. "2024-03", "2024-Q1" or "2024"
+func taxPeriod(t time.Time, groupBy string) string {
+	switch groupBy {
+	case "quarter":
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())+2)/3)
+	case "year":
+		return fmt.Sprintf("%d", t.Year())
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// WriteTaxLiabilityCSV writes a tax liability report as CSV
+func WriteTaxLiabilityCSV(w io.Writer, report *TaxLiabilityReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"period", "state", "jurisdiction", "level", "orders", "sales", "exempt_sales", "taxable_sales", "tax"})
+	for _, l := range report.Liabilities {
+		writer.Write([]string{l.Period, l.State, l.Jurisdiction, l.Level, strconv.Itoa(l.Orders),
+			formatMoney(l.Sales), formatMoney(l.ExemptSales), formatMoney(l.TaxableSales), formatMoney(l.Tax)})
+	}
+	writer.Write([]string{"TOTAL", "", "", "", "", formatMoney(report.Sales), formatMoney(report.ExemptSales), formatMoney(report.TaxableSales), formatMoney(report.Tax)})
+	writer.Flush()
+	return writer.Error()
+}
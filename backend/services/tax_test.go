@@ -0,0 +1,68 @@
+package services
+
+import (
+	"testing"
+
+	"printflow/models"
+)
+
+func TestLineTaxRoundsOnceAndJurisdictionsAddUp(t *testing.T) {
+	austin := LabelInput{State: "TX", Zip: "78701"}
+	// 1.16 at 6.25% and 2% rounds to 0.07 + 0.02, but at 8.25% to 0.10
+	tax, err := TaxOrderLines([]models.OrderLine{{Product: "T-Shirt", LineTotal: 1.16}}, austin, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := tax.Lines[0]
+	if line.Amount != 0.10 {
+		t.Errorf("line tax %.2f, want 0.10", line.Amount)
+	}
+	var sum float64
+	for _, row := range line.Jurisdictions {
+		sum += row.Amount
+	}
+	if roundCents(sum) != line.Amount {
+		t.Errorf("jurisdictions add up to %.2f, not the line's %.2f", sum, line.Amount)
+	}
+}
+
+func TestRushFeeTaxedWhereTheStateTaxesIt(t *testing.T) {
+	lines := []models.OrderLine{
+		{Product: "T-Shirt", LineTotal: 60},
+		{Product: "T-Shirt", LineTotal: 30, Discount: 10},
+	}
+
+	// Texas taxes the rush fee: 10.00 shared 7.50 / 2.50 by sales of 60 and 20
+	tax, err := TaxOrderLines(lines, LabelInput{State: "TX", Zip: "78701"}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tax.Lines[0].Jurisdictions[0].Sales; got != 67.50 {
+		t.Errorf("first line taxed on %.2f, want 67.50", got)
+	}
+	if got := tax.Lines[1].Jurisdictions[0].Sales; got != 22.50 {
+		t.Errorf("second line taxed on %.2f, want 22.50", got)
+	}
+	if tax.Total != roundCents(90*8.25/100) {
+		t.Errorf("tax %.2f, want %.2f", tax.Total, roundCents(90*8.25/100))
+	}
+
+	// California does not
+	tax, err = TaxOrderLines(lines, LabelInput{State: "CA", Zip: "95814"}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tax.Total != roundCents(80*7.25/100) {
+		t.Errorf("tax %.2f, want %.2f", tax.Total, roundCents(80*7.25/100))
+	}
+}
+
+func TestShareCentsAddsUp(t *testing.T) {
+	shares := shareCents(10, []float64{1, 1, 1})
+	if roundCents(shares[0]+shares[1]+shares[2]) != 10 {
+		t.Errorf("shares %v do not add up to 10", shares)
+	}
+	if shares := shareCents(5, []float64{0, 0}); shares[0] != 5 {
+		t.Errorf("shares %v, want all on the first", shares)
+	}
+}
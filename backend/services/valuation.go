@@ -0,0 +1,381 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// Inventory costing methods
+const (
+	CostMethodFIFO    = "fifo"
+	CostMethodAverage = "average"
+)
+
+// ItemValuation is the value of one SKU's on-hand stock at a point in time
+type ItemValuation struct {
+	InventoryItemID uint    `json:"inventoryItemId"`
+	SKU             string  `json:"sku"`
+	OnHand          int     `json:"onHand"`
+	UnitCost        float64 `json:"unitCost"`
+	Value           float64 `json:"value"`
+}
+
+// InventoryValuation is the value of all stock at a point in time
+type InventoryValuation struct {
+	AsOf       time.Time       `json:"asOf"`
+	Method     string          `json:"method"`
+	Items      []ItemValuation `json:"items"`
+	TotalValue float64         `json:"totalValue"`
+}
+
+// OrderCOGS is the cost of goods for one order
+type OrderCOGS struct {
+	OrderID         uint      `json:"orderId"`
+	ConsumedAt      time.Time `json:"consumedAt"`
+	BlankCost       float64   `json:"blankCost"`
+	ConsumablesCost float64   `json:"consumablesCost"`
+	Total           float64   `json:"total"`
+}
+
+// COGSReport is the cost of goods for orders consumed within a period
+type COGSReport struct {
+	From   time.Time   `json:"from"`
+	To     time.Time   `json:"to"`
+	Orders []OrderCOGS `json:"orders"`
+	Total  float64     `json:"total"`
+}
+
+// CostMethod returns the configured costing method (INVENTORY_COST_METHOD), FIFO by default
+func CostMethod() string {
+	if strings.ToLower(os.Getenv("INVENTORY_COST_METHOD")) == CostMethodAverage {
+		return CostMethodAverage
+	}
+	return CostMethodFIFO
+}
+
+// ValidCostMethod reports whether method is a supported costing method
+func ValidCostMethod(method string) bool {
+	return method == CostMethodFIFO || method == CostMethodAverage
+}
+
+// costLayer is a quantity of stock received at one unit cost
+type costLayer struct {
+	Quantity int     `json:"quantity"`
+	UnitCost float64 `json:"unitCost"`
+}
+
+// costState is a SKU's cost position rebuilt from its ledger
+type costState struct {
+	onHand      int
+	averageCost float64
+	layers      []costLayer
+}
+
+// value returns the cost of the on-hand stock under the given method
+func (s *costState) value(method string) float64 {
+	if method == CostMethodAverage {
+		return float64(s.onHand) * s.averageCost
+	}
+	total := 0.0
+	for _, l := range s.layers {
+		total += float64(l.Quantity) * l.UnitCost
+	}
+	return total
+}
+
+// receive adds stock to the cost position
+func (s *costState) receive(quantity int, unitCost float64) {
+	if s.onHand+quantity > 0 {
+		s.averageCost = (float64(s.onHand)*s.averageCost + float64(quantity)*unitCost) / float64(s.onHand+quantity)
+	}
+	s.onHand += quantity
+	s.layers = append(s.layers, costLayer{Quantity: quantity, UnitCost: unitCost})
+}
+
+// issue removes stock from the cost position and returns its cost under the given method
+func (s *costState) issue(quantity int, method string) float64 {
+	fifoCost := 0.0
+	remaining := quantity
+	for remaining > 0 && len(s.layers) > 0 {
+		take := remaining
+		if s.layers[0].Quantity < take {
+			take = s.layers[0].Quantity
+		}
+		fifoCost += float64(take) * s.layers[0].UnitCost
+		s.layers[0].Quantity -= take
+		remaining -= take
+		if s.layers[0].Quantity == 0 {
+			s.layers = s.layers[1:]
+		}
+	}
+	s.onHand -= quantity
+
+	if method == CostMethodAverage {
+		return float64(quantity) * s.averageCost
+	}
+	return fifoCost
+}
+
+// apply folds one costed movement into the position and returns the cost of the stock
+// it issued. Stock entering without a cost comes in at the inbound cost.
+func (s *costState) apply(quantity int, unitCost float64, method string) float64 {
+	switch {
+	case quantity > 0:
+		if unitCost <= 0 {
+			unitCost = s.inboundCost()
+		}
+		s.receive(quantity, unitCost)
+	case quantity < 0:
+		return s.issue(-quantity, method)
+	}
+	return 0
+}
+
+// inboundCost is the unit cost for stock entering without a purchase price (found stock,
+// receipts with no cost): the current average, or zero for a SKU never costed
+func (s *costState) inboundCost() float64 {
+	return s.averageCost
+}
+
+// replayCosts rebuilds a SKU's cost position from its ledger, up to and including asOf
+func replayCosts(tx *gorm.DB, itemID uint, asOf time.Time, method string) (*costState, error) {
+	var movements []models.StockMovement
+	err := tx.Where("inventory_item_id = ? AND created_at <= ? AND type IN ?", itemID, asOf, costedMovements).
+		Order("created_at, id").
+		Find(&movements).Error
+	if err != nil {
+		return nil, err
+	}
+
+	state := &costState{}
+	for _, m := range movements {
+		state.apply(m.Quantity, m.UnitCost, method)
+	}
+	return state, nil
+}
+
+// costedMovements are the movement types that change what a SKU's stock cost
+var costedMovements = []string{models.MovementReceive, models.MovementAdjust, models.MovementConsume}
+
+// currentCosts loads a SKU's saved cost position, bringing it up to date with any
+// movements posted since it was saved (all of them for a SKU never saved)
+func currentCosts(tx *gorm.DB, itemID uint) (*costState, error) {
+	var saved models.ItemCost
+	if err := tx.Where("inventory_item_id = ?", itemID).Limit(1).Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	state := &costState{onHand: saved.OnHand, averageCost: saved.AverageCost}
+	if saved.Layers != "" {
+		if err := json.Unmarshal([]byte(saved.Layers), &state.layers); err != nil {
+			return nil, fmt.Errorf("cost layers of item %d: %w", itemID, err)
+		}
+	}
+
+	var movements []models.StockMovement
+	err := tx.Where("inventory_item_id = ? AND id > ? AND type IN ?", itemID, saved.ThroughMovementID, costedMovements).
+		Order("id").
+		Find(&movements).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range movements {
+		state.apply(m.Quantity, m.UnitCost, CostMethod())
+	}
+	return state, nil
+}
+
+// saveCosts stores a SKU's cost position as of movementID
+func saveCosts(tx *gorm.DB, itemID uint, state *costState, movementID uint) error {
+	layers, err := json.Marshal(state.layers)
+	if err != nil {
+		return err
+	}
+	return tx.Save(&models.ItemCost{
+		InventoryItemID:   itemID,
+		OnHand:            state.onHand,
+		AverageCost:       state.averageCost,
+		Layers:            string(layers),
+		ThroughMovementID: movementID,
+	}).Error
+}
+
+// ValueInventory values every SKU's on-hand stock as of a point in time
+func ValueInventory(tx *gorm.DB, asOf time.Time, method string) (*InventoryValuation, error) {
+	if !ValidCostMethod(method) {
+		return nil, fmt.Errorf("unknown cost method %q", method)
+	}
+
+	var items []models.InventoryItem
+	if err := tx.Order("sku").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	valuation := &InventoryValuation{
+		AsOf:   asOf,
+		Method: method,
+		Items:  make([]ItemValuation, 0, len(items)),
+	}
+	for _, item := range items {
+		state, err := replayCosts(tx, item.ID, asOf, method)
+		if err != nil {
+			return nil, err
+		}
+		if state.onHand == 0 {
+			continue
+		}
+
+		value := roundQuantity(state.value(method))
+		valuation.Items = append(valuation.Items, ItemValuation{
+			InventoryItemID: item.ID,
+			SKU:             item.SKU,
+			OnHand:          state.onHand,
+			UnitCost:        roundQuantity(value / float64(state.onHand)),
+			Value:           value,
+		})
+		valuation.TotalValue += value
+	}
+	valuation.TotalValue = roundQuantity(valuation.TotalValue)
+	return valuation, nil
+}
+
+// RecordOrderCostOfGoods totals the blank and consumable cost consumed by an order onto it
+func RecordOrderCostOfGoods(tx *gorm.DB, order *models.Order) error {
+	var blankCost, consumablesCost float64
+	err := tx.Model(&models.StockMovement{}).
+		Select("COALESCE(SUM(cost), 0)").
+		Where("order_id = ? AND type = ?", order.ID, models.MovementConsume).
+		Scan(&blankCost).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&models.ConsumableMovement{}).
+		Select("COALESCE(SUM(cost), 0)").
+		Where("order_id = ? AND type = ?", order.ID, models.ConsumableUse).
+		Scan(&consumablesCost).Error
+	if err != nil {
+		return err
+	}
+
+	order.CostOfGoods = roundQuantity(blankCost + consumablesCost)
+	return nil
+}
+
+// CostOfGoodsSold reports COGS for every order whose blanks or consumables were used between from and to
+func CostOfGoodsSold(tx *gorm.DB, from, to time.Time) (*COGSReport, error) {
+	type costRow struct {
+		OrderID    uint
+		ConsumedAt string
+		Cost       float64
+	}
+
+	// Blanks and consumables are both counted by when they were used, so an order
+	// consumed across two periods has each share reported in its own period
+	var blanks, consumables []costRow
+	err := tx.Model(&models.StockMovement{}).
+		Select("order_id, MAX(created_at) AS consumed_at, SUM(cost) AS cost").
+		Where("type = ? AND order_id IS NOT NULL AND created_at >= ? AND created_at <= ?", models.MovementConsume, from, to).
+		Group("order_id").
+		Scan(&blanks).Error
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(&models.ConsumableMovement{}).
+		Select("order_id, MAX(created_at) AS consumed_at, SUM(cost) AS cost").
+		Where("type = ? AND order_id IS NOT NULL AND created_at >= ? AND created_at <= ?", models.ConsumableUse, from, to).
+		Group("order_id").
+		Scan(&consumables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make(map[uint]*OrderCOGS)
+	var ids []uint
+	line := func(row costRow) *OrderCOGS {
+		order, ok := orders[row.OrderID]
+		if !ok {
+			order = &OrderCOGS{OrderID: row.OrderID}
+			orders[row.OrderID] = order
+			ids = append(ids, row.OrderID)
+		}
+		if consumedAt := parseDBTime(row.ConsumedAt); consumedAt.After(order.ConsumedAt) {
+			order.ConsumedAt = consumedAt
+		}
+		return order
+	}
+	for _, row := range blanks {
+		line(row).BlankCost = roundQuantity(row.Cost)
+	}
+	for _, row := range consumables {
+		line(row).ConsumablesCost = roundQuantity(row.Cost)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	report := &COGSReport{From: from, To: to, Orders: make([]OrderCOGS, 0, len(ids))}
+	for _, id := range ids {
+		order := orders[id]
+		order.Total = roundQuantity(order.BlankCost + order.ConsumablesCost)
+		report.Orders = append(report.Orders, *order)
+		report.Total += order.Total
+	}
+	report.Total = roundQuantity(report.Total)
+	return report, nil
+}
+
+// WriteValuationCSV writes an inventory valuation as CSV
+func WriteValuationCSV(w io.Writer, valuation *InventoryValuation) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"sku", "on_hand", "unit_cost", "value"})
+	for _, item := range valuation.Items {
+		writer.Write([]string{
+			item.SKU,
+			strconv.Itoa(item.OnHand),
+			formatMoney(item.UnitCost),
+			formatMoney(item.Value),
+		})
+	}
+	writer.Write([]string{"TOTAL", "", "", formatMoney(valuation.TotalValue)})
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteCOGSCSV writes a COGS report as CSV
+func WriteCOGSCSV(w io.Writer, report *COGSReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"order_id", "consumed_at", "blank_cost", "consumables_cost", "total"})
+	for _, line := range report.Orders {
+		writer.Write([]string{
+			strconv.Itoa(int(line.OrderID)),
+			line.ConsumedAt.Format(time.RFC3339),
+			formatMoney(line.BlankCost),
+			formatMoney(line.ConsumablesCost),
+			formatMoney(line.Total),
+		})
+	}
+	writer.Write([]string{"TOTAL", "", "", "", formatMoney(report.Total)})
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatMoney(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// parseDBTime reads a timestamp returned by an aggregate query, which SQLite hands back as text
+func parseDBTime(value string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
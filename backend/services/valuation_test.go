@@ -0,0 +1,41 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"printflow/models"
+)
+
+func TestCostOfGoodsSoldCountsEachCostInItsOwnPeriod(t *testing.T) {
+	tx := newTestDB(t)
+	order, _, _ := printingOrder(t, tx, 10)
+
+	// Ink used for the order last month belongs to last month's report
+	ink, err := CreateConsumable(tx, "Black ink", models.UnitMilliliters, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastMonth := time.Now().AddDate(0, -1, 0)
+	use := models.ConsumableMovement{ConsumableID: ink.ID, Type: models.ConsumableUse, Quantity: -5, Cost: 2.5, OrderID: &order.ID, CreatedAt: lastMonth}
+	if err := tx.Create(&use).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	thisPeriod, err := CostOfGoodsSold(tx, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thisPeriod.Orders) != 1 || thisPeriod.Orders[0].BlankCost != 30 || thisPeriod.Orders[0].ConsumablesCost != 0 {
+		t.Errorf("this period %+v, want 30.00 of blanks and no consumables", thisPeriod.Orders)
+	}
+
+	lastPeriod, err := CostOfGoodsSold(tx, lastMonth.Add(-time.Hour), lastMonth.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lastPeriod.Orders) != 1 || lastPeriod.Orders[0].BlankCost != 0 || lastPeriod.Total != 2.5 {
+		t.Errorf("last period %+v, want 2.50 of consumables only", lastPeriod.Orders)
+	}
+}
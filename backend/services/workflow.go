@@ -2,23 +2,204 @@ package services
 
 import (
     "errors"
+    "fmt"
+    "log"
     "printflow/models"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+var (
+    ErrInvalidTransition = errors.New("invalid state transition")
+    ErrTransitionBlocked = errors.New("transition blocked")
 )
 
+// GuardFailure is one guard that blocked a transition
+type GuardFailure struct {
+    Guard   string `json:"guard"`
+    Message string `json:"message"`
+}
+
+// GuardError reports every guard that blocked a transition
+type GuardError struct {
+    From     string         `json:"from"`
+    To       string         `json:"to"`
+    Failures []GuardFailure `json:"failures"`
+}
+
+func (e *GuardError) Error() string {
+    messages := make([]string, len(e.Failures))
+    for i, f := range e.Failures {
+        messages[i] = f.Guard + ": " + f.Message
+    }
+    return fmt.Sprintf("%s: %s -> %s (%s)", ErrTransitionBlocked, e.From, e.To, strings.Join(messages, "; "))
+}
+
+func (e *GuardError) Is(target error) bool {
+    return target == ErrTransitionBlocked
+}
+
+// TransitionMeta describes who changed an order's status, why, and from which request
+type TransitionMeta struct {
+    Actor      string
+    Reason     string
+    RemoteAddr string
+    UserAgent  string
+    RequestID  string
+}
+
+// Transition moves an order to newStatus if its product's workflow allows it.
+// Entering ON_HOLD remembers the current status, and a held order may always
+// resume to that status.
 func Transition(order *models.Order, newStatus string) error {
-    validTransitions := map[string][]string{
-        models.StatusCreated:         {models.StatusMockupGenerated},
-        models.StatusMockupGenerated: {models.StatusApproved},
-        models.StatusApproved:        {models.StatusReady},
+    _, err := transitionDefinition(order, newStatus)
+    if err != nil {
+        return err
+    }
+
+    if newStatus == models.StatusOnHold {
+        order.HeldFrom = order.Status
+    } else {
+        order.HeldFrom = ""
+    }
+    order.Status = newStatus
+    return nil
+}
+
+// transitionDefinition finds the workflow transition for an order's move to newStatus.
+// Resuming from hold has no definition, so it returns nil with no error.
+func transitionDefinition(order *models.Order, newStatus string) (*TransitionDefinition, error) {
+    if order.Status == models.StatusOnHold && order.HeldFrom != "" && newStatus == order.HeldFrom {
+        return nil, nil
+    }
+
+    workflow, err := WorkflowFor(order.Product)
+    if err != nil {
+        return nil, err
+    }
+    definition := workflow.transitionTo(order.Status, newStatus)
+    if definition == nil {
+        return nil, ErrInvalidTransition
+    }
+    return definition, nil
+}
+
+// ReachedStatus reports whether an order has progressed at least as far as status
+// along its workflow. A held order counts as being at the status it was held from.
+func ReachedStatus(order *models.Order, status string) bool {
+    current := order.Status
+    if current == models.StatusOnHold {
+        current = order.HeldFrom
+    }
+
+    workflow, err := WorkflowFor(order.Product)
+    if err != nil {
+        return false
+    }
+    currentRank, targetRank := workflow.rank(current), workflow.rank(status)
+    return currentRank >= 0 && targetRank >= 0 && currentRank >= targetRank
+}
+
+// ApplyTransition checks the transition's guards, moves the order to the new status
+// and runs the transition's hooks, then saves the order and records the change in its
+// history. Run it inside a transaction so a failed hook leaves the order, its history
+// and stock untouched.
+func ApplyTransition(tx *gorm.DB, order *models.Order, newStatus string, meta TransitionMeta) error {
+    definition, err := transitionDefinition(order, newStatus)
+    if err != nil {
+        return err
     }
 
-    allowed := validTransitions[order.Status]
-    for _, s := range allowed {
-        if s == newStatus {
-            order.Status = newStatus
-            return nil
+    var guards, hooks []string
+    if definition != nil {
+        guards, hooks = definition.Guards, definition.Hooks
+    }
+    if err := checkGuards(tx, order, newStatus, guards); err != nil {
+        return err
+    }
+
+    from := order.Status
+    if err := Transition(order, newStatus); err != nil {
+        return err
+    }
+    for _, name := range hooks {
+        if err := hookRegistry[name](tx, order); err != nil {
+            return err
         }
     }
 
-    return errors.New("invalid state transition")
+    if err := SaveOrder(tx, order); err != nil {
+        return err
+    }
+    return RecordOrderEvent(tx, order, from, meta)
+}
+
+// CanTransition reports whether the order may move to newStatus, checking the
+// transition's guards without changing anything
+func CanTransition(tx *gorm.DB, order *models.Order, newStatus string) error {
+    definition, err := transitionDefinition(order, newStatus)
+    if err != nil || definition == nil {
+        return err
+    }
+    return checkGuards(tx, order, newStatus, definition.Guards)
+}
+
+// checkGuards runs every guard and reports all that fail, not just the first
+func checkGuards(tx *gorm.DB, order *models.Order, newStatus string, guards []string) error {
+    guardErr := &GuardError{From: order.Status, To: newStatus}
+    for _, name := range guards {
+        err := guardRegistry[name](tx, order)
+        if err != nil {
+            guardErr.Failures = append(guardErr.Failures, GuardFailure{Guard: name, Message: err.Error()})
+        }
+    }
+    if len(guardErr.Failures) > 0 {
+        return guardErr
+    }
+    return nil
+}
+
+// RunAfterCommitHooks fires the after-commit hooks of the order's move from `from`
+// to its current status. Call it once the transition's transaction has committed.
+// The hooks run in the background and their failures are logged.
+func RunAfterCommitHooks(db *gorm.DB, order models.Order, from string) {
+    workflow, err := WorkflowFor(order.Product)
+    if err != nil {
+        return
+    }
+    definition := workflow.transitionTo(from, order.Status)
+    if definition == nil || len(definition.AfterCommit) == 0 {
+        return
+    }
+
+    go func(hooks []string) {
+        for _, name := range hooks {
+            if err := afterCommitRegistry[name](db, order, from); err != nil {
+                log.Printf("Order %d %s -> %s: %s hook failed: %v", order.ID, from, order.Status, name, err)
+            }
+        }
+    }(definition.AfterCommit)
+}
+
+// RecordOrderEvent appends the order's move from `from` to its current status to its audit trail
+func RecordOrderEvent(tx *gorm.DB, order *models.Order, from string, meta TransitionMeta) error {
+    event := models.OrderEvent{
+        OrderID:    order.ID,
+        FromStatus: from,
+        ToStatus:   order.Status,
+        Actor:      meta.Actor,
+        Reason:     meta.Reason,
+        RemoteAddr: meta.RemoteAddr,
+        UserAgent:  meta.UserAgent,
+        RequestID:  meta.RequestID,
+    }
+    return tx.Create(&event).Error
+}
+
+// OrderHistory returns an order's status changes, oldest first
+func OrderHistory(tx *gorm.DB, orderID uint) ([]models.OrderEvent, error) {
+    var events []models.OrderEvent
+    err := tx.Where("order_id = ?", orderID).Order("created_at, id").Find(&events).Error
+    return events, err
 }
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// DefaultWorkflowFile is read at startup when WORKFLOW_FILE is not set. Files ending
+// in .yaml or .yml are read as YAML, anything else as JSON.
+const DefaultWorkflowFile = "workflows.json"
+
+// GuardFunc checks that an order may make a transition; a non-nil error blocks it
+type GuardFunc func(tx *gorm.DB, order *models.Order) error
+
+// HookFunc is a side effect run in the transition's transaction after its guards pass
+type HookFunc func(tx *gorm.DB, order *models.Order) error
+
+// AfterCommitFunc is a side effect run once the transition has been committed.
+// It cannot undo the transition, so failures are only logged.
+type AfterCommitFunc func(db *gorm.DB, order models.Order, from string) error
+
+// guardRegistry holds the guards a workflow file may name
+var guardRegistry = map[string]GuardFunc{
+	"has_logo":             hasLogo,
+	"has_mockup":           hasMockup,
+	"has_shipping_address": hasShippingAddress,
+	"stock_reserved":       stockReserved,
+	"deposit_received":     depositReceived,
+}
+
+// hookRegistry holds the hooks a workflow file may name
+var hookRegistry = map[string]HookFunc{
+	"reserve_stock":        ReserveForOrder,
+	"consume_stock":        ConsumeForOrder,
+	"release_stock":        ReleaseForOrder,
+	"consume_consumables":  ConsumeConsumablesForOrder,
+	"record_cost_of_goods": RecordOrderCostOfGoods,
+}
+
+// afterCommitRegistry holds the post-commit hooks a workflow file may name
+var afterCommitRegistry = map[string]AfterCommitFunc{
+	"send_email":    sendStatusEmail,
+	"enqueue_label": enqueueLabel,
+	"post_webhook":  postStatusWebhook,
+}
+
+// TransitionDefinition allows moving from any of From to To once every guard passes,
+// running the hooks in order and the after-commit hooks once the change is saved
+type TransitionDefinition struct {
+	From        []string `json:"from"`
+	To          string   `json:"to"`
+	Guards      []string `json:"guards"`
+	Hooks       []string `json:"hooks"`
+	AfterCommit []string `json:"afterCommit"`
+}
+
+// WorkflowDefinition is the state machine for a set of products. States lists the
+// forward path in order; exception states (hold, cancel, revision) sit off that path.
+// The workflow without products applies to every product not claimed by another.
+type WorkflowDefinition struct {
+	Name            string                 `json:"name"`
+	Products        []string               `json:"products"`
+	Initial         string                 `json:"initial"`
+	States          []string               `json:"states"`
+	ExceptionStates []string               `json:"exceptionStates"`
+	Transitions     []TransitionDefinition `json:"transitions"`
+}
+
+// WorkflowConfig is the contents of the workflow file
+type WorkflowConfig struct {
+	Workflows []WorkflowDefinition `json:"workflows"`
+}
+
+var ErrWorkflowsNotLoaded = errors.New("workflows have not been loaded")
+
+var (
+	defaultWorkflow  *WorkflowDefinition
+	productWorkflows map[string]*WorkflowDefinition
+	loadedWorkflows  []WorkflowDefinition
+)
+
+// WorkflowFile returns the workflow file path from WORKFLOW_FILE, or the default
+func WorkflowFile() string {
+	if path := os.Getenv("WORKFLOW_FILE"); path != "" {
+		return path
+	}
+	return DefaultWorkflowFile
+}
+
+// LoadWorkflows reads, validates and installs the workflow definitions in path
+func LoadWorkflows(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading workflow file: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if data, err = yamlToJSON(data); err != nil {
+			return fmt.Errorf("parsing workflow file %s: %w", path, err)
+		}
+	}
+
+	var config WorkflowConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("parsing workflow file %s: %w", path, err)
+	}
+	if err := validateWorkflows(config.Workflows); err != nil {
+		return fmt.Errorf("invalid workflow file %s: %w", path, err)
+	}
+
+	loadedWorkflows = config.Workflows
+	defaultWorkflow = nil
+	productWorkflows = make(map[string]*WorkflowDefinition)
+	for i := range loadedWorkflows {
+		workflow := &loadedWorkflows[i]
+		if len(workflow.Products) == 0 {
+			defaultWorkflow = workflow
+		}
+		for _, product := range workflow.Products {
+			productWorkflows[strings.ToLower(product)] = workflow
+		}
+	}
+	return nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so YAML workflow files get the same
+// strict decoding as JSON ones
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// Workflows returns the loaded workflow definitions
+func Workflows() []WorkflowDefinition {
+	return loadedWorkflows
+}
+
+// WorkflowFor returns the workflow that governs orders for product
+func WorkflowFor(product string) (*WorkflowDefinition, error) {
+	if workflow, ok := productWorkflows[strings.ToLower(product)]; ok {
+		return workflow, nil
+	}
+	if defaultWorkflow == nil {
+		return nil, ErrWorkflowsNotLoaded
+	}
+	return defaultWorkflow, nil
+}
+
+// InitialStatus returns the status new orders for product start in
+func InitialStatus(product string) (string, error) {
+	workflow, err := WorkflowFor(product)
+	if err != nil {
+		return "", err
+	}
+	return workflow.Initial, nil
+}
+
+// transitionTo returns the definition allowing a move from one status to another, if any
+func (w *WorkflowDefinition) transitionTo(from, to string) *TransitionDefinition {
+	for i, t := range w.Transitions {
+		if t.To != to {
+			continue
+		}
+		for _, f := range t.From {
+			if f == from {
+				return &w.Transitions[i]
+			}
+		}
+	}
+	return nil
+}
+
+// rank returns a status's position on the forward path, or -1 for exception states
+func (w *WorkflowDefinition) rank(status string) int {
+	for i, s := range w.States {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateWorkflows(workflows []WorkflowDefinition) error {
+	if len(workflows) == 0 {
+		return errors.New("no workflows defined")
+	}
+
+	names := make(map[string]bool)
+	products := make(map[string]string)
+	defaults := 0
+	for _, w := range workflows {
+		if w.Name == "" {
+			return errors.New("every workflow needs a name")
+		}
+		if names[w.Name] {
+			return fmt.Errorf("workflow %q is defined twice", w.Name)
+		}
+		names[w.Name] = true
+
+		if len(w.Products) == 0 {
+			defaults++
+		}
+		for _, product := range w.Products {
+			key := strings.ToLower(product)
+			if other, ok := products[key]; ok {
+				return fmt.Errorf("product %q is claimed by workflows %q and %q", product, other, w.Name)
+			}
+			products[key] = w.Name
+		}
+
+		if err := validateWorkflow(w); err != nil {
+			return fmt.Errorf("workflow %q: %w", w.Name, err)
+		}
+	}
+	if defaults != 1 {
+		return fmt.Errorf("exactly one workflow must have no products to act as the default, found %d", defaults)
+	}
+	return nil
+}
+
+func validateWorkflow(w WorkflowDefinition) error {
+	if len(w.States) == 0 {
+		return errors.New("no states defined")
+	}
+
+	states := make(map[string]bool)
+	for _, s := range append(append([]string{}, w.States...), w.ExceptionStates...) {
+		if s == "" {
+			return errors.New("state names cannot be empty")
+		}
+		if states[s] {
+			return fmt.Errorf("state %s is listed twice", s)
+		}
+		states[s] = true
+	}
+	if !states[w.Initial] {
+		return fmt.Errorf("initial state %q is not a declared state", w.Initial)
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range w.Transitions {
+		if !states[t.To] {
+			return fmt.Errorf("transition to unknown state %q", t.To)
+		}
+		if len(t.From) == 0 {
+			return fmt.Errorf("transition to %s has no source states", t.To)
+		}
+		for _, from := range t.From {
+			if !states[from] {
+				return fmt.Errorf("transition from unknown state %q", from)
+			}
+			key := from + "->" + t.To
+			if seen[key] {
+				return fmt.Errorf("transition %s is defined twice", key)
+			}
+			seen[key] = true
+		}
+		for _, guard := range t.Guards {
+			if _, ok := guardRegistry[guard]; !ok {
+				return fmt.Errorf("transition to %s uses unknown guard %q", t.To, guard)
+			}
+		}
+		for _, hook := range t.Hooks {
+			if _, ok := hookRegistry[hook]; !ok {
+				return fmt.Errorf("transition to %s uses unknown hook %q", t.To, hook)
+			}
+		}
+		for _, hook := range t.AfterCommit {
+			if _, ok := afterCommitRegistry[hook]; !ok {
+				return fmt.Errorf("transition to %s uses unknown after-commit hook %q", t.To, hook)
+			}
+		}
+	}
+	return nil
+}
+
+func hasLogo(tx *gorm.DB, order *models.Order) error {
+	missing, err := assetsMissing(tx, order, func(a models.Asset) string { return a.LogoURL })
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d of the order's designs have no logo", missing)
+	}
+	return nil
+}
+
+func hasMockup(tx *gorm.DB, order *models.Order) error {
+	missing, err := assetsMissing(tx, order, func(a models.Asset) string { return a.MockupURL })
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d of the order's designs have no mockup", missing)
+	}
+	return nil
+}
+
+// assetsMissing counts the order's assets where field is empty; an order without
+// assets counts as missing one
+func assetsMissing(tx *gorm.DB, order *models.Order, field func(models.Asset) string) (int64, error) {
+	assets, err := OrderAssets(tx, order.ID)
+	if err != nil {
+		return 0, err
+	}
+	if len(assets) == 0 {
+		return 1, nil
+	}
+	var missing int64
+	for _, asset := range assets {
+		if field(asset) == "" {
+			missing++
+		}
+	}
+	return missing, nil
+}
+
+func stockReserved(tx *gorm.DB, order *models.Order) error {
+	needed, err := orderQuantities(tx, order)
+	if err != nil {
+		return err
+	}
+	reservations, err := OutstandingReservations(tx, order.ID)
+	if err != nil {
+		return err
+	}
+	reserved := make(map[uint]int)
+	for _, r := range reservations {
+		reserved[r.InventoryItemID] += r.Quantity
+	}
+
+	short, total := 0, 0
+	for itemID, quantity := range needed {
+		total += quantity
+		if reserved[itemID] < quantity {
+			short += quantity - reserved[itemID]
+		}
+	}
+	if short > 0 {
+		return fmt.Errorf("order has %d of %d blanks reserved", total-short, total)
+	}
+	return nil
+}
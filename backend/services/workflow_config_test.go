@@ -0,0 +1,89 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"printflow/testutil"
+)
+
+// writeWorkflowFile writes contents to a workflow file named name in a temporary directory
+func writeWorkflowFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInvalidWorkflowFilesAreRejected(t *testing.T) {
+	cases := []struct {
+		name, contents, want string
+	}{
+		{"unknown guard", `{"workflows": [{"name": "w", "initial": "A", "states": ["A", "B"],
+			"transitions": [{"from": ["A"], "to": "B", "guards": ["has_paperwork"]}]}]}`, `unknown guard "has_paperwork"`},
+		{"unknown hook", `{"workflows": [{"name": "w", "initial": "A", "states": ["A", "B"],
+			"transitions": [{"from": ["A"], "to": "B", "hooks": ["print_it"]}]}]}`, `unknown hook "print_it"`},
+		{"unknown field", `{"workflows": [{"name": "w", "initial": "A", "states": ["A"], "stages": []}]}`, `unknown field "stages"`},
+		{"undeclared initial state", `{"workflows": [{"name": "w", "initial": "NEW", "states": ["A"]}]}`, `initial state "NEW"`},
+		{"transition to an unknown state", `{"workflows": [{"name": "w", "initial": "A", "states": ["A"],
+			"transitions": [{"from": ["A"], "to": "Z"}]}]}`, `unknown state "Z"`},
+		{"no default workflow", `{"workflows": [{"name": "w", "products": ["Cap"], "initial": "A", "states": ["A"]}]}`, "exactly one workflow"},
+		{"product claimed twice", `{"workflows": [
+			{"name": "a", "initial": "A", "states": ["A"]},
+			{"name": "b", "products": ["Cap"], "initial": "A", "states": ["A"]},
+			{"name": "c", "products": ["cap"], "initial": "A", "states": ["A"]}]}`, `product "cap" is claimed`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := LoadWorkflows(writeWorkflowFile(t, "workflows.json", tc.contents))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error mentioning %s", err, tc.want)
+			}
+		})
+	}
+
+	// A rejected file leaves the loaded workflows alone
+	if workflow, err := WorkflowFor("Cap"); err != nil || workflow.Name != "embroidery" {
+		t.Errorf("after rejected files Cap follows %v (%v), want embroidery", workflow, err)
+	}
+}
+
+func TestWorkflowsLoadFromYAML(t *testing.T) {
+	t.Cleanup(func() {
+		if err := LoadWorkflows(testutil.ConfigDir + DefaultWorkflowFile); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	path := writeWorkflowFile(t, "workflows.yaml", `
+workflows:
+  - name: simple
+    initial: CREATED
+    states: [CREATED, SHIPPED]
+    transitions:
+      - from: [CREATED]
+        to: SHIPPED
+        guards: [has_shipping_address]
+`)
+	if err := LoadWorkflows(path); err != nil {
+		t.Fatal(err)
+	}
+	workflow, err := WorkflowFor("T-Shirt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transition := workflow.transitionTo("CREATED", "SHIPPED")
+	if workflow.Name != "simple" || transition == nil || len(transition.Guards) != 1 {
+		t.Errorf("loaded %+v, want the simple workflow with a guarded CREATED -> SHIPPED", workflow)
+	}
+
+	// YAML files are decoded as strictly as JSON ones
+	bad := writeWorkflowFile(t, "workflows.yml", "workflows:\n  - name: simple\n    initial: CREATED\n    states: [CREATED]\n    stage: 1\n")
+	if err := LoadWorkflows(bad); err == nil || !strings.Contains(err.Error(), `unknown field "stage"`) {
+		t.Errorf("got %v, want the unknown field rejected", err)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"printflow/models"
+)
+
+// mockedUpOrder is an order for 10 blanks awaiting approval, with its mockup made
+func mockedUpOrder(t *testing.T, tx *gorm.DB) *models.Order {
+	t.Helper()
+	order := createTestOrder(t, tx, models.StatusMockupGenerated, 100)
+	asset := models.Asset{OrderID: order.ID, Product: order.Product, Color: order.Color, LogoURL: "/uploads/logo.png", MockupURL: "/mockups/mockup.png"}
+	if err := tx.Create(&asset).Error; err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+// stockBlanks receives quantity black T-Shirts in M at the main location
+func stockBlanks(t *testing.T, tx *gorm.DB, quantity int) uint {
+	t.Helper()
+	item, err := CreateInventoryItem(tx, "T-Shirt", "black", "M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReceiveStock(tx, item.ID, MovementInput{Quantity: quantity, UnitCost: 3}); err != nil {
+		t.Fatal(err)
+	}
+	return item.ID
+}
+
+func TestApprovalReservesAndFulfillmentConsumes(t *testing.T) {
+	tx := newTestDB(t)
+	itemID := stockBlanks(t, tx, 15)
+	meta := TransitionMeta{Actor: "test"}
+
+	first := mockedUpOrder(t, tx)
+	if err := ApplyTransition(tx, first, models.StatusApproved, meta); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 15, 10)
+
+	// A second order cannot be promised blanks that are already held
+	second := mockedUpOrder(t, tx)
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		return ApplyTransition(tx, second, models.StatusApproved, meta)
+	})
+	if !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("approving past available stock: got %v, want ErrInsufficientStock", err)
+	}
+	assertStock(t, tx, itemID, 15, 10)
+
+	if err := ApplyTransition(tx, first, models.StatusReady, meta); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 5, 0)
+	if reserved, _ := OutstandingReservations(tx, first.ID); len(reserved) != 0 {
+		t.Errorf("consumed order still holds %v", reserved)
+	}
+	if first.CostOfGoods != 30 {
+		t.Errorf("cost of goods %.2f, want 30", first.CostOfGoods)
+	}
+}
+
+func TestCancellingReleasesReservedStock(t *testing.T) {
+	tx := newTestDB(t)
+	itemID := stockBlanks(t, tx, 10)
+	meta := TransitionMeta{Actor: "test"}
+
+	order := mockedUpOrder(t, tx)
+	if err := ApplyTransition(tx, order, models.StatusApproved, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTransition(tx, order, models.StatusCancelled, meta); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 10, 0)
+}
+
+func TestHeldOrderResumesWhereItWasHeld(t *testing.T) {
+	tx := newTestDB(t)
+	meta := TransitionMeta{Actor: "test"}
+
+	order := mockedUpOrder(t, tx)
+	if err := ApplyTransition(tx, order, models.StatusOnHold, meta); err != nil {
+		t.Fatal(err)
+	}
+	if order.HeldFrom != models.StatusMockupGenerated {
+		t.Fatalf("held from %q, want %s", order.HeldFrom, models.StatusMockupGenerated)
+	}
+
+	// A held order only goes back where it was, not on along the workflow
+	if err := ApplyTransition(tx, order, models.StatusApproved, meta); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("approving a held order: got %v, want ErrInvalidTransition", err)
+	}
+	if err := ApplyTransition(tx, order, models.StatusMockupGenerated, meta); err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != models.StatusMockupGenerated || order.HeldFrom != "" {
+		t.Errorf("resumed to %s holding %q, want %s and nothing held", order.Status, order.HeldFrom, models.StatusMockupGenerated)
+	}
+}
+
+func TestRevisionReleasesStockForANewMockup(t *testing.T) {
+	tx := newTestDB(t)
+	itemID := stockBlanks(t, tx, 10)
+	meta := TransitionMeta{Actor: "test"}
+
+	order := mockedUpOrder(t, tx)
+	if err := ApplyTransition(tx, order, models.StatusApproved, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTransition(tx, order, models.StatusRevisionRequested, meta); err != nil {
+		t.Fatal(err)
+	}
+	assertStock(t, tx, itemID, 10, 0)
+
+	if err := ApplyTransition(tx, order, models.StatusApproved, meta); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("approving a rejected mockup: got %v, want ErrInvalidTransition", err)
+	}
+	if err := ApplyTransition(tx, order, models.StatusMockupGenerated, meta); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShippedOrdersCannotBeCancelledOrHeld(t *testing.T) {
+	tx := newTestDB(t)
+	for _, status := range []string{models.StatusShipped, models.StatusDelivered, models.StatusCancelled} {
+		order := createTestOrder(t, tx, status, 100)
+		for _, to := range []string{models.StatusCancelled, models.StatusOnHold} {
+			if err := Transition(order, to); !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("%s -> %s: got %v, want ErrInvalidTransition", status, to, err)
+			}
+		}
+	}
+}
+
+func TestPostProductionStepsRunInOrder(t *testing.T) {
+	tx := newTestDB(t)
+	meta := TransitionMeta{Actor: "test"}
+	order, _, _ := printingOrder(t, tx, 10)
+
+	if err := ApplyTransition(tx, order, models.StatusShipped, meta); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("shipping before printing: got %v, want ErrInvalidTransition", err)
+	}
+	for _, status := range []string{models.StatusPrinted, models.StatusPacked} {
+		if err := ApplyTransition(tx, order, status, meta); err != nil {
+			t.Fatalf("moving to %s: %v", status, err)
+		}
+	}
+
+	// Nothing ships without an address to ship to
+	var guardErr *GuardError
+	if err := ApplyTransition(tx, order, models.StatusShipped, meta); !errors.As(err, &guardErr) || guardErr.Failures[0].Guard != "has_shipping_address" {
+		t.Fatalf("shipping without an address: got %v, want has_shipping_address to block it", err)
+	}
+	address := models.CustomerAddress{Kind: models.AddressShipping, Address: "1 Main St", City: "Springfield", State: "IL", Zip: "62701"}
+	if err := tx.Create(&address).Error; err != nil {
+		t.Fatal(err)
+	}
+	order.ShippingAddressID = &address.ID
+
+	for _, status := range []string{models.StatusShipped, models.StatusDelivered} {
+		if err := ApplyTransition(tx, order, status, meta); err != nil {
+			t.Fatalf("moving to %s: %v", status, err)
+		}
+	}
+	if !ReachedStatus(order, models.StatusReady) || !ReachedStatus(order, models.StatusDelivered) {
+		t.Error("a delivered order has not reached READY_FOR_FULFILLMENT and DELIVERED")
+	}
+}
+
+func TestReachedStatusFollowsTheWorkflowPath(t *testing.T) {
+	cases := []struct {
+		status, heldFrom string
+		want             bool
+	}{
+		{models.StatusApproved, "", false},
+		{models.StatusReady, "", true},
+		{models.StatusPacked, "", true},
+		{models.StatusOnHold, models.StatusPrinted, true},
+		{models.StatusOnHold, models.StatusApproved, false},
+		{models.StatusCancelled, "", false},
+		{models.StatusRevisionRequested, "", false},
+	}
+	for _, tc := range cases {
+		order := &models.Order{Product: "T-Shirt", Status: tc.status, HeldFrom: tc.heldFrom}
+		if got := ReachedStatus(order, models.StatusReady); got != tc.want {
+			t.Errorf("%s (held from %q) reached %s: got %v, want %v", tc.status, tc.heldFrom, models.StatusReady, got, tc.want)
+		}
+	}
+}
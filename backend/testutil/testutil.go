@@ -0,0 +1,69 @@
+// Package testutil holds the database and fixtures shared by the package tests. It
+// only depends on db and models, so the services tests can use it too.
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"printflow/db"
+	"printflow/models"
+)
+
+// ConfigDir is where the package tests find workflows.json, pricing.json and tax.json
+const ConfigDir = "../"
+
+// NewDB opens an empty, migrated in-memory database of its own for one test
+func NewDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	database, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.Migrate(database); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+// CreateOrder saves an order with one line of 10 black T-Shirts in M, priced at total.
+// The line points at the SKU's inventory item when there is one, and the order at the
+// main location when it exists.
+func CreateOrder(t testing.TB, tx *gorm.DB, status string, total float64) *models.Order {
+	t.Helper()
+	var item models.InventoryItem
+	tx.Where("product = ? AND color = ? AND size = ?", "T-Shirt", "black", "M").Limit(1).Find(&item)
+	var location models.Location
+	tx.Where("code = ?", "MAIN").Limit(1).Find(&location)
+
+	order := models.Order{
+		Product:       "T-Shirt",
+		Color:         "black",
+		Size:          "M",
+		Status:        status,
+		LocationID:    location.ID,
+		Subtotal:      total,
+		Total:         total,
+		PaymentStatus: models.PaymentUnpaid,
+		Lines: []models.OrderLine{{
+			InventoryItemID: item.ID, Product: "T-Shirt", Color: "black", Size: "M",
+			Quantity: 10, Placements: "front", UnitPrice: total / 10, LineTotal: total,
+		}},
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &order
+}
@@ -9,6 +9,27 @@ type Order = {
   Color: string;
   Size: string;
   Status: string;
+  Rush: string;
+  RushFee: number;
+  DiscountTotal: number;
+  TaxTotal: number;
+  TaxCertificate: string;
+  Total: number;
+  PaymentStatus: string;
+  AmountPaid: number;
+  PricedAt: string | null;
+  Lines: OrderLine[] | null;
+};
+
+type OrderLine = {
+  ID: number;
+  Product: string;
+  Color: string;
+  Size: string;
+  Quantity: number;
+  Placements: string;
+  UnitPrice: number;
+  LineTotal: number;
 };
 
 type Asset = {
@@ -28,6 +49,8 @@ type OrderResponse = {
 export default function OrderDetail() {
   const { ID } = useParams<{ ID: string }>();
   const [orderData, setOrderData] = useState<OrderResponse | null>(null);
+  // The order's version, sent back as If-Match so stale changes are rejected
+  const [etag, setEtag] = useState("");
   const [mockupLoading, setMockupLoading] = useState(false);
   const [labelLoading, setLabelLoading] = useState(false);
 
@@ -42,7 +65,10 @@ export default function OrderDetail() {
 
   const load = () => {
     fetch(`${API}/orders/${ID}`)
-      .then(res => res.json())
+      .then(res => {
+        setEtag(res.headers.get("ETag") || "");
+        return res.json();
+      })
       .then(setOrderData);
   };
 
@@ -58,17 +84,18 @@ export default function OrderDetail() {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
+          "If-Match": etag,
         },
-        body: JSON.stringify({
-          logoUrl: orderData.asset?.LogoURL || "",
-          aiPrompt: orderData.asset?.AIPrompt || "",
-          useAI: orderData.asset?.AIGenerated || false,
-        }),
+        // An empty body renders each design with the artwork stored on the order
+        body: JSON.stringify({}),
       });
 
       if (response.ok) {
         // Reload the order data to show the new mockup
         load();
+      } else if (response.status === 409) {
+        alert("This order was changed by someone else. Reloading the latest version.");
+        load();
       } else {
         const errorData = await response.json();
         alert(`Failed to generate mockup: ${errorData.error || 'Unknown error'}`);
@@ -81,7 +108,26 @@ export default function OrderDetail() {
   };
 
   const approve = async () => {
-    await fetch(`${API}/orders/${ID}/approve`, { method: "POST" });
+    const res = await fetch(`${API}/orders/${ID}/approve`, {
+      method: "POST",
+      headers: { "If-Match": etag },
+    });
+    if (res.status === 409) {
+      const errorData = await res.json();
+      alert(`Could not approve order: ${errorData.error || 'Unknown error'}`);
+    }
+    load();
+  };
+
+  const markReady = async () => {
+    const res = await fetch(`${API}/orders/${ID}/ready`, {
+      method: "POST",
+      headers: { "If-Match": etag },
+    });
+    if (!res.ok) {
+      const errorData = await res.json();
+      alert(`Failed to release order: ${errorData.error || 'Unknown error'}`);
+    }
     load();
   };
 
@@ -127,14 +173,30 @@ export default function OrderDetail() {
         border: "1px solid #e5e7eb"
       }}>
         <div style={{ marginBottom: 8 }}>
-          <strong>Product:</strong> {order.Product}
-        </div>
-        <div style={{ marginBottom: 8 }}>
-          <strong>Color:</strong> {order.Color}
-        </div>
-        <div style={{ marginBottom: 8 }}>
-          <strong>Size:</strong> {order.Size}
+          <strong>Items:</strong>
+          <ul style={{ margin: "4px 0 0", paddingLeft: 20 }}>
+            {(order.Lines || []).map((line) => (
+              <li key={line.ID}>
+                {line.Quantity} x {line.Product} {line.Color} {line.Size}
+                <span style={{ color: "#6b7280" }}> ({line.Placements})</span>
+                {order.PricedAt && (
+                  <span> @ ${line.UnitPrice.toFixed(2)} = ${line.LineTotal.toFixed(2)}</span>
+                )}
+              </li>
+            ))}
+          </ul>
         </div>
+        {order.PricedAt && (
+          <div style={{ marginBottom: 8 }}>
+            <strong>Total:</strong> ${order.Total.toFixed(2)}
+            {order.DiscountTotal > 0 && <span style={{ color: "#6b7280" }}> (after ${order.DiscountTotal.toFixed(2)} discount)</span>}
+            {order.Rush && <span style={{ color: "#6b7280" }}> (incl. {order.Rush} fee ${order.RushFee.toFixed(2)})</span>}
+            {order.TaxTotal > 0 && <span style={{ color: "#6b7280" }}> (incl. ${order.TaxTotal.toFixed(2)} tax)</span>}
+            {order.TaxCertificate && <span style={{ color: "#6b7280" }}> (tax exempt, certificate {order.TaxCertificate})</span>}
+            <span style={{ color: "#6b7280" }}> · {order.PaymentStatus.replace("_", " ").toLowerCase()}, ${order.AmountPaid.toFixed(2)} paid</span>
+            {" "}<a href={`${API}/orders/${order.ID}/invoice`} target="_blank" rel="noreferrer">Invoice</a>
+          </div>
+        )}
         <div style={{ marginBottom: 8 }}>
           <strong>Status:</strong> <span style={{ 
             padding: "4px 8px", 
@@ -297,6 +359,25 @@ export default function OrderDetail() {
         </button>
       )}
 
+      {order.Status === "APPROVED" && (
+        <button
+          onClick={markReady}
+          style={{
+            display: "block",
+            marginBottom: 20,
+            padding: "12px 20px",
+            background: "#3B82F6",
+            color: "white",
+            border: "none",
+            borderRadius: 8,
+            fontWeight: 500,
+            cursor: "pointer"
+          }}
+        >
+          Release to Production
+        </button>
+      )}
+
       <div style={{ 
         backgroundColor: "#f9fafb", 
         padding: 16, 
@@ -48,29 +48,61 @@ function StatusBadge({ status }: { status: string }) {
 }
 
 
+type OrderPage = {
+  orders: Order[];
+  total: number;
+  limit: number;
+  offset: number;
+};
+
+const PAGE_SIZE = 25;
+
 export default function Orders() {
   const [ordersWithAssets, setOrdersWithAssets] = useState<OrderWithAsset[]>([]);
+  const [total, setTotal] = useState(0);
+  const [offset, setOffset] = useState(0);
+  const [search, setSearch] = useState("");
 
   useEffect(() => {
-    // First get all orders
-    fetch(`${API}/orders`)
+    // First get a page of orders
+    const params = new URLSearchParams({ limit: String(PAGE_SIZE), offset: String(offset) });
+    if (search) params.set("q", search);
+    fetch(`${API}/orders?${params}`)
       .then(res => res.json())
-      .then((ordersList: Order[]) => {
-        // Then fetch each order with its asset details
+      .then((page: OrderPage) => {
+        setTotal(page.total);
+        // Then fetch each order on the page with its asset details
         Promise.all(
-          ordersList.map(order => 
+          page.orders.map(order => 
             fetch(`${API}/orders/${order.ID}`)
               .then(res => res.json())
               .catch(() => ({ order, asset: null }))
           )
         ).then(setOrdersWithAssets);
       });
-  }, []);
+  }, [offset, search]);
 
   return (
     <div style={{ maxWidth: 1000, margin: "0 auto" }}>
       <h2 style={{ marginBottom: 24, color: "#111827" }}>Orders</h2>
 
+      <input
+        type="search"
+        placeholder="Search customer or AI prompt"
+        value={search}
+        onChange={(e) => {
+          setSearch(e.target.value);
+          setOffset(0);
+        }}
+        style={{ marginBottom: 16, padding: "8px 12px", width: 280, borderRadius: 6, border: "1px solid #d1d5db" }}
+      />
+      <a
+        href={`${API}/orders/export?${new URLSearchParams(search ? { q: search } : {})}`}
+        style={{ marginLeft: 12, color: "#2563eb", fontSize: 14 }}
+      >
+        Export CSV
+      </a>
+
       <div style={{ 
         backgroundColor: "white", 
         borderRadius: 12, 
@@ -162,6 +194,20 @@ export default function Orders() {
           </tbody>
         </table>
       </div>
+
+      <div style={{ display: "flex", justifyContent: "space-between", alignItems: "center", marginTop: 16, fontSize: 14 }}>
+        <span style={{ color: "#6b7280" }}>
+          {total === 0 ? "No orders" : `${offset + 1}–${Math.min(offset + PAGE_SIZE, total)} of ${total}`}
+        </span>
+        <div style={{ display: "flex", gap: 8 }}>
+          <button disabled={offset === 0} onClick={() => setOffset(Math.max(0, offset - PAGE_SIZE))}>
+            Previous
+          </button>
+          <button disabled={offset + PAGE_SIZE >= total} onClick={() => setOffset(offset + PAGE_SIZE)}>
+            Next
+          </button>
+        </div>
+      </div>
     </div>
   );
 }